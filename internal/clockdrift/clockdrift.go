@@ -0,0 +1,79 @@
+// Package clockdrift estimates the clock offset of producers from the times of
+// the events they send, so that events from devices with a skewed clock can be
+// recognized and corrected.
+package clockdrift
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// smoothing is the weight of a new sample in a rolling estimate once it is
+// based on enough samples. Until then samples are averaged.
+const smoothing = 0.1
+
+// Estimate is the rolling clock offset of one producer.
+type Estimate struct {
+	// Offset is the difference between event and receive time. Clocks that
+	// run ahead have a positive offset.
+	Offset time.Duration
+	// Deviation is the average absolute difference between samples and Offset.
+	Deviation time.Duration
+	// Samples is the number of events the estimate is based on.
+	Samples int
+}
+
+// Stable reports whether the estimate is based on at least minSamples events
+// whose offsets deviate by at most maxDeviation on average.
+func (e Estimate) Stable(minSamples int, maxDeviation time.Duration) bool {
+	return e.Samples >= minSamples && e.Deviation <= maxDeviation
+}
+
+func (e Estimate) add(offset time.Duration) Estimate {
+	e.Samples++
+	weight := max(smoothing, 1/float64(e.Samples))
+	deviation := offset - e.Offset
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	if e.Samples > 1 {
+		e.Deviation += time.Duration(weight * float64(deviation-e.Deviation))
+	}
+	e.Offset += time.Duration(weight * float64(offset-e.Offset))
+	return e
+}
+
+// Tracker keeps the estimates of the most recently seen producers. It is safe
+// for concurrent use.
+type Tracker struct {
+	mu        sync.Mutex
+	estimates *lru.Cache[string, Estimate]
+}
+
+// NewTracker creates a tracker of at most size producers.
+func NewTracker(size int) (*Tracker, error) {
+	estimates, err := lru.New[string, Estimate](size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create estimate cache: %w", err)
+	}
+	return &Tracker{estimates: estimates}, nil
+}
+
+// Observe adds the offset of an event to the estimate of its producer and
+// returns the updated estimate.
+func (t *Tracker) Observe(producer string, offset time.Duration) Estimate {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	estimate, _ := t.estimates.Get(producer)
+	estimate = estimate.add(offset)
+	t.estimates.Add(producer, estimate)
+	return estimate
+}
+
+// Get returns the estimate of a producer.
+func (t *Tracker) Get(producer string) (Estimate, bool) {
+	return t.estimates.Peek(producer)
+}
@@ -0,0 +1,104 @@
+package clockdrift
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObserve(t *testing.T) {
+	tests := []struct {
+		name           string
+		offsets        []time.Duration
+		expectedOffset time.Duration
+		stable         bool
+	}{
+		{
+			name:           "constant offset",
+			offsets:        repeat(time.Hour, 30),
+			expectedOffset: time.Hour,
+			stable:         true,
+		},
+		{
+			name:           "small jitter",
+			offsets:        alternate(-time.Minute-time.Second, -time.Minute+time.Second, 30),
+			expectedOffset: -time.Minute,
+			stable:         true,
+		},
+		{
+			name:           "too few samples",
+			offsets:        repeat(time.Hour, 5),
+			expectedOffset: time.Hour,
+		},
+		{
+			name:           "buffered uploads",
+			offsets:        alternate(-time.Hour, 0, 30),
+			expectedOffset: -30 * time.Minute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker, err := NewTracker(10)
+			require.NoError(t, err)
+			var estimate Estimate
+			for _, offset := range tt.offsets {
+				estimate = tracker.Observe("producer", offset)
+			}
+			assert.Equal(t, len(tt.offsets), estimate.Samples)
+			assert.InDelta(t, tt.expectedOffset, estimate.Offset, float64(10*time.Minute))
+			assert.Equal(t, tt.stable, estimate.Stable(10, 5*time.Second))
+
+			stored, ok := tracker.Get("producer")
+			require.True(t, ok)
+			assert.Equal(t, estimate, stored)
+		})
+	}
+}
+
+func TestOffsetChange(t *testing.T) {
+	tracker, err := NewTracker(10)
+	require.NoError(t, err)
+	for range 30 {
+		tracker.Observe("producer", time.Hour)
+	}
+	// A corrected clock makes the estimate unstable.
+	estimate := tracker.Observe("producer", 0)
+	assert.False(t, estimate.Stable(10, 5*time.Second))
+}
+
+func TestTrackerSize(t *testing.T) {
+	tracker, err := NewTracker(2)
+	require.NoError(t, err)
+	tracker.Observe("a", time.Second)
+	tracker.Observe("b", time.Second)
+	tracker.Observe("c", time.Second)
+
+	_, ok := tracker.Get("a")
+	assert.False(t, ok)
+	_, ok = tracker.Get("c")
+	assert.True(t, ok)
+
+	_, err = NewTracker(0)
+	require.Error(t, err)
+}
+
+func repeat(offset time.Duration, n int) []time.Duration {
+	offsets := make([]time.Duration, n)
+	for i := range offsets {
+		offsets[i] = offset
+	}
+	return offsets
+}
+
+func alternate(a, b time.Duration, n int) []time.Duration {
+	offsets := make([]time.Duration, n)
+	for i := range offsets {
+		offsets[i] = a
+		if i%2 == 1 {
+			offsets[i] = b
+		}
+	}
+	return offsets
+}
@@ -0,0 +1,169 @@
+// Package asyncticket lets HTTP clients hand off a message for asynchronous
+// processing. The request is answered with 202 and a ticket as soon as its
+// CloudEvent headers are valid, and the processing outcome can be queried with
+// the ticket.
+package asyncticket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/cloudeventconvert"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/google/uuid"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// TicketKey is the metadata key holding the ticket of an asynchronously processed message.
+	TicketKey = "dimo_async_ticket"
+	// QueuedContent marks a message emitted by the queue input that has not been restored yet.
+	QueuedContent = "dimo_content_async"
+	// ResponseContent marks a message that has been turned into the response of an
+	// async request or ticket query and only needs to be sent back.
+	ResponseContent = "dimo_async_response"
+
+	originalContentKey = "dimo_async_content"
+	responseStatusKey  = "response_status"
+	preferKey          = "Prefer"
+	verbKey            = "http_server_verb"
+	ticketQueryKey     = "ticket"
+	respondAsync       = "respond-async"
+)
+
+var errQueueNotFound = errors.New("async processing is not enabled")
+
+type processor struct {
+	queueName string
+	mgr       *service.Resources
+}
+
+func newProcessor(queueName string, mgr *service.Resources) *processor {
+	return &processor{queueName: queueName, mgr: mgr}
+}
+
+// Close to fulfill the service.Processor interface.
+func (*processor) Close(context.Context) error {
+	return nil
+}
+
+// ProcessBatch to fulfill the service.BatchProcessor interface.
+func (p *processor) ProcessBatch(ctx context.Context, msgs service.MessageBatch) ([]service.MessageBatch, error) {
+	for _, msg := range msgs {
+		content, _ := msg.MetaGet(processors.MessageContentKey)
+		if content == QueuedContent {
+			original, _ := msg.MetaGet(originalContentKey)
+			msg.MetaSetMut(processors.MessageContentKey, original)
+			msg.MetaDelete(originalContentKey)
+			continue
+		}
+		source, ok := msg.MetaGet(httpinputserver.DIMOCloudEventSource)
+		if !ok {
+			continue
+		}
+		if verb, _ := msg.MetaGet(verbKey); verb == http.MethodGet {
+			if err := p.query(msg, source); err != nil {
+				processors.SetError(msg, processorName, "failed to query ticket", err)
+			}
+			continue
+		}
+		if prefer, _ := msg.MetaGet(preferKey); !prefersAsync(prefer) {
+			continue
+		}
+		if err := p.accept(ctx, msg, source, content); err != nil {
+			processors.SetError(msg, processorName, "failed to accept message", err)
+		}
+	}
+	return []service.MessageBatch{msgs}, nil
+}
+
+// accept queues a copy of msg for processing and turns msg into the 202
+// response. Messages that fail the checks of the CloudEvent conversion are
+// rejected without a ticket, so the client learns about them synchronously.
+func (p *processor) accept(ctx context.Context, msg *service.Message, source, content string) error {
+	queue, err := p.queue()
+	if err != nil {
+		return err
+	}
+	msgBytes, err := msg.AsBytes()
+	if err != nil {
+		return fmt.Errorf("failed to get message as bytes: %w", err)
+	}
+	// The conversion is kept with the message, so it is not repeated when processed.
+	if _, err := cloudeventconvert.Convert(ctx, msg); err != nil {
+		return fmt.Errorf("invalid cloud event: %w", err)
+	}
+
+	ticket := Ticket{
+		ID:         uuid.New().String(),
+		State:      StatePending,
+		AcceptedAt: time.Now().UTC(),
+		source:     source,
+	}
+	// The queued message is detached from the request so the request can be
+	// answered before it is processed.
+	queued := service.NewMessage(msgBytes)
+	_ = msg.MetaWalkMut(func(key string, value any) error {
+		queued.MetaSetMut(key, value)
+		return nil
+	})
+	queued.MetaDelete(preferKey)
+	queued.MetaSetMut(TicketKey, ticket.ID)
+	queued.MetaSetMut(originalContentKey, content)
+	queued.MetaSetMut(processors.MessageContentKey, QueuedContent)
+	if err := queue.submit(ticket, queued); err != nil {
+		return err
+	}
+	msg.MetaSetMut(TicketKey, ticket.ID)
+	return setResponse(msg, http.StatusAccepted, ticket)
+}
+
+// query turns msg into the response to a ticket status query.
+func (p *processor) query(msg *service.Message, source string) error {
+	queue, err := p.queue()
+	if err != nil {
+		return err
+	}
+	id, _ := msg.MetaGet(ticketQueryKey)
+	ticket, ok := queue.lookup(id, source)
+	if !ok {
+		return setResponse(msg, http.StatusNotFound, map[string]string{"error": "ticket not found"})
+	}
+	return setResponse(msg, http.StatusOK, ticket)
+}
+
+func (p *processor) queue() (*ticketQueue, error) {
+	queue, ok := p.mgr.GetGeneric(queueKey(p.queueName))
+	if !ok {
+		return nil, fmt.Errorf("%w: no %s input named %q", errQueueNotFound, inputName, p.queueName)
+	}
+	return queue.(*ticketQueue), nil
+}
+
+func setResponse(msg *service.Message, status int, body any) error {
+	respBytes, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+	msg.SetBytes(respBytes)
+	msg.MetaSetMut(responseStatusKey, status)
+	msg.MetaSetMut(processors.MessageContentKey, ResponseContent)
+	return nil
+}
+
+// prefersAsync reports whether a Prefer header asks for an asynchronous response. [RFC 7240]
+func prefersAsync(prefer string) bool {
+	for _, preference := range strings.Split(prefer, ",") {
+		token, _, _ := strings.Cut(preference, ";")
+		if strings.EqualFold(strings.TrimSpace(token), respondAsync) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package asyncticket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testSource  = "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b"
+	otherSource = "0x5E57000000000000000000000000000000000B1A"
+)
+
+const (
+	statusData  = `{"signals":[{"name":"speed","timestamp":"2025-01-01T00:00:00Z","value":1}]}`
+	eventsData  = `{"events":[{"name":"harshBraking","timestamp":"2025-01-01T00:00:00Z"}]}`
+	unknownData = `{}`
+)
+
+// connectionEvent returns a connection payload in the CloudEvent format of the
+// default module, which infers the event type from data.
+func connectionEvent(id, data string) string {
+	return fmt.Sprintf(`{"id":%q,"specversion":"1.0","subject":"did:erc721:137:0xbA5738a18d83D41847dfFbDC6101d37C69c9B0cF:1","producer":"did:erc721:137:0x9c94C395cBcBDe662235E0A9d3bB87Ad708561BA:1","time":%q,"data":%s}`,
+		id, time.Now().UTC().Format(time.RFC3339), data)
+}
+
+func newRequest(body, verb, prefer, source string) *service.Message {
+	msg := service.NewMessage([]byte(body))
+	msg.MetaSetMut(httpinputserver.DIMOCloudEventSource, source)
+	msg.MetaSetMut(processors.MessageContentKey, httpinputserver.ConnectionContent)
+	msg.MetaSetMut(verbKey, verb)
+	msg.MetaSetMut("Content-Type", "application/json")
+	if prefer != "" {
+		msg.MetaSetMut(preferKey, prefer)
+	}
+	return msg
+}
+
+func process(t *testing.T, proc *processor, msg *service.Message) *service.Message {
+	t.Helper()
+	batches, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.Len(t, batches, 1)
+	require.Len(t, batches[0], 1)
+	return batches[0][0]
+}
+
+func queryTicket(t *testing.T, proc *processor, id, source string) (int, Ticket) {
+	t.Helper()
+	msg := newRequest("", http.MethodGet, "", source)
+	msg.MetaSetMut(ticketQueryKey, id)
+	out := process(t, proc, msg)
+	require.NoError(t, out.GetError())
+	status, _ := out.MetaGetMut(responseStatusKey)
+	body, err := out.AsBytes()
+	require.NoError(t, err)
+	var ticket Ticket
+	require.NoError(t, json.Unmarshal(body, &ticket))
+	return status.(int), ticket
+}
+
+func TestAsyncTicket(t *testing.T) {
+	mgr := service.MockResources()
+	queue := newTicketQueue(2, 10, time.Minute, mgr)
+	mgr.SetGeneric(queueKey("default"), queue)
+	in := &input{queue: queue}
+	proc := newProcessor("default", mgr)
+	ctx := context.Background()
+
+	tests := []struct {
+		name          string
+		body          string
+		pipelineErr   string
+		ackErr        error
+		expectedState string
+		expectedCode  int
+	}{
+		{name: "succeeded", body: connectionEvent("1", statusData), expectedState: StateSucceeded, expectedCode: http.StatusOK},
+		{name: "conversion error", body: connectionEvent("2", statusData), pipelineErr: "failed to convert to cloudevent", expectedState: StateRejected, expectedCode: http.StatusBadRequest},
+		{name: "storage failure", body: connectionEvent("3", statusData), ackErr: errors.New("clickhouse unavailable"), expectedState: StateFailed, expectedCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := process(t, proc, newRequest(tt.body, http.MethodPost, "respond-async, wait=5", testSource))
+			require.NoError(t, resp.GetError())
+			status, _ := resp.MetaGetMut(responseStatusKey)
+			assert.Equal(t, http.StatusAccepted, status)
+			content, _ := resp.MetaGet(processors.MessageContentKey)
+			assert.Equal(t, ResponseContent, content)
+			var accepted Ticket
+			respBytes, err := resp.AsBytes()
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(respBytes, &accepted))
+			assert.Equal(t, StatePending, accepted.State)
+
+			batch, ack, err := in.ReadBatch(ctx)
+			require.NoError(t, err)
+			queued := process(t, proc, batch[0])
+			content, _ = queued.MetaGet(processors.MessageContentKey)
+			assert.Equal(t, httpinputserver.ConnectionContent, content)
+			ticketID, _ := queued.MetaGet(TicketKey)
+			assert.Equal(t, accepted.ID, ticketID)
+			queuedBytes, err := queued.AsBytes()
+			require.NoError(t, err)
+			assert.Equal(t, tt.body, string(queuedBytes))
+
+			code, ticket := queryTicket(t, proc, accepted.ID, testSource)
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, StatePending, ticket.State)
+
+			if tt.pipelineErr != "" {
+				queued.MetaSetMut(responseStatusKey, http.StatusBadRequest)
+				queued.SetBytes([]byte(tt.pipelineErr))
+				require.NoError(t, queued.AddSyncResponse())
+			}
+			require.NoError(t, ack(ctx, tt.ackErr))
+
+			code, ticket = queryTicket(t, proc, accepted.ID, testSource)
+			assert.Equal(t, http.StatusOK, code)
+			assert.Equal(t, tt.expectedState, ticket.State)
+			assert.Equal(t, tt.expectedCode, ticket.Status)
+			assert.NotNil(t, ticket.CompletedAt)
+
+			code, _ = queryTicket(t, proc, accepted.ID, otherSource)
+			assert.Equal(t, http.StatusNotFound, code)
+		})
+	}
+
+	t.Run("synchronous requests pass through", func(t *testing.T) {
+		out := process(t, proc, newRequest(connectionEvent("4", statusData), http.MethodPost, "", testSource))
+		require.NoError(t, out.GetError())
+		content, _ := out.MetaGet(processors.MessageContentKey)
+		assert.Equal(t, httpinputserver.ConnectionContent, content)
+	})
+
+	t.Run("rejected before a ticket is issued", func(t *testing.T) {
+		t.Cleanup(func() { sourceconfig.Set(&sourceconfig.Registry{}) })
+		sourceconfig.Set(&sourceconfig.Registry{Sources: map[string]sourceconfig.Overrides{
+			testSource: {AllowedTypes: []string{"dimo.status"}},
+		}})
+		tickets := queue.tickets.Len()
+		for name, body := range map[string]string{
+			"invalid json":     `{"id":`,
+			"invalid header":   connectionEvent("bad id!", statusData),
+			"unsupported type": connectionEvent("5", unknownData),
+			"type not allowed": connectionEvent("6", eventsData),
+		} {
+			out := process(t, proc, newRequest(body, http.MethodPost, respondAsync, testSource))
+			require.Error(t, out.GetError(), name)
+			content, _ := out.MetaGet(processors.MessageContentKey)
+			assert.Equal(t, httpinputserver.ConnectionContent, content, name)
+			_, hasTicket := out.MetaGet(TicketKey)
+			assert.False(t, hasTicket, name)
+		}
+		assert.Empty(t, queue.msgs)
+		assert.Equal(t, tickets, queue.tickets.Len())
+	})
+
+	t.Run("queue full", func(t *testing.T) {
+		for i := range 2 {
+			out := process(t, proc, newRequest(connectionEvent(fmt.Sprint(10+i), statusData), http.MethodPost, respondAsync, testSource))
+			require.NoError(t, out.GetError())
+		}
+		out := process(t, proc, newRequest(connectionEvent("12", statusData), http.MethodPost, respondAsync, testSource))
+		require.ErrorIs(t, out.GetError(), errQueueFull)
+	})
+}
+
+func TestPrefersAsync(t *testing.T) {
+	tests := []struct {
+		prefer string
+		want   bool
+	}{
+		{prefer: "respond-async", want: true},
+		{prefer: "Respond-Async", want: true},
+		{prefer: "return=minimal, respond-async; foo=bar", want: true},
+		{prefer: "return=minimal", want: false},
+		{prefer: "", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, prefersAsync(tt.prefer), tt.prefer)
+	}
+}
@@ -0,0 +1,71 @@
+package asyncticket
+
+import (
+	"fmt"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	processorName            = "dimo_async_ticket"
+	inputName                = "dimo_async_ticket_queue"
+	queueFieldName           = "queue"
+	maxQueuedFieldName       = "max_queued"
+	maxTicketsFieldName      = "max_tickets"
+	ticketRetentionFieldName = "ticket_retention"
+)
+
+var processorConfigSpec = service.NewConfigSpec().
+	Summary("Accepts messages of requests that prefer an asynchronous response with 202 and a ticket, and answers ticket status queries. Accepted messages are handed to the dimo_async_ticket_queue input with the same queue name.").
+	Field(service.NewStringField(queueFieldName).Default("default").Description("Name of the dimo_async_ticket_queue input that processes accepted messages."))
+
+var inputConfigSpec = service.NewConfigSpec().
+	Summary("Emits messages accepted by dimo_async_ticket and records the processing outcome of each under its ticket.").
+	Field(service.NewStringField(queueFieldName).Default("default").Description("Name of the queue, referenced by dimo_async_ticket processors.")).
+	Field(service.NewIntField(maxQueuedFieldName).Default(10000).Description("Maximum number of accepted messages waiting to be processed. Requests are rejected with 503 while the queue is full.")).
+	Field(service.NewIntField(maxTicketsFieldName).Default(100000).Description("Maximum number of tickets kept. The oldest tickets are dropped first.")).
+	Field(service.NewDurationField(ticketRetentionFieldName).Default("1h").Description("How long a ticket can be queried after it was issued."))
+
+func init() {
+	err := service.RegisterBatchProcessor(processorName, processorConfigSpec, processorCtor)
+	if err != nil {
+		panic(err)
+	}
+	err = service.RegisterBatchInput(inputName, inputConfigSpec, inputCtor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func processorCtor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchProcessor, error) {
+	name, err := cfg.FieldString(queueFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", queueFieldName, err)
+	}
+	return newProcessor(name, mgr), nil
+}
+
+func inputCtor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+	name, err := cfg.FieldString(queueFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", queueFieldName, err)
+	}
+	maxQueued, err := cfg.FieldInt(maxQueuedFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", maxQueuedFieldName, err)
+	}
+	maxTickets, err := cfg.FieldInt(maxTicketsFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", maxTicketsFieldName, err)
+	}
+	retention, err := cfg.FieldDuration(ticketRetentionFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ticketRetentionFieldName, err)
+	}
+	if maxQueued <= 0 || maxTickets <= 0 {
+		return nil, fmt.Errorf("%s and %s must be positive", maxQueuedFieldName, maxTicketsFieldName)
+	}
+	queue := newTicketQueue(maxQueued, maxTickets, retention, mgr)
+	mgr.SetGeneric(queueKey(name), queue)
+	return &input{queue: queue}, nil
+}
@@ -0,0 +1,131 @@
+package asyncticket
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// Ticket states.
+const (
+	StatePending   = "pending"
+	StateSucceeded = "succeeded"
+	StateRejected  = "rejected"
+	StateFailed    = "failed"
+
+	// MetricTickets counts issued and completed tickets, labelled by state.
+	MetricTickets = "dis_async_tickets_total"
+)
+
+var errQueueFull = errors.New("async queue is full")
+
+// queueKey identifies a ticket queue in the shared resources.
+type queueKey string
+
+// Ticket is the processing status of an asynchronously accepted message.
+type Ticket struct {
+	ID string `json:"ticket"`
+	// State is one of pending, succeeded, rejected or failed.
+	State string `json:"state"`
+	// Status is the HTTP status a synchronous request would have received.
+	Status      int        `json:"status,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	AcceptedAt  time.Time  `json:"accepted_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+
+	source string
+}
+
+// ticketQueue holds accepted messages until the input emits them, and the
+// tickets of recently accepted messages.
+type ticketQueue struct {
+	msgs    chan *service.Message
+	tickets *expirable.LRU[string, Ticket]
+	counter *service.MetricCounter
+}
+
+func newTicketQueue(maxQueued, maxTickets int, retention time.Duration, mgr *service.Resources) *ticketQueue {
+	return &ticketQueue{
+		msgs:    make(chan *service.Message, maxQueued),
+		tickets: expirable.NewLRU[string, Ticket](maxTickets, nil, retention),
+		counter: mgr.Metrics().NewCounter(MetricTickets, "state"),
+	}
+}
+
+// submit issues a pending ticket for msg and queues it without blocking.
+func (q *ticketQueue) submit(ticket Ticket, msg *service.Message) error {
+	q.tickets.Add(ticket.ID, ticket)
+	select {
+	case q.msgs <- msg:
+		q.counter.Incr(1, StatePending)
+		return nil
+	default:
+		q.tickets.Remove(ticket.ID)
+		return errQueueFull
+	}
+}
+
+// complete records the outcome of a processed message. Messages are rejected
+// when the pipeline set an error sync response and failed when they could not
+// be delivered to the outputs.
+func (q *ticketQueue) complete(id string, store *service.SyncResponseStore, ackErr error) {
+	ticket, ok := q.tickets.Peek(id)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	ticket.CompletedAt = &now
+	switch status, reason := httpinputserver.SyncResponseStatus(store); {
+	case ackErr != nil:
+		ticket.State, ticket.Status, ticket.Error = StateFailed, http.StatusInternalServerError, ackErr.Error()
+	case status >= http.StatusMultipleChoices:
+		ticket.State, ticket.Status, ticket.Error = StateRejected, status, reason
+	default:
+		ticket.State, ticket.Status = StateSucceeded, status
+	}
+	q.counter.Incr(1, ticket.State)
+	q.tickets.Add(id, ticket)
+}
+
+// lookup returns the ticket if it was issued to source.
+func (q *ticketQueue) lookup(id, source string) (Ticket, bool) {
+	ticket, ok := q.tickets.Get(id)
+	if !ok || ticket.source != source {
+		return Ticket{}, false
+	}
+	return ticket, true
+}
+
+type input struct {
+	queue *ticketQueue
+}
+
+// Connect to fulfill the service.BatchInput interface.
+func (*input) Connect(context.Context) error {
+	return nil
+}
+
+// ReadBatch emits the next accepted message and records its outcome once acknowledged.
+func (i *input) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	select {
+	case msg := <-i.queue.msgs:
+		id, _ := msg.MetaGet(TicketKey)
+		msg, store := msg.WithSyncResponseStore()
+		return service.MessageBatch{msg}, func(_ context.Context, err error) error {
+			i.queue.complete(id, store, err)
+			return nil
+		}, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// Close to fulfill the service.BatchInput interface.
+func (*input) Close(context.Context) error {
+	return nil
+}
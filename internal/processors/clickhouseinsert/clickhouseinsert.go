@@ -0,0 +1,225 @@
+// Package clickhouseinsert provides an output that inserts rows into ClickHouse
+// with deterministic insert deduplication tokens.
+package clickhouseinsert
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// MetricInsertFailed counts batches that were not inserted, labelled by table and reason.
+	MetricInsertFailed = "dis_clickhouse_insert_failed_total"
+
+	reasonPermanent        = "permanent"
+	reasonRetriesExhausted = "retries_exhausted"
+)
+
+// permanentCodes are the ClickHouse error codes of rows that cannot be
+// inserted however often they are retried.
+var permanentCodes = map[int32]bool{
+	6:   true, // CANNOT_PARSE_TEXT
+	27:  true, // CANNOT_PARSE_INPUT_ASSERTION_FAILED
+	43:  true, // ILLEGAL_TYPE_OF_ARGUMENT
+	53:  true, // TYPE_MISMATCH
+	70:  true, // CANNOT_CONVERT_TYPE
+	117: true, // INCORRECT_DATA
+	131: true, // TOO_LARGE_STRING_SIZE
+	321: true, // VALUE_IS_OUT_OF_RANGE_OF_DATA_TYPE
+	349: true, // CANNOT_INSERT_NULL_IN_ORDINARY_COLUMN
+}
+
+// identifierPattern matches the table and column names that are accepted
+// without quoting.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+type output struct {
+	logger     *service.Logger
+	opts       *clickhouse.Options
+	table      string
+	query      string
+	dedupToken bool
+	// backoff is copied for every batch, since it is stateful.
+	backoff *backoff.ExponentialBackOff
+	failed  *service.MetricCounter
+
+	mu   sync.RWMutex
+	conn driver.Conn
+}
+
+// Connect to fulfill the service.BatchOutput interface.
+func (o *output) Connect(ctx context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn != nil {
+		return nil
+	}
+	conn, err := clickhouse.Open(o.opts)
+	if err != nil {
+		return fmt.Errorf("failed to open clickhouse connection: %w", err)
+	}
+	if err := conn.Ping(ctx); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to ping clickhouse: %w", err)
+	}
+	o.conn = conn
+	return nil
+}
+
+// WriteBatch inserts the rows of the batch in one insert, and retries it as
+// is while it fails transiently. Retrying here rather than in a retry output
+// keeps the batch and its deduplication token the same, so a retry of an
+// attempt that failed on the client side but succeeded on the server is
+// ignored. A batch that fails permanently, e.g. because a value does not fit
+// its column, is dropped, since retrying it would block every batch after it.
+func (o *output) WriteBatch(ctx context.Context, msgs service.MessageBatch) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	err := o.writeBatch(ctx, msgs)
+	var permanent *backoff.PermanentError
+	switch {
+	case err == nil || errors.Is(err, service.ErrNotConnected):
+		return err
+	case errors.As(err, &permanent):
+		o.failed.Incr(1, o.table, reasonPermanent)
+		o.logger.Errorf("Dropping batch of %d rows that cannot be inserted into %s: %v", len(msgs), o.table, permanent.Err)
+		return nil
+	default:
+		o.failed.Incr(1, o.table, reasonRetriesExhausted)
+		return err
+	}
+}
+
+func (o *output) writeBatch(ctx context.Context, msgs service.MessageBatch) error {
+	rows := make([][]any, len(msgs))
+	for i, msg := range msgs {
+		structured, err := msg.AsStructured()
+		if err != nil {
+			return backoff.Permanent(fmt.Errorf("failed to get row of message %d: %w", i, err))
+		}
+		row, ok := structured.([]any)
+		if !ok {
+			return backoff.Permanent(fmt.Errorf("message %d is not a row: %T", i, structured))
+		}
+		rows[i] = row
+	}
+
+	settings := clickhouse.Settings{}
+	if o.dedupToken {
+		token, err := dedupToken(o.table, rows)
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		settings["insert_deduplicate"] = 1
+		settings["insert_deduplication_token"] = token
+		settings["deduplicate_blocks_in_dependent_materialized_views"] = 1
+	}
+	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+
+	boff := *o.backoff
+	boff.Reset()
+	for {
+		err := o.insert(ctx, rows)
+		var permanent *backoff.PermanentError
+		if err == nil || errors.Is(err, service.ErrNotConnected) || errors.As(err, &permanent) {
+			return err
+		}
+		wait := boff.NextBackOff()
+		if wait == backoff.Stop {
+			return err
+		}
+		o.logger.Warnf("Retrying insert of %d rows into %s in %s: %v", len(rows), o.table, wait, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (o *output) insert(ctx context.Context, rows [][]any) error {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	if o.conn == nil {
+		return service.ErrNotConnected
+	}
+	batch, err := o.conn.PrepareBatch(ctx, o.query)
+	if err != nil {
+		return classify(fmt.Errorf("failed to prepare insert into %s: %w", o.table, err))
+	}
+	for i, row := range rows {
+		if err := batch.Append(row...); err != nil {
+			return backoff.Permanent(errors.Join(fmt.Errorf("failed to append row %d to insert into %s: %w", i, o.table, err), batch.Abort()))
+		}
+	}
+	if err := batch.Send(); err != nil {
+		return classify(fmt.Errorf("failed to insert into %s: %w", o.table, err))
+	}
+	return nil
+}
+
+// classify marks the error of an insert as permanent when ClickHouse rejected
+// the rows themselves, so that retrying it fails again.
+func classify(err error) error {
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) && permanentCodes[exception.Code] {
+		return backoff.Permanent(err)
+	}
+	return err
+}
+
+// Close to fulfill the service.BatchOutput interface.
+func (o *output) Close(context.Context) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.conn == nil {
+		return nil
+	}
+	err := o.conn.Close()
+	o.conn = nil
+	return err
+}
+
+// dedupToken returns the insert deduplication token of the rows of a table.
+// It only depends on the table and the values and order of the rows.
+func dedupToken(table string, rows [][]any) (string, error) {
+	hash := sha256.New()
+	hash.Write([]byte(table))
+	enc := json.NewEncoder(hash)
+	for i, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return "", fmt.Errorf("failed to hash row %d: %w", i, err)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// insertQuery returns the insert statement of a table and its columns.
+func insertQuery(table string, columns []string) (string, error) {
+	if !identifierPattern.MatchString(table) {
+		return "", fmt.Errorf("invalid table name: %s", table)
+	}
+	for _, column := range columns {
+		if !identifierPattern.MatchString(column) {
+			return "", fmt.Errorf("invalid column name: %s", column)
+		}
+	}
+	if len(columns) == 0 {
+		return "INSERT INTO " + table, nil
+	}
+	return "INSERT INTO " + table + " (" + strings.Join(columns, ", ") + ")", nil
+}
@@ -0,0 +1,155 @@
+package clickhouseinsert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupToken(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	row := func(name string, value float64) []any {
+		return vss.SignalToSlice(vss.Signal{Data: vss.SignalData{Name: name, Timestamp: ts, ValueNumber: value}})
+	}
+	speed, temp := row(vss.FieldSpeed, 50), row(vss.FieldExteriorAirTemperature, 20)
+	token, err := dedupToken("signal", [][]any{speed, temp})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		table     string
+		rows      [][]any
+		sameToken bool
+	}{
+		{name: "same rows", table: "signal", rows: [][]any{row(vss.FieldSpeed, 50), row(vss.FieldExteriorAirTemperature, 20)}, sameToken: true},
+		{name: "other order", table: "signal", rows: [][]any{temp, speed}},
+		{name: "other value", table: "signal", rows: [][]any{row(vss.FieldSpeed, 51), temp}},
+		{name: "other table", table: "event", rows: [][]any{speed, temp}},
+		{name: "fewer rows", table: "signal", rows: [][]any{speed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			other, err := dedupToken(tt.table, tt.rows)
+			require.NoError(t, err)
+			if tt.sameToken {
+				assert.Equal(t, token, other)
+			} else {
+				assert.NotEqual(t, token, other)
+			}
+		})
+	}
+}
+
+func TestInsertQuery(t *testing.T) {
+	tests := []struct {
+		name          string
+		table         string
+		columns       []string
+		expectedQuery string
+		expectError   bool
+	}{
+		{name: "all columns", table: "signal", expectedQuery: "INSERT INTO signal"},
+		{name: "some columns", table: "dimo.signal", columns: []string{"subject", "timestamp"}, expectedQuery: "INSERT INTO dimo.signal (subject, timestamp)"},
+		{name: "invalid table", table: "signal; DROP TABLE signal", expectError: true},
+		{name: "invalid column", table: "signal", columns: []string{"subject)"}, expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := insertQuery(tt.table, tt.columns)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedQuery, query)
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	conf, err := configSpec.ParseYAML(`
+dsn: clickhouse://localhost:9000/dimo?username=default&password=secret
+table: signal
+batching:
+  count: 100
+backoff:
+  max_elapsed_time: 1m
+`, nil)
+	require.NoError(t, err)
+	out, policy, maxInFlight, err := ctor(conf, service.MockResources())
+	require.NoError(t, err)
+	assert.Equal(t, 100, policy.Count)
+	assert.Equal(t, defaultMaxInFlight, maxInFlight)
+	o := out.(*output)
+	assert.Equal(t, "INSERT INTO signal", o.query)
+	assert.True(t, o.dedupToken)
+	assert.Equal(t, time.Minute, o.backoff.MaxElapsedTime)
+	assert.Equal(t, time.Second, o.backoff.InitialInterval)
+	require.NoError(t, out.Close(t.Context()))
+}
+
+func TestDefaultConfig(t *testing.T) {
+	conf, err := configSpec.ParseYAML(`
+dsn: clickhouse://localhost:9000/dimo
+table: signal
+`, nil)
+	require.NoError(t, err)
+	out, _, maxInFlight, err := ctor(conf, service.MockResources())
+	require.NoError(t, err)
+	assert.Equal(t, defaultMaxInFlight, maxInFlight)
+	o := out.(*output)
+	assert.True(t, o.dedupToken)
+	// A batch that keeps failing must not block the output forever.
+	assert.Equal(t, 5*time.Minute, o.backoff.MaxElapsedTime)
+	assert.Equal(t, time.Second, o.backoff.InitialInterval)
+	assert.Equal(t, 30*time.Second, o.backoff.MaxInterval)
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		permanent bool
+	}{
+		{name: "type mismatch", err: &clickhouse.Exception{Code: 53}, permanent: true},
+		{name: "unparsable value", err: fmt.Errorf("failed to insert into signal: %w", &clickhouse.Exception{Code: 6}), permanent: true},
+		{name: "too many parts", err: &clickhouse.Exception{Code: 252}},
+		{name: "unknown table", err: &clickhouse.Exception{Code: 60}},
+		{name: "network", err: errors.New("connection reset by peer")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var permanent *backoff.PermanentError
+			assert.Equal(t, tt.permanent, errors.As(classify(tt.err), &permanent))
+		})
+	}
+}
+
+func TestWriteBatchDropsInvalidRows(t *testing.T) {
+	conf, err := configSpec.ParseYAML(`
+dsn: clickhouse://localhost:9000/dimo
+table: signal
+`, nil)
+	require.NoError(t, err)
+	out, _, _, err := ctor(conf, service.MockResources())
+	require.NoError(t, err)
+
+	// The row cannot be inserted, so it is dropped without a connection.
+	err = out.WriteBatch(context.Background(), service.MessageBatch{service.NewMessage([]byte(`{"not":"a row"}`))})
+	require.NoError(t, err)
+
+	// Valid rows are not dropped while ClickHouse is unreachable.
+	msg := service.NewMessage(nil)
+	msg.SetStructured([]any{"subject", 1})
+	err = out.WriteBatch(context.Background(), service.MessageBatch{msg})
+	require.ErrorIs(t, err, service.ErrNotConnected)
+}
@@ -0,0 +1,98 @@
+package clickhouseinsert
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	outputName           = "dimo_clickhouse_insert"
+	dsnFieldName         = "dsn"
+	tableFieldName       = "table"
+	columnsFieldName     = "columns"
+	dedupTokenFieldName  = "dedup_token"
+	maxInFlightFieldName = "max_in_flight"
+	batchingFieldName    = "batching"
+	backoffFieldName     = "backoff"
+	defaultMaxInFlight   = 64
+)
+
+var configSpec = service.NewConfigSpec().
+	Summary("Inserts rows into a ClickHouse table. Every message must be structured as an array of column values, like those of dimo_signal_to_slice and dimo_event_to_slice.").
+	Field(service.NewStringField(dsnFieldName).Description("ClickHouse DSN, e.g. clickhouse://host:9440/dimo?username=default&secure=true.")).
+	Field(service.NewStringField(tableFieldName).Description("Table to insert into.")).
+	Field(service.NewStringListField(columnsFieldName).Default([]string{}).Description("Columns of the row values in order. Empty inserts into all columns of the table in order.")).
+	Field(service.NewBoolField(dedupTokenFieldName).Default(true).Description("Send an insert deduplication token derived from the table and rows of every batch, so that ClickHouse ignores a retry of a batch it already inserted, including in dependent materialized views. Only byte-identical batches share a token: the same rows batched differently, e.g. after a restart, are inserted again.")).
+	Field(service.NewIntField(maxInFlightFieldName).Default(defaultMaxInFlight).Description("Maximum number of batches inserted concurrently.")).
+	Field(service.NewBatchPolicyField(batchingFieldName)).
+	Field(service.NewBackOffField(backoffFieldName, true, defaultBackoff()).Description("Backoff between retries of a failed insert. A batch is retried as is, with the same deduplication token, until it is inserted or max_elapsed_time is reached, and then fails. A batch that ClickHouse rejects for its rows, e.g. a type mismatch, is not retried and is dropped."))
+
+func defaultBackoff() *backoff.ExponentialBackOff {
+	boff := backoff.NewExponentialBackOff()
+	boff.InitialInterval = time.Second
+	boff.MaxInterval = 30 * time.Second
+	boff.MaxElapsedTime = 5 * time.Minute
+	return boff
+}
+
+func init() {
+	err := service.RegisterBatchOutput(outputName, configSpec, ctor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func ctor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchOutput, service.BatchPolicy, int, error) {
+	dsn, err := cfg.FieldString(dsnFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", dsnFieldName, err)
+	}
+	opts, err := clickhouse.ParseDSN(dsn)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to parse %s: %w", dsnFieldName, err)
+	}
+	table, err := cfg.FieldString(tableFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", tableFieldName, err)
+	}
+	columns, err := cfg.FieldStringList(columnsFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", columnsFieldName, err)
+	}
+	query, err := insertQuery(table, columns)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, err
+	}
+	dedupToken, err := cfg.FieldBool(dedupTokenFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", dedupTokenFieldName, err)
+	}
+	maxInFlight, err := cfg.FieldInt(maxInFlightFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", maxInFlightFieldName, err)
+	}
+	if maxInFlight <= 0 {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("%s must be positive", maxInFlightFieldName)
+	}
+	policy, err := cfg.FieldBatchPolicy(batchingFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", batchingFieldName, err)
+	}
+	boff, err := cfg.FieldBackOff(backoffFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", backoffFieldName, err)
+	}
+	return &output{
+		logger:     mgr.Logger(),
+		opts:       opts,
+		table:      table,
+		query:      query,
+		dedupToken: dedupToken,
+		backoff:    boff,
+		failed:     mgr.Metrics().NewCounter(MetricInsertFailed, "table", "reason"),
+	}, policy, maxInFlight, nil
+}
@@ -1,7 +1,6 @@
 package cloudeventconvert
 
 import (
-	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,7 +8,9 @@ import (
 
 	"github.com/DIMO-Network/cloudevent"
 	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
 	"github.com/DIMO-Network/dis/internal/processors/rawparquet"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
 	"github.com/DIMO-Network/dis/internal/web3"
 	"github.com/ethereum/go-ethereum/accounts"
 	"github.com/ethereum/go-ethereum/common"
@@ -25,14 +26,18 @@ const MaxTombstoneReasonBytes = 512
 
 // processAttestationMsg is the entrypoint for attestation messages.
 // It validates the message, verifies the signature, and sets the metadata.
-func (c *cloudeventProcessor) processAttestationMsg(ctx context.Context, msg *service.Message, msgBytes []byte, source string) service.MessageBatch {
-	event, err := parseAndValidateAttestation(msgBytes, source)
-	if err != nil {
-		processors.SetError(msg, processorName, "failed to process attestation", err)
+func (c *cloudeventProcessor) processAttestationMsg(msg *service.Message, conv *Conversion) service.MessageBatch {
+	event := *conv.attestation
+	event.CloudEventHeader = conv.headers()[0]
+
+	// Check the policy before signature verification so unauthorized callers
+	// cannot make us spend RPC calls on ERC-1271 checks.
+	if err := c.authorizeAttestation(msg, &event); err != nil {
+		processors.SetError(msg, processorName, "attestation not authorized", err)
 		return service.MessageBatch{msg}
 	}
 
-	validSignature, err := c.verifySignature(event, common.HexToAddress(event.Source))
+	validSignature, err := c.verifySignature(&event, common.HexToAddress(event.Source))
 	if err != nil {
 		processors.SetError(msg, processorName, "failed to check message signature", err)
 		return service.MessageBatch{msg}
@@ -53,14 +58,28 @@ func (c *cloudeventProcessor) processAttestationMsg(ctx context.Context, msg *se
 	}
 
 	msg.MetaDelete("Authorization")
+	msg.MetaDelete(httpinputserver.JWTClaimsKey)
 	setMetaData(&event.CloudEventHeader, msg)
 	msg.MetaSetMut(processors.MessageContentKey, cloudEventValidContentType)
 
-	msg.SetStructuredMut(event)
+	msg.SetStructuredMut(&event)
 
 	return service.MessageBatch{msg}
 }
 
+// authorizeAttestation applies the attestation policy to the claims recorded by the attestation input.
+func (c *cloudeventProcessor) authorizeAttestation(msg *service.Message, event *cloudevent.RawEvent) error {
+	if c.attestationPolicy == nil {
+		return nil
+	}
+	rawClaims, _ := msg.MetaGet(httpinputserver.JWTClaimsKey)
+	claims, err := parseJWTClaims(rawClaims)
+	if err != nil {
+		return err
+	}
+	return c.attestationPolicy.Authorize(claims, common.HexToAddress(event.Source), event.Type, event.Subject)
+}
+
 // tombstoneData is the expected shape of a dimo.tombstone event's data payload.
 type tombstoneData struct {
 	VoidsID string `json:"voidsId"`
@@ -101,10 +120,6 @@ func parseAndValidateAttestation(msgBytes []byte, source string) (*cloudevent.Ra
 		return nil, fmt.Errorf("failed to unmarshal attestation cloud event: %w", err)
 	}
 
-	if processors.IsFutureTimestamp(event.Time) {
-		return nil, fmt.Errorf("event timestamp %v exceeds valid range", event.Time)
-	}
-
 	if did, err := cloudevent.DecodeERC721DID(event.Subject); err == nil {
 		event.Subject = did.String()
 	} else if did, err := cloudevent.DecodeEthrDID(event.Subject); err == nil {
@@ -135,6 +150,14 @@ func parseAndValidateAttestation(msgBytes []byte, source string) (*cloudevent.Ra
 	if !isValidAttestationType(event.Type) {
 		return nil, fmt.Errorf("invalid attestation type %q: must be dimo.attestation, dimo.tombstone, dimo.raw.*, or dimo.document.*", event.Type)
 	}
+	if !sourceconfig.For(source).AllowsType(event.Type) {
+		return nil, fmt.Errorf("%w: %s", errTypeNotAllowed, event.Type)
+	}
+	// The signature only covers the data, so the time may be clamped.
+	timeCheck := processors.CheckTimestamp(event.Time, source, event.Type, sourceconfig.TimeActionReject)
+	if err := timeCheck.Apply(&event.CloudEventHeader); err != nil {
+		return nil, fmt.Errorf("invalid event time: %w", err)
+	}
 	return &event, nil
 }
 
@@ -0,0 +1,162 @@
+package cloudeventconvert
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	issuerClaim     = "iss"
+	providerIDClaim = "provider_id"
+)
+
+var errAttestationNotAllowed = errors.New("attestation not allowed by policy")
+
+// AttestationPolicy restricts which attestation types and subjects an
+// authenticated caller may write. An attestation is allowed when at least one
+// rule matches the caller and permits both its type and subject.
+type AttestationPolicy struct {
+	Rules []AttestationRule `yaml:"rules"`
+}
+
+// AttestationRule grants the callers it matches the right to write the listed
+// types about the listed subjects. Empty caller conditions match any caller.
+type AttestationRule struct {
+	Name string `yaml:"name"`
+
+	// Issuers are the JWT issuers the rule applies to.
+	Issuers []string `yaml:"issuers"`
+	// ProviderIDs are the values of the JWT provider_id claim the rule applies to.
+	ProviderIDs []string `yaml:"provider_ids"`
+	// Sources are the attestation source addresses the rule applies to.
+	Sources []string `yaml:"sources"`
+	// Claims requires each named JWT claim to equal, or for list claims to
+	// contain, one of the listed values.
+	Claims map[string][]string `yaml:"claims"`
+
+	// Types are path.Match patterns of allowed attestation types, e.g. "dimo.document.*".
+	Types []string `yaml:"types"`
+	// Subjects are path.Match patterns of allowed subjects. Empty allows any subject.
+	Subjects []string `yaml:"subjects"`
+}
+
+// LoadAttestationPolicy reads and validates a policy file. An empty path yields
+// a nil policy, which allows every attestation.
+func LoadAttestationPolicy(policyPath string) (*AttestationPolicy, error) {
+	if policyPath == "" {
+		return nil, nil
+	}
+	raw, err := os.ReadFile(policyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation policy: %w", err)
+	}
+	var policy AttestationPolicy
+	if err := yaml.Unmarshal(raw, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse attestation policy: %w", err)
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if len(rule.Types) == 0 {
+			return nil, fmt.Errorf("attestation policy rule %q allows no types", rule.Name)
+		}
+		for j, source := range rule.Sources {
+			if !common.IsHexAddress(source) {
+				return nil, fmt.Errorf("attestation policy rule %q has invalid source address: %s", rule.Name, source)
+			}
+			rule.Sources[j] = common.HexToAddress(source).Hex()
+		}
+		// Subjects are compared case-insensitively so patterns need not match EIP-55 casing.
+		for j, subject := range rule.Subjects {
+			rule.Subjects[j] = strings.ToLower(subject)
+		}
+		for _, pattern := range slices.Concat(rule.Types, rule.Subjects) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("attestation policy rule %q has invalid pattern %q: %w", rule.Name, pattern, err)
+			}
+		}
+	}
+	return &policy, nil
+}
+
+// Authorize returns errAttestationNotAllowed unless a rule permits the caller
+// identified by the JWT claims and source to write eventType about subject.
+func (p *AttestationPolicy) Authorize(claims map[string]any, source common.Address, eventType, subject string) error {
+	if p == nil {
+		return nil
+	}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+		if rule.matchesCaller(claims, source) && matchAny(rule.Types, eventType) &&
+			(len(rule.Subjects) == 0 || matchAny(rule.Subjects, strings.ToLower(subject))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: source %s may not write %s about %s", errAttestationNotAllowed, source.Hex(), eventType, subject)
+}
+
+func (r *AttestationRule) matchesCaller(claims map[string]any, source common.Address) bool {
+	if len(r.Sources) > 0 && !slices.Contains(r.Sources, source.Hex()) {
+		return false
+	}
+	if len(r.Issuers) > 0 && !claimMatches(claims[issuerClaim], r.Issuers) {
+		return false
+	}
+	if len(r.ProviderIDs) > 0 && !claimMatches(claims[providerIDClaim], r.ProviderIDs) {
+		return false
+	}
+	for name, allowed := range r.Claims {
+		if !claimMatches(claims[name], allowed) {
+			return false
+		}
+	}
+	return true
+}
+
+// claimMatches reports whether a scalar claim equals one of allowed, or a list
+// claim contains one of allowed.
+func claimMatches(claim any, allowed []string) bool {
+	switch v := claim.(type) {
+	case nil:
+		return false
+	case []any:
+		for _, elem := range v {
+			if claimMatches(elem, allowed) {
+				return true
+			}
+		}
+		return false
+	case string:
+		return slices.Contains(allowed, v)
+	default:
+		return slices.Contains(allowed, fmt.Sprint(v))
+	}
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseJWTClaims decodes the claims recorded by the attestation input.
+func parseJWTClaims(raw string) (map[string]any, error) {
+	claims := map[string]any{}
+	if raw == "" {
+		return claims, nil
+	}
+	if err := json.Unmarshal([]byte(raw), &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode jwt claims: %w", err)
+	}
+	return claims, nil
+}
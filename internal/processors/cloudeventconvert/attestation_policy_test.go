@@ -0,0 +1,179 @@
+package cloudeventconvert
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAttestationPolicy = `
+rules:
+  - name: insurers
+    issuers: ["https://auth.dimo.zone"]
+    claims:
+      privileges: ["attest:insurance"]
+    types: ["dimo.attestation", "dimo.document.*"]
+    subjects: ["did:erc721:137:0xba5738a18d83d41847dffbdc6101d37c69c9b0cf:*"]
+  - name: tombstoner
+    provider_ids: ["ops"]
+    sources: ["0x07b584f6a7125491c991ca2a45ab9e641b1cee1b"]
+    types: ["dimo.tombstone"]
+`
+
+func writeAttestationPolicy(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestAttestationPolicyAuthorize(t *testing.T) {
+	policy, err := LoadAttestationPolicy(writeAttestationPolicy(t, testAttestationPolicy))
+	require.NoError(t, err)
+
+	source := common.HexToAddress("0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b")
+	otherSource := common.HexToAddress("0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8")
+	subject := "did:erc721:137:0xbA5738a18d83D41847dfFbDC6101d37C69c9B0cF:42"
+	insurer := map[string]any{"iss": "https://auth.dimo.zone", "privileges": []any{"attest:insurance", "other"}}
+
+	tests := []struct {
+		name      string
+		claims    map[string]any
+		source    common.Address
+		eventType string
+		subject   string
+		allowed   bool
+	}{
+		{
+			name:      "insurer writes attestation about allowed contract",
+			claims:    insurer,
+			source:    otherSource,
+			eventType: cloudevent.TypeAttestation,
+			subject:   subject,
+			allowed:   true,
+		},
+		{
+			name:      "insurer writes document",
+			claims:    insurer,
+			source:    otherSource,
+			eventType: "dimo.document.policy",
+			subject:   subject,
+			allowed:   true,
+		},
+		{
+			name:      "insurer writes raw type",
+			claims:    insurer,
+			source:    otherSource,
+			eventType: "dimo.raw.blob",
+			subject:   subject,
+		},
+		{
+			name:      "insurer writes about other contract",
+			claims:    insurer,
+			source:    otherSource,
+			eventType: cloudevent.TypeAttestation,
+			subject:   "did:erc721:137:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:42",
+		},
+		{
+			name:      "missing privilege claim",
+			claims:    map[string]any{"iss": "https://auth.dimo.zone"},
+			source:    otherSource,
+			eventType: cloudevent.TypeAttestation,
+			subject:   subject,
+		},
+		{
+			name:      "wrong issuer",
+			claims:    map[string]any{"iss": "https://auth.dev.dimo.zone", "privileges": "attest:insurance"},
+			source:    otherSource,
+			eventType: cloudevent.TypeAttestation,
+			subject:   subject,
+		},
+		{
+			name:      "ops tombstone from allowed source",
+			claims:    map[string]any{"provider_id": "ops"},
+			source:    source,
+			eventType: cloudevent.TypeAttestationTombstone,
+			subject:   subject,
+			allowed:   true,
+		},
+		{
+			name:      "ops tombstone from other source",
+			claims:    map[string]any{"provider_id": "ops"},
+			source:    otherSource,
+			eventType: cloudevent.TypeAttestationTombstone,
+			subject:   subject,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Authorize(tt.claims, tt.source, tt.eventType, tt.subject)
+			if tt.allowed {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, errAttestationNotAllowed)
+		})
+	}
+
+	var noPolicy *AttestationPolicy
+	require.NoError(t, noPolicy.Authorize(nil, source, "dimo.raw.blob", subject))
+}
+
+func TestLoadAttestationPolicyInvalid(t *testing.T) {
+	policy, err := LoadAttestationPolicy("")
+	require.NoError(t, err)
+	assert.Nil(t, policy)
+
+	invalid := []string{
+		"rules:\n  - name: no-types\n",
+		"rules:\n  - name: bad-source\n    sources: [not-an-address]\n    types: [dimo.attestation]\n",
+		"rules:\n  - name: bad-pattern\n    types: [\"dimo.[\"]\n",
+	}
+	for _, content := range invalid {
+		_, err := LoadAttestationPolicy(writeAttestationPolicy(t, content))
+		require.Error(t, err, content)
+	}
+}
+
+func TestProcessAttestationMsg_Policy(t *testing.T) {
+	const privHex = "59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d"
+	privKey, err := crypto.HexToECDSA(privHex)
+	require.NoError(t, err)
+	source := crypto.PubkeyToAddress(privKey.PublicKey)
+
+	policy, err := LoadAttestationPolicy(writeAttestationPolicy(t, "rules:\n  - provider_ids: [ops]\n    types: [dimo.tombstone]\n"))
+	require.NoError(t, err)
+	proc := &cloudeventProcessor{attestationPolicy: policy}
+
+	subject := "did:erc721:80002:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:1005"
+	input := signTombstoneEvent(t, privHex, source, subject, "tombstone-id-1", "target-attestation-id-1", "", time.Now().UTC())
+
+	newMsg := func(claims string) *service.Message {
+		msg := service.NewMessage(input)
+		msg.MetaSet(httpinputserver.DIMOCloudEventSource, source.Hex())
+		msg.MetaSet(processors.MessageContentKey, httpinputserver.AttestationContent)
+		msg.MetaSet(httpinputserver.JWTClaimsKey, claims)
+		return msg
+	}
+
+	out := proc.processMsg(context.Background(), newMsg(`{"provider_id":"ops"}`))
+	require.Len(t, out, 1)
+	require.NoError(t, out[0].GetError())
+	_, ok := out[0].MetaGet(httpinputserver.JWTClaimsKey)
+	assert.False(t, ok, "jwt claims should not be forwarded")
+
+	out = proc.processMsg(context.Background(), newMsg(`{"provider_id":"someone-else"}`))
+	require.Len(t, out, 1)
+	require.ErrorIs(t, out[0].GetError(), errAttestationNotAllowed)
+}
@@ -0,0 +1,90 @@
+package cloudeventconvert
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// binaryModePrefix is the prefix of CloudEvents attribute headers in HTTP binary content mode.
+	binaryModePrefix = "ce-"
+	// structuredContentType is the content type of an event in the JSON event format.
+	structuredContentType = "application/cloudevents+json"
+)
+
+// liftBinaryModeHeaders turns a CloudEvents HTTP binary content mode request,
+// where the attributes are sent as ce-* headers and the body is the event data,
+// into a structured JSON event. ok is false when the request is not in binary
+// mode, which is signalled by the ce-specversion header.
+func liftBinaryModeHeaders(msg *service.Message, body []byte) (eventJSON []byte, ok bool, err error) {
+	attrs := map[string]any{}
+	_ = msg.MetaWalk(func(key, value string) error {
+		lowerKey := strings.ToLower(key)
+		if !strings.HasPrefix(lowerKey, binaryModePrefix) {
+			return nil
+		}
+		name := strings.TrimPrefix(lowerKey, binaryModePrefix)
+		if decoded, err := url.PathUnescape(value); err == nil {
+			value = decoded
+		}
+		attrs[name] = value
+		return nil
+	})
+	if _, ok := attrs["specversion"]; !ok {
+		return nil, false, nil
+	}
+	contentType, _ := msg.MetaGet(contentTypeKey)
+	if contentType != "" {
+		attrs["datacontenttype"] = contentType
+	}
+	// The body is the event data, so data attributes cannot be set through headers.
+	delete(attrs, "data")
+	delete(attrs, "data_base64")
+
+	hdrJSON, err := json.Marshal(attrs)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to encode binary mode attributes: %w", err)
+	}
+	var event cloudevent.RawEvent
+	if err := json.Unmarshal(hdrJSON, &event); err != nil {
+		return nil, true, fmt.Errorf("invalid binary mode attributes: %w", err)
+	}
+	if len(body) > 0 {
+		setBinaryData(&event, body)
+	}
+	// Call MarshalJSON directly; json.Marshal would compact the data and break signatures over it.
+	eventJSON, err = event.MarshalJSON()
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to encode binary mode event: %w", err)
+	}
+
+	removeBinaryModeHeaders(msg)
+	return eventJSON, true, nil
+}
+
+// removeBinaryModeHeaders removes the attribute headers of a binary mode
+// request once they are part of the structured event.
+func removeBinaryModeHeaders(msg *service.Message) {
+	var attrKeys []string
+	isBinaryMode := false
+	_ = msg.MetaWalk(func(key, _ string) error {
+		lowerKey := strings.ToLower(key)
+		if strings.HasPrefix(lowerKey, binaryModePrefix) {
+			attrKeys = append(attrKeys, key)
+			isBinaryMode = isBinaryMode || lowerKey == binaryModePrefix+"specversion"
+		}
+		return nil
+	})
+	if !isBinaryMode {
+		return
+	}
+	for _, key := range attrKeys {
+		msg.MetaDelete(key)
+	}
+	msg.MetaSetMut(contentTypeKey, structuredContentType)
+}
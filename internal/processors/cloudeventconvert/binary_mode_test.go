@@ -0,0 +1,170 @@
+package cloudeventconvert
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/DIMO-Network/model-garage/pkg/modules"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryModeConnection(t *testing.T) {
+	modules.CloudEventRegistry.Override(binaryTestSource, passthroughModule{})
+
+	subject := "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:2"
+	producer := "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:3"
+	eventTime := "2025-03-14T15:09:26.535Z"
+	data := `{"signals":[{"name":"speed","timestamp":"2025-03-14T15:09:26Z","value":55}]}`
+
+	structured := []byte(`{"id":"evt-1","source":"` + binaryTestSource + `","specversion":"1.0","type":"dimo.status",` +
+		`"subject":"` + subject + `","producer":"` + producer + `","time":"` + eventTime + `",` +
+		`"datacontenttype":"application/json","vin":"1HGCM82633A004352","data":` + data + `}`)
+	expected := convertConnectionEvent(t, structured, "application/json")
+	require.NoError(t, expected.GetError())
+	expectedBytes, err := expected.AsBytes()
+	require.NoError(t, err)
+
+	msg := service.NewMessage([]byte(data))
+	msg.MetaSetMut(httpinputserver.DIMOCloudEventSource, binaryTestSource)
+	msg.MetaSetMut(processors.MessageContentKey, httpinputserver.ConnectionContent)
+	msg.MetaSetMut(contentTypeKey, "application/json")
+	msg.MetaSetMut("Ce-Specversion", "1.0")
+	msg.MetaSetMut("Ce-Id", "evt-1")
+	msg.MetaSetMut("Ce-Source", binaryTestSource)
+	msg.MetaSetMut("Ce-Type", cloudevent.TypeStatus)
+	msg.MetaSetMut("Ce-Subject", subject)
+	msg.MetaSetMut("Ce-Producer", producer)
+	msg.MetaSetMut("Ce-Time", eventTime)
+	msg.MetaSetMut("ce-vin", "1HGCM82633A004352")
+
+	// Converting the request ahead of processing leaves its binary mode headers in place.
+	_, err = Convert(context.Background(), msg)
+	require.NoError(t, err)
+	_, ok := msg.MetaGet("Ce-Id")
+	require.True(t, ok)
+
+	proc := &cloudeventProcessor{}
+	result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	require.Len(t, result[0], 1)
+	out := result[0][0]
+	require.NoError(t, out.GetError())
+	outBytes, err := out.AsBytes()
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expectedBytes), string(outBytes))
+
+	_, hasHeader := out.MetaGet("Ce-Id")
+	assert.False(t, hasHeader, "ce-* headers should be removed once lifted")
+}
+
+func TestBinaryModeAttestation(t *testing.T) {
+	const privHex = "59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d"
+	privKey, err := crypto.HexToECDSA(privHex)
+	require.NoError(t, err)
+	source := crypto.PubkeyToAddress(privKey.PublicKey)
+
+	// Whitespace in the body is part of the signed bytes, so the signature only
+	// verifies if the lift keeps the body as is.
+	data := []byte(`{"subject": "did:erc721:80002:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:1005", "insured": true}`)
+	sig, err := crypto.Sign(accounts.TextHash(data), privKey)
+	require.NoError(t, err)
+	sig[64] += 27
+
+	msg := service.NewMessage(data)
+	msg.MetaSetMut(httpinputserver.DIMOCloudEventSource, source.Hex())
+	msg.MetaSetMut(processors.MessageContentKey, httpinputserver.AttestationContent)
+	msg.MetaSetMut(contentTypeKey, "application/json")
+	msg.MetaSetMut("Ce-Specversion", "1.0")
+	msg.MetaSetMut("Ce-Id", "attestation-1")
+	msg.MetaSetMut("Ce-Type", cloudevent.TypeAttestation)
+	msg.MetaSetMut("Ce-Subject", "did:erc721:80002:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:1005")
+	msg.MetaSetMut("Ce-Time", time.Now().UTC().Truncate(time.Minute).Format(time.RFC3339))
+	msg.MetaSetMut("Ce-Signature", "0x"+common.Bytes2Hex(sig))
+
+	proc := &cloudeventProcessor{}
+	result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	require.Len(t, result[0], 1)
+	out := result[0][0]
+	require.NoError(t, out.GetError())
+
+	var event cloudevent.RawEvent
+	outBytes, err := out.AsBytes()
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(outBytes, &event))
+	assert.Equal(t, "attestation-1", event.ID)
+	assert.Equal(t, source.Hex(), event.Source)
+	assert.Equal(t, "application/json", event.DataContentType)
+	assert.JSONEq(t, string(data), string(event.Data))
+}
+
+func TestLiftBinaryModeHeaders(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		body         []byte
+		expectedMode bool
+		expectedJSON string
+		expectError  bool
+	}{
+		{
+			name:         "structured mode",
+			headers:      map[string]string{"Content-Type": "application/cloudevents+json", "Ce-Id": "1"},
+			body:         []byte(`{"id":"1"}`),
+			expectedMode: false,
+		},
+		{
+			name: "percent encoded values and binary data",
+			headers: map[string]string{
+				"Ce-Specversion": "1.0",
+				"Ce-Id":          "1",
+				"Ce-Type":        cloudevent.TypeStatus,
+				"Ce-Subject":     "caf%C3%A9",
+				"Content-Type":   "application/octet-stream",
+			},
+			body:         []byte{0x00, 0x01},
+			expectedMode: true,
+			expectedJSON: `{"id":"1","source":"","producer":"","specversion":"1.0","subject":"café","time":"0001-01-01T00:00:00Z","type":"dimo.status","datacontenttype":"application/octet-stream","data_base64":"AAE="}`,
+		},
+		{
+			name:         "invalid time",
+			headers:      map[string]string{"Ce-Specversion": "1.0", "Ce-Time": "yesterday"},
+			expectedMode: true,
+			expectError:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := service.NewMessage(tt.body)
+			for key, value := range tt.headers {
+				msg.MetaSetMut(key, value)
+			}
+			eventJSON, isBinaryMode, err := liftBinaryModeHeaders(msg, tt.body)
+			assert.Equal(t, tt.expectedMode, isBinaryMode)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			if tt.expectedJSON == "" {
+				assert.Nil(t, eventJSON)
+				return
+			}
+			assert.JSONEq(t, tt.expectedJSON, string(eventJSON))
+			contentType, _ := msg.MetaGet(contentTypeKey)
+			assert.Equal(t, structuredContentType, contentType)
+		})
+	}
+}
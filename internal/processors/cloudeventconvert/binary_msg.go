@@ -0,0 +1,265 @@
+package cloudeventconvert
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"reflect"
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// contentTypeKey is the metadata key the HTTP inputs store the Content-Type header under.
+	contentTypeKey = "Content-Type"
+
+	// ContentTypeProtobuf is the CloudEvents protobuf event format.
+	ContentTypeProtobuf = "application/cloudevents+protobuf"
+	// ContentTypeCBOR is a CBOR encoding of the CloudEvents JSON event format.
+	ContentTypeCBOR = "application/cbor"
+	// ContentTypeCloudEventsCBOR is an alias of ContentTypeCBOR.
+	ContentTypeCloudEventsCBOR = "application/cloudevents+cbor"
+)
+
+var errProtoData = errors.New("proto_data is not supported, use binary_data or text_data")
+
+var cborDecMode = func() cbor.DecMode {
+	mode, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]any{}),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return mode
+}()
+
+// binaryEventToJSON decodes a Protobuf or CBOR encoded CloudEvent into the
+// JSON event format so that it follows the same conversion and validation as
+// structured JSON events. ok is false when contentType is not a binary format.
+func binaryEventToJSON(contentType string, body []byte) (eventJSON []byte, ok bool, err error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, false, nil
+	}
+	var event *cloudevent.RawEvent
+	switch mediaType {
+	case ContentTypeProtobuf:
+		event, err = decodeProtobufEvent(body)
+	case ContentTypeCBOR, ContentTypeCloudEventsCBOR:
+		event, err = decodeCBOREvent(body)
+	default:
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to decode %s cloud event: %w", mediaType, err)
+	}
+	eventJSON, err = event.MarshalJSON()
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to encode %s cloud event as json: %w", mediaType, err)
+	}
+	return eventJSON, true, nil
+}
+
+// decodeCBOREvent decodes a CBOR map with the same keys as the JSON event
+// format. data may be any CBOR value; a byte string is used as is for JSON
+// content types and as data_base64 otherwise.
+func decodeCBOREvent(body []byte) (*cloudevent.RawEvent, error) {
+	var fields map[string]any
+	if err := cborDecMode.Unmarshal(body, &fields); err != nil {
+		return nil, err
+	}
+	data, hasData := fields["data"]
+	delete(fields, "data")
+	if t, ok := fields["time"].(time.Time); ok {
+		fields["time"] = t.Format(time.RFC3339Nano)
+	}
+	hdrJSON, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var event cloudevent.RawEvent
+	if err := json.Unmarshal(hdrJSON, &event); err != nil {
+		return nil, err
+	}
+	if !hasData {
+		return &event, nil
+	}
+	if raw, ok := data.([]byte); ok {
+		setBinaryData(&event, raw)
+		return &event, nil
+	}
+	event.Data, err = json.Marshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("data is not representable as json: %w", err)
+	}
+	return &event, nil
+}
+
+// decodeProtobufEvent decodes the io.cloudevents.v1.CloudEvent message.
+func decodeProtobufEvent(body []byte) (*cloudevent.RawEvent, error) {
+	var event cloudevent.RawEvent
+	var binaryData []byte
+	attrs := map[string]any{}
+	err := rangeProtoFields(body, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			event.ID = string(value)
+		case 2:
+			event.Source = string(value)
+		case 3:
+			event.SpecVersion = string(value)
+		case 4:
+			event.Type = string(value)
+		case 5:
+			name, attr, err := decodeProtoAttribute(value)
+			if err != nil {
+				return err
+			}
+			attrs[name] = attr
+		case 6:
+			binaryData = value
+		case 7:
+			event.Data = json.RawMessage(value)
+			if !json.Valid(value) {
+				textJSON, err := json.Marshal(string(value))
+				if err != nil {
+					return err
+				}
+				event.Data = textJSON
+			}
+		case 8:
+			return errProtoData
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Route optional and extension attributes through the JSON decoder so they
+	// land in the same header fields and Extras as a structured JSON event.
+	if len(attrs) > 0 {
+		var extra cloudevent.CloudEventHeader
+		attrJSON, err := json.Marshal(attrs)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(attrJSON, &extra); err != nil {
+			return nil, fmt.Errorf("invalid attributes: %w", err)
+		}
+		extra.ID, extra.Source, extra.SpecVersion, extra.Type = event.ID, event.Source, event.SpecVersion, event.Type
+		event.CloudEventHeader = extra
+	}
+	if binaryData != nil {
+		setBinaryData(&event, binaryData)
+	}
+	return &event, nil
+}
+
+// decodeProtoAttribute decodes a map<string, CloudEventAttributeValue> entry.
+func decodeProtoAttribute(entry []byte) (string, any, error) {
+	var name string
+	var value any
+	err := rangeProtoFields(entry, func(num protowire.Number, raw []byte) error {
+		switch num {
+		case 1:
+			name = string(raw)
+		case 2:
+			return rangeProtoFields(raw, func(num protowire.Number, raw []byte) error {
+				switch num {
+				case 1:
+					v, _ := protowire.ConsumeVarint(raw)
+					value = v != 0
+				case 2:
+					v, _ := protowire.ConsumeVarint(raw)
+					value = int32(v)
+				case 3, 5, 6:
+					value = string(raw)
+				case 4:
+					value = base64.StdEncoding.EncodeToString(raw)
+				case 7:
+					ts, err := decodeProtoTimestamp(raw)
+					if err != nil {
+						return err
+					}
+					value = ts.Format(time.RFC3339Nano)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	if name == "" {
+		return "", nil, errors.New("attribute without a name")
+	}
+	return name, value, nil
+}
+
+func decodeProtoTimestamp(raw []byte) (time.Time, error) {
+	var seconds, nanos uint64
+	err := rangeProtoFields(raw, func(num protowire.Number, value []byte) error {
+		switch num {
+		case 1:
+			seconds, _ = protowire.ConsumeVarint(value)
+		case 2:
+			nanos, _ = protowire.ConsumeVarint(value)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(seconds), int64(int32(nanos))).UTC(), nil
+}
+
+// rangeProtoFields calls fn for every field in a protobuf message. value holds
+// the varint bytes for varint fields and the payload for length delimited fields.
+func rangeProtoFields(msg []byte, fn func(num protowire.Number, value []byte) error) error {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		var value []byte
+		switch typ {
+		case protowire.VarintType:
+			_, n = protowire.ConsumeVarint(msg)
+			if n >= 0 {
+				value = msg[:n]
+			}
+		case protowire.BytesType:
+			var payload []byte
+			payload, n = protowire.ConsumeBytes(msg)
+			value = payload
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, msg)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		msg = msg[n:]
+		if err := fn(num, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBinaryData stores raw event data, inline when it is JSON and as
+// data_base64 otherwise.
+func setBinaryData(event *cloudevent.RawEvent, raw []byte) {
+	if (event.DataContentType == "" || cloudevent.IsJSONDataContentType(event.DataContentType)) && json.Valid(raw) {
+		event.Data = json.RawMessage(raw)
+		return
+	}
+	event.DataBase64 = base64.StdEncoding.EncodeToString(raw)
+}
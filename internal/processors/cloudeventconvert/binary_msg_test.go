@@ -0,0 +1,173 @@
+package cloudeventconvert
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/DIMO-Network/model-garage/pkg/modules"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const binaryTestSource = "0x5E57000000000000000000000000000000000B1A"
+
+// passthroughModule returns the structured event it is given, like the default module.
+type passthroughModule struct{}
+
+func (passthroughModule) CloudEventConvert(_ context.Context, data []byte) ([]cloudevent.CloudEventHeader, []byte, error) {
+	var event cloudevent.RawEvent
+	if err := json.Unmarshal(data, &event); err != nil {
+		return nil, nil, err
+	}
+	return []cloudevent.CloudEventHeader{event.CloudEventHeader}, event.Data, nil
+}
+
+func protoString(b []byte, num protowire.Number, s string) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+func protoAttribute(b []byte, name string, value []byte) []byte {
+	var entry []byte
+	entry = protoString(entry, 1, name)
+	entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+	entry = protowire.AppendBytes(entry, value)
+	b = protowire.AppendTag(b, 5, protowire.BytesType)
+	return protowire.AppendBytes(b, entry)
+}
+
+func protoTimestamp(t time.Time) []byte {
+	var ts []byte
+	ts = protowire.AppendTag(ts, 1, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(t.Unix()))
+	ts = protowire.AppendTag(ts, 2, protowire.VarintType)
+	ts = protowire.AppendVarint(ts, uint64(t.Nanosecond()))
+	var value []byte
+	value = protowire.AppendTag(value, 7, protowire.BytesType)
+	return protowire.AppendBytes(value, ts)
+}
+
+func protoStringValue(s string) []byte {
+	return protoString(nil, 3, s)
+}
+
+func TestBinaryEventRoundTrip(t *testing.T) {
+	modules.CloudEventRegistry.Override(binaryTestSource, passthroughModule{})
+
+	eventTime := time.Date(2025, 3, 14, 15, 9, 26, 535000000, time.UTC)
+	subject := "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:2"
+	producer := "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:3"
+	data := `{"signals":[{"name":"speed","timestamp":"2025-03-14T15:09:26Z","value":55}]}`
+
+	jsonEvent := []byte(`{"id":"evt-1","source":"` + binaryTestSource + `","specversion":"1.0","type":"dimo.status",` +
+		`"subject":"` + subject + `","producer":"` + producer + `","time":"` + eventTime.Format(time.RFC3339Nano) + `",` +
+		`"datacontenttype":"application/json","dataversion":"v2","vin":"1HGCM82633A004352","data":` + data + `}`)
+
+	var protoEvent []byte
+	protoEvent = protoString(protoEvent, 1, "evt-1")
+	protoEvent = protoString(protoEvent, 2, binaryTestSource)
+	protoEvent = protoString(protoEvent, 3, "1.0")
+	protoEvent = protoString(protoEvent, 4, cloudevent.TypeStatus)
+	protoEvent = protoAttribute(protoEvent, "subject", protoStringValue(subject))
+	protoEvent = protoAttribute(protoEvent, "producer", protoStringValue(producer))
+	protoEvent = protoAttribute(protoEvent, "time", protoTimestamp(eventTime))
+	protoEvent = protoAttribute(protoEvent, "datacontenttype", protoStringValue("application/json"))
+	protoEvent = protoAttribute(protoEvent, "dataversion", protoStringValue("v2"))
+	protoEvent = protoAttribute(protoEvent, "vin", protoStringValue("1HGCM82633A004352"))
+	protoEvent = protowire.AppendTag(protoEvent, 6, protowire.BytesType)
+	protoEvent = protowire.AppendBytes(protoEvent, []byte(data))
+
+	var dataValue any
+	require.NoError(t, json.Unmarshal([]byte(data), &dataValue))
+	cborFields := map[string]any{
+		"id":              "evt-1",
+		"source":          binaryTestSource,
+		"specversion":     "1.0",
+		"type":            cloudevent.TypeStatus,
+		"subject":         subject,
+		"producer":        producer,
+		"time":            eventTime,
+		"datacontenttype": "application/json",
+		"dataversion":     "v2",
+		"vin":             "1HGCM82633A004352",
+	}
+	cborEncMode, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano, TimeTag: cbor.EncTagRequired}.EncMode()
+	require.NoError(t, err)
+	cborFields["data"] = dataValue
+	cborEvent, err := cborEncMode.Marshal(cborFields)
+	require.NoError(t, err)
+	cborFields["data"] = []byte(data)
+	cborBytesEvent, err := cborEncMode.Marshal(cborFields)
+	require.NoError(t, err)
+
+	expected := convertConnectionEvent(t, jsonEvent, "application/json")
+	require.NoError(t, expected.GetError())
+	expectedBytes, err := expected.AsBytes()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{name: "protobuf", contentType: ContentTypeProtobuf, body: protoEvent},
+		{name: "cbor", contentType: ContentTypeCBOR, body: cborEvent},
+		{name: "cbor byte string data", contentType: ContentTypeCloudEventsCBOR + "; charset=utf-8", body: cborBytesEvent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := convertConnectionEvent(t, tt.body, tt.contentType)
+			require.NoError(t, out.GetError())
+			outBytes, err := out.AsBytes()
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expectedBytes), string(outBytes))
+		})
+	}
+}
+
+func TestBinaryEventInvalid(t *testing.T) {
+	modules.CloudEventRegistry.Override(binaryTestSource, passthroughModule{})
+
+	protoData := protoString(nil, 1, "evt-1")
+	protoData = protowire.AppendTag(protoData, 8, protowire.BytesType)
+	protoData = protowire.AppendBytes(protoData, []byte{0x0a, 0x00})
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{name: "truncated protobuf", contentType: ContentTypeProtobuf, body: []byte{0x0a, 0x10, 'a'}},
+		{name: "protobuf proto_data", contentType: ContentTypeProtobuf, body: protoData},
+		{name: "cbor array", contentType: ContentTypeCBOR, body: []byte{0x81, 0x01}},
+		{name: "json sent as cbor", contentType: ContentTypeCBOR, body: []byte(`{"id":"evt-1"}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := convertConnectionEvent(t, tt.body, tt.contentType)
+			require.Error(t, out.GetError())
+		})
+	}
+}
+
+func convertConnectionEvent(t *testing.T, body []byte, contentType string) *service.Message {
+	t.Helper()
+	msg := service.NewMessage(body)
+	msg.MetaSetMut(httpinputserver.DIMOCloudEventSource, binaryTestSource)
+	msg.MetaSetMut(processors.MessageContentKey, httpinputserver.ConnectionContent)
+	msg.MetaSetMut(contentTypeKey, contentType)
+	proc := &cloudeventProcessor{}
+	result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.Len(t, result, 1)
+	require.Len(t, result[0], 1)
+	return result[0][0]
+}
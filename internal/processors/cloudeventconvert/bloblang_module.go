@@ -0,0 +1,85 @@
+package cloudeventconvert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/model-garage/pkg/defaultmodule"
+	"github.com/DIMO-Network/model-garage/pkg/schema"
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/redpanda-data/benthos/v4/public/bloblang"
+)
+
+// bloblangModule converts payloads to CloudEvents with a Bloblang mapping declared in config.
+type bloblangModule struct {
+	// headers maps a payload to one CloudEvent header or a list of them.
+	headers *bloblang.Executor
+}
+
+// bloblangSignalModule also converts payloads to signals with a Bloblang mapping.
+type bloblangSignalModule struct {
+	*bloblangModule
+	// signals maps a payload to a list of signals in the default module format.
+	signals   *bloblang.Executor
+	signalMap map[string]*schema.SignalInfo
+}
+
+// newBloblangModule returns a module for the mappings. Without a signals
+// mapping the module is not a signal module, so signals of its source are left
+// to the signal module registered for it otherwise, usually the default module.
+func newBloblangModule(headers, signals *bloblang.Executor) (any, error) {
+	module := &bloblangModule{headers: headers}
+	if signals == nil {
+		return module, nil
+	}
+	signalMap, _, err := defaultmodule.LoadSignalAndEventNameMap()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signal map: %w", err)
+	}
+	return &bloblangSignalModule{bloblangModule: module, signals: signals, signalMap: signalMap}, nil
+}
+
+// CloudEventConvert maps the payload to CloudEvent headers. The payload is kept as the event data.
+func (m *bloblangModule) CloudEventConvert(_ context.Context, msgData []byte) ([]cloudevent.CloudEventHeader, []byte, error) {
+	result, err := query(m.headers, msgData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to map cloud event headers: %w", err)
+	}
+	if _, ok := result.([]any); !ok {
+		result = []any{result}
+	}
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal mapped headers: %w", err)
+	}
+	var hdrs []cloudevent.CloudEventHeader
+	if err := json.Unmarshal(resultJSON, &hdrs); err != nil {
+		return nil, nil, fmt.Errorf("mapped headers are not cloud event headers: %w", err)
+	}
+	return hdrs, msgData, nil
+}
+
+// SignalConvert maps the event data to signals, which are validated like those of the default module.
+func (m *bloblangSignalModule) SignalConvert(_ context.Context, event cloudevent.RawEvent) ([]vss.Signal, error) {
+	result, err := query(m.signals, event.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map signals: %w", err)
+	}
+	signalData, err := json.Marshal(map[string]any{"signals": result})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal mapped signals: %w", err)
+	}
+	event.Data = signalData
+	return defaultmodule.SignalConvert(event, m.signalMap)
+}
+
+// query runs a mapping against a JSON payload.
+func query(exec *bloblang.Executor, payload []byte) (any, error) {
+	var input any
+	if err := json.Unmarshal(payload, &input); err != nil {
+		return nil, fmt.Errorf("payload is not valid JSON: %w", err)
+	}
+	return exec.Query(input)
+}
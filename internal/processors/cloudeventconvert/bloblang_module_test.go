@@ -0,0 +1,102 @@
+package cloudeventconvert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const bloblangBindings = `
+module_bindings:
+  - source: "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b"
+    module: bloblang
+    cloudevent_mapping: |
+      root.id = this.msgId
+      root.type = "dimo.status"
+      root.producer = "did:erc721:80002:0x325b45949C833986bC98e98a49F3CA5C5c4643B5:" + this.deviceId.string()
+      root.subject = "did:erc721:80002:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:" + this.vehicleId.string()
+      root.time = this.ts
+    signals_mapping: |
+      root = [
+        {"name": "speed", "timestamp": this.ts, "value": this.kph},
+        {"name": "powertrainTransmissionTravelledDistance", "timestamp": this.ts, "value": this.odo.string()},
+      ]
+`
+
+func TestBloblangModule(t *testing.T) {
+	bindings, err := parseTestBindings(t, bloblangBindings)
+	require.NoError(t, err)
+	require.Len(t, bindings, 1)
+	binding := bindings[0]
+
+	payload := []byte(`{"msgId":"m1","deviceId":7,"vehicleId":12,"ts":"2025-01-02T03:04:05Z","kph":42.5,"odo":1000}`)
+	require.NotNil(t, binding.cloudEvent)
+	hdrs, data, err := binding.cloudEvent.CloudEventConvert(context.Background(), payload)
+	require.NoError(t, err)
+	assert.Equal(t, payload, data)
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.Equal(t, []cloudevent.CloudEventHeader{{
+		SpecVersion: "1.0",
+		ID:          "m1",
+		Type:        cloudevent.TypeStatus,
+		Producer:    "did:erc721:80002:0x325b45949C833986bC98e98a49F3CA5C5c4643B5:7",
+		Subject:     "did:erc721:80002:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:12",
+		Time:        ts,
+	}}, hdrs)
+
+	require.NotNil(t, binding.signal)
+	event := cloudevent.RawEvent{CloudEventHeader: hdrs[0], Data: data}
+	signals, err := binding.signal.SignalConvert(context.Background(), event)
+	require.NoError(t, err)
+	require.Len(t, signals, 2)
+	assert.Equal(t, vss.SignalData{Timestamp: ts, Name: vss.FieldSpeed, ValueNumber: 42.5, CloudEventID: "m1"}, signals[0].Data)
+	assert.Equal(t, vss.SignalData{Timestamp: ts, Name: vss.FieldPowertrainTransmissionTravelledDistance, ValueNumber: 1000, CloudEventID: "m1"}, signals[1].Data)
+	assert.Equal(t, cloudevent.TypeSignal, signals[0].Type)
+
+	// Signal values are validated like those of the default module.
+	event.Data = []byte(`{"ts":"2025-01-02T03:04:05Z","kph":1,"odo":"x"}`)
+	_, err = binding.signal.SignalConvert(context.Background(), event)
+	require.Error(t, err)
+
+	_, _, err = binding.cloudEvent.CloudEventConvert(context.Background(), []byte("not json"))
+	require.Error(t, err)
+}
+
+func TestBloblangModuleWithoutSignals(t *testing.T) {
+	bindings, err := parseTestBindings(t, `
+module_bindings:
+  - source: "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b"
+    module: bloblang
+    cloudevent_mapping: |
+      root = [this.merge({"type": "dimo.status"}), this.merge({"type": "dimo.fingerprint"})]
+`)
+	require.NoError(t, err)
+	assert.Nil(t, bindings[0].signal)
+
+	hdrs, _, err := bindings[0].cloudEvent.CloudEventConvert(context.Background(), []byte(`{"id":"1"}`))
+	require.NoError(t, err)
+	require.Len(t, hdrs, 2)
+	assert.Equal(t, cloudevent.TypeFingerprint, hdrs[1].Type)
+}
+
+func TestBloblangBindingErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{name: "missing cloudevent mapping", yaml: "module_bindings:\n  - source: autopi\n    module: bloblang\n"},
+		{name: "mapping on go module", yaml: "module_bindings:\n  - source: autopi\n    module: autopi\n    cloudevent_mapping: root = this\n"},
+		{name: "contract on bloblang module", yaml: "module_bindings:\n  - source: autopi\n    module: bloblang\n    cloudevent_mapping: root = this\n    device_contract: synthetic\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTestBindings(t, tt.yaml)
+			require.Error(t, err)
+		})
+	}
+}
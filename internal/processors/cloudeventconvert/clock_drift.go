@@ -0,0 +1,57 @@
+package cloudeventconvert
+
+import (
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/clockdrift"
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// MetricClockOffset is the estimated clock offset of the producer of each
+	// event, labelled by source and direction (ahead or behind).
+	MetricClockOffset = "dis_producer_clock_offset"
+	// MetricClockCorrected counts events whose signals were corrected by the
+	// clock offset of their producer, labelled by source.
+	MetricClockCorrected = "dis_clock_corrected_total"
+)
+
+// clockDrift tracks the clock offset of producers and optionally marks events
+// from producers whose clock is stably ahead for correction.
+type clockDrift struct {
+	tracker      *clockdrift.Tracker
+	offsets      *service.MetricTimer
+	corrected    *service.MetricCounter
+	correct      bool
+	minOffset    time.Duration
+	maxDeviation time.Duration
+	minSamples   int
+}
+
+// apply observes the offset between the event time and the time the input
+// received it. When the clock of the producer is stably ahead by at least
+// minOffset, the offset is recorded in the event for its signals to be
+// corrected by. The event time is left as sent. Producers that are behind
+// are not corrected, since buffered uploads look the same.
+func (d *clockDrift) apply(hdr *cloudevent.CloudEventHeader, source string, receivedAt time.Time) {
+	if hdr.Producer == "" {
+		return
+	}
+	estimate := d.tracker.Observe(hdr.Producer, hdr.Time.Sub(receivedAt))
+	direction, offset := "ahead", estimate.Offset
+	if offset < 0 {
+		direction, offset = "behind", -offset
+	}
+	d.offsets.Timing(int64(offset), source, direction)
+
+	if !d.correct || estimate.Offset < d.minOffset || !estimate.Stable(d.minSamples, d.maxDeviation) {
+		return
+	}
+	if hdr.Extras == nil {
+		hdr.Extras = make(map[string]any)
+	}
+	hdr.Extras[processors.ExtensionClockOffset] = estimate.Offset.String()
+	d.corrected.Incr(1, source)
+}
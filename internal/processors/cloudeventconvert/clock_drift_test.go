@@ -0,0 +1,83 @@
+package cloudeventconvert
+
+import (
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/clockdrift"
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClockDrift(t *testing.T) {
+	const producer = "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:1"
+	tests := []struct {
+		name      string
+		correct   bool
+		offset    time.Duration
+		queued    time.Duration
+		corrected bool
+	}{
+		{name: "correction disabled", offset: time.Hour},
+		{name: "stable offset", correct: true, offset: time.Hour, corrected: true},
+		{name: "clock behind", correct: true, offset: -2 * time.Hour},
+		{name: "offset below minimum", correct: true, offset: 10 * time.Second},
+		// The offset is measured from the time the input received the event, not
+		// from when it was converted after waiting in a queue.
+		{name: "queued before conversion", correct: true, offset: time.Hour, queued: 30 * time.Minute, corrected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker, err := clockdrift.NewTracker(10)
+			require.NoError(t, err)
+			metrics := service.MockResources().Metrics()
+			proc := &cloudeventProcessor{
+				logger: service.MockResources().Logger(),
+				clockDrift: &clockDrift{
+					tracker:      tracker,
+					offsets:      metrics.NewTimer(MetricClockOffset, "source", "direction"),
+					corrected:    metrics.NewCounter(MetricClockCorrected, "source"),
+					correct:      tt.correct,
+					minOffset:    time.Minute,
+					maxDeviation: 5 * time.Second,
+					minSamples:   10,
+				},
+			}
+
+			var event *cloudevent.RawEvent
+			var sentAt time.Time
+			for range 10 {
+				receivedAt := time.Now().Add(-tt.queued)
+				sentAt = receivedAt.Add(tt.offset).UTC().Truncate(time.Second)
+				msg := service.NewMessage(nil)
+				msg.MetaSetMut(httpinputserver.ReceivedAtKey, httpinputserver.ReceivedAtValue(receivedAt))
+				hdrs := []cloudevent.CloudEventHeader{{
+					Type:     cloudevent.TypeStatus,
+					Producer: producer,
+					Subject:  "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:2",
+					Time:     sentAt,
+				}}
+				msgs, err := proc.createConnectionMsgs(msg, "0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8", hdrs, []byte(`{}`))
+				require.NoError(t, err)
+				event, err = processors.MsgToEvent(msgs[0])
+				require.NoError(t, err)
+			}
+
+			estimate, ok := tracker.Get(producer)
+			require.True(t, ok)
+			assert.InDelta(t, tt.offset, estimate.Offset, float64(2*time.Second))
+			// The event keeps the time it was sent with.
+			assert.Equal(t, sentAt, event.Time)
+			assert.NotContains(t, event.Extras, processors.ExtensionOriginalTime)
+			if !tt.corrected {
+				assert.NotContains(t, event.Extras, processors.ExtensionClockOffset)
+				return
+			}
+			assert.Equal(t, estimate.Offset, processors.ClockOffset(&event.CloudEventHeader))
+		})
+	}
+}
@@ -1,27 +1,29 @@
 package cloudeventconvert
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"regexp"
+	"slices"
 	"time"
 
 	"github.com/DIMO-Network/cloudevent"
 	"github.com/DIMO-Network/dis/internal/processors"
 	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
 	"github.com/DIMO-Network/dis/internal/ratedlogger"
-	"github.com/DIMO-Network/model-garage/pkg/autopi"
-	"github.com/DIMO-Network/model-garage/pkg/hashdog"
-	"github.com/DIMO-Network/model-garage/pkg/modules"
-	"github.com/DIMO-Network/model-garage/pkg/ruptela"
-	"github.com/ethereum/go-ethereum/common"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/redpanda-data/benthos/v4/public/service"
 )
 
 const (
+	// ConversionKey is the metadata key holding the Conversion of a message.
+	ConversionKey = "dimo_cloudevent_conversion"
+
 	cloudEventTypeKey     = "dimo_cloudevent_type"
 	cloudEventProducerKey = "dimo_cloudevent_producer"
 	cloudEventSubjectKey  = "dimo_cloudevent_subject"
@@ -37,6 +39,11 @@ const (
 	MaxHeaderBytes = 8 * 1024
 )
 
+var (
+	errTypeNotAllowed  = errors.New("cloud event type not allowed for source")
+	errPayloadTooLarge = errors.New("payload too large for source")
+)
+
 var erc1271magicValue = [4]byte{0x16, 0x26, 0xba, 0x7e}
 var validCharacters = regexp.MustCompile(`^[a-zA-Z0-9\-_/,. :]+$`)
 
@@ -52,6 +59,10 @@ type cloudeventProcessor struct {
 	logger          *service.Logger
 	producerLoggers map[string]*ratedlogger.Logger
 	ethClient       *ethclient.Client
+	// attestationPolicy restricts what each caller may attest to. Nil allows everything.
+	attestationPolicy *AttestationPolicy
+	// clockDrift tracks producer clock offsets. Nil disables tracking.
+	clockDrift *clockDrift
 }
 
 // Close to fulfill the service.Processor interface.
@@ -59,40 +70,13 @@ func (*cloudeventProcessor) Close(context.Context) error {
 	return nil
 }
 
-func newCloudConvertProcessor(client *ethclient.Client, lgr *service.Logger, chainID uint64, vehicleAddr, aftermarketAddr, syntheticAddr common.Address) *cloudeventProcessor {
-	// AutoPi
-	autoPiModule := &autopi.Module{
-		AftermarketContractAddr: aftermarketAddr,
-		VehicleContractAddr:     vehicleAddr,
-		ChainID:                 chainID,
-	}
-	modules.CloudEventRegistry.Override(modules.AutoPiSource.String(), autoPiModule)
-
-	// Ruptela
-	ruptelaModule := &ruptela.Module{
-		AftermarketContractAddr: aftermarketAddr,
-		VehicleContractAddr:     vehicleAddr,
-		ChainID:                 chainID}
-	modules.CloudEventRegistry.Override(modules.RuptelaSource.String(), ruptelaModule)
-
-	// Ruptela Protocol - currently handled by Kaufmann Oracle only
-	ruptelaSyntheticModule := &ruptela.Module{
-		AftermarketContractAddr: syntheticAddr,
-		VehicleContractAddr:     vehicleAddr,
-		ChainID:                 chainID,
-	}
-	modules.CloudEventRegistry.Override(modules.KaufmannSource.String(), ruptelaSyntheticModule)
-
-	// HashDog
-	hashDogModule := &hashdog.Module{
-		AftermarketContractAddr: aftermarketAddr,
-		VehicleContractAddr:     vehicleAddr,
-		ChainID:                 chainID}
-	modules.CloudEventRegistry.Override(modules.HashDogSource.String(), hashDogModule)
+func newCloudConvertProcessor(client *ethclient.Client, lgr *service.Logger, bindings []moduleBinding, policy *AttestationPolicy) *cloudeventProcessor {
+	registerModules(bindings)
 
 	return &cloudeventProcessor{
-		logger:    lgr,
-		ethClient: client,
+		logger:            lgr,
+		ethClient:         client,
+		attestationPolicy: policy,
 	}
 }
 
@@ -106,32 +90,159 @@ func (c *cloudeventProcessor) ProcessBatch(ctx context.Context, msgs service.Mes
 }
 
 func (c *cloudeventProcessor) processMsg(ctx context.Context, msg *service.Message) service.MessageBatch {
-	msgBytes, err := msg.AsBytes()
+	conv, err := Convert(ctx, msg)
 	if err != nil {
-		processors.SetError(msg, processorName, "failed to get message as bytes", err)
+		setConversionError(msg, err)
 		return service.MessageBatch{msg}
 	}
-	source, ok := msg.MetaGet(httpinputserver.DIMOCloudEventSource)
-	if !ok {
-		processors.SetError(msg, processorName, "failed to get source from message metadata", nil)
+	// The events carry their converted form from here on.
+	msg.MetaDelete(ConversionKey)
+	removeBinaryModeHeaders(msg)
+
+	switch conv.content {
+	case httpinputserver.ConnectionContent:
+		return c.processConnectionMsg(msg, conv)
+	case httpinputserver.AttestationContent:
+		return c.processAttestationMsg(msg, conv)
+	default:
+		processors.SetError(msg, processorName, "Internal error", errors.New("unknown content type"))
 		return service.MessageBatch{msg}
 	}
+}
 
-	contentType, ok := msg.MetaGet(processors.MessageContentKey)
-	if !ok {
-		processors.SetError(msg, processorName, "failed to get content type from message metadata", nil)
-		return service.MessageBatch{msg}
+// Conversion holds the CloudEvents a message converts to, after the checks of
+// the conversion that need neither chain lookups nor caller policies: the
+// payload size, header parsing and validation, and the types the source may
+// send. It is kept in the metadata of the message under ConversionKey, so the
+// steps that need it before convert_cloudevent do not convert again.
+type Conversion struct {
+	// Headers are the validated headers of the events, with defaults filled in.
+	Headers []cloudevent.CloudEventHeader
+	// Data is the data of the events.
+	Data []byte
+
+	content string
+	source  string
+	// binary is set for connection payloads sent in a binary event format.
+	binary bool
+	// attestation is the parsed event of an attestation.
+	attestation *cloudevent.RawEvent
+	// body is the message body the conversion was made from.
+	body []byte
+}
+
+// headers returns a copy of the headers that the caller may modify.
+func (c *Conversion) headers() []cloudevent.CloudEventHeader {
+	hdrs := slices.Clone(c.Headers)
+	for i := range hdrs {
+		hdrs[i].Extras = maps.Clone(hdrs[i].Extras)
 	}
+	return hdrs
+}
 
-	switch contentType {
+// conversionError is a failed conversion with the reason reported for the message.
+type conversionError struct {
+	reason string
+	err    error
+}
+
+func (e *conversionError) Error() string {
+	if e.err == nil {
+		return e.reason
+	}
+	return e.reason + ": " + e.err.Error()
+}
+
+func (e *conversionError) Unwrap() error {
+	return e.err
+}
+
+// Convert returns the conversion of msg, reusing the one kept in its metadata
+// when it was made from the current body. It lets a request be classified or
+// rejected synchronously before it is processed, without converting it again
+// in convert_cloudevent.
+func Convert(ctx context.Context, msg *service.Message) (*Conversion, error) {
+	body, err := msg.AsBytes()
+	if err != nil {
+		return nil, &conversionError{reason: "failed to read message", err: err}
+	}
+	if kept, ok := msg.MetaGetMut(ConversionKey); ok {
+		if conv, ok := kept.(*Conversion); ok && bytes.Equal(conv.body, body) {
+			return conv, nil
+		}
+	}
+	// readMsg lifts binary mode requests into the body, which is left as sent.
+	msgBytes, source, content, err := readMsg(msg.Copy())
+	if err != nil {
+		return nil, &conversionError{reason: "failed to read message", err: err}
+	}
+	conv := &Conversion{content: content, source: source, body: body}
+	switch content {
 	case httpinputserver.ConnectionContent:
-		return c.processConnectionMsg(ctx, msg, msgBytes, source)
+		err = convertConnection(ctx, msg, conv, msgBytes)
 	case httpinputserver.AttestationContent:
-		return c.processAttestationMsg(ctx, msg, msgBytes, source)
+		conv.attestation, err = parseAndValidateAttestation(msgBytes, source)
+		if err != nil {
+			err = &conversionError{reason: "failed to process attestation", err: err}
+			break
+		}
+		conv.Headers = []cloudevent.CloudEventHeader{conv.attestation.CloudEventHeader}
+		conv.Data = conv.attestation.Data
 	default:
-		processors.SetError(msg, processorName, "Internal error", errors.New("unknown content type"))
-		return service.MessageBatch{msg}
+		err = &conversionError{reason: "Internal error", err: errors.New("unknown content type")}
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg.MetaSetMut(ConversionKey, conv)
+	return conv, nil
+}
+
+// setConversionError marks msg as failed with the reason of a failed conversion.
+func setConversionError(msg *service.Message, err error) {
+	var convErr *conversionError
+	if !errors.As(err, &convErr) {
+		processors.SetError(msg, processorName, "failed to convert message", err)
+		return
+	}
+	if convErr.reason == reasonConvertFailed {
+		// Try to unmarshal convert errors
+		data, marshalErr := json.Marshal(convErr.err)
+		if marshalErr == nil {
+			msg.SetBytes(data)
+		}
+	}
+	processors.SetError(msg, processorName, convErr.reason, convErr.err)
+}
+
+// readMsg returns the body, source and content of msg. A binary mode request
+// is lifted into a structured event, which replaces the body of msg.
+func readMsg(msg *service.Message) (msgBytes []byte, source, content string, err error) {
+	msgBytes, err = msg.AsBytes()
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to get message as bytes: %w", err)
+	}
+	source, ok := msg.MetaGet(httpinputserver.DIMOCloudEventSource)
+	if !ok {
+		return nil, "", "", errors.New("failed to get source from message metadata")
+	}
+	if maxBytes := sourceconfig.For(source).MaxPayloadBytes; maxBytes > 0 && int64(len(msgBytes)) > maxBytes {
+		return nil, "", "", fmt.Errorf("%w: %d bytes exceeds max %d", errPayloadTooLarge, len(msgBytes), maxBytes)
+	}
+	content, ok = msg.MetaGet(processors.MessageContentKey)
+	if !ok {
+		return nil, "", "", errors.New("failed to get content type from message metadata")
+	}
+
+	eventJSON, isBinaryMode, err := liftBinaryModeHeaders(msg, msgBytes)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read binary mode cloud event: %w", err)
+	}
+	if isBinaryMode {
+		msgBytes = eventJSON
+		msg.SetBytes(msgBytes)
 	}
+	return msgBytes, source, content, nil
 }
 
 // validateHeadersAndSetDefaults validates the cloud event header and fills in defaults.
@@ -12,6 +12,7 @@ import (
 	"github.com/DIMO-Network/cloudevent"
 	"github.com/DIMO-Network/dis/internal/processors"
 	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
 	"github.com/DIMO-Network/dis/internal/processors/rawparquet"
 	"github.com/DIMO-Network/model-garage/pkg/modules"
 	"github.com/ethereum/go-ethereum/accounts"
@@ -754,7 +755,7 @@ func TestProcessAttestationMsg_Tombstone(t *testing.T) {
 		msg.MetaSet(processors.MessageContentKey, httpinputserver.AttestationContent)
 
 		proc := &cloudeventProcessor{}
-		out := proc.processAttestationMsg(context.Background(), msg, input, source.Hex())
+		out := proc.processMsg(context.Background(), msg)
 		require.Len(t, out, 1)
 		require.Nil(t, out[0].GetError(), "unexpected error: %v", out[0].GetError())
 
@@ -798,7 +799,7 @@ func TestProcessAttestationMsg_Tombstone(t *testing.T) {
 		msg.MetaSet(processors.MessageContentKey, httpinputserver.AttestationContent)
 
 		proc := &cloudeventProcessor{}
-		out := proc.processAttestationMsg(context.Background(), msg, input, source.Hex())
+		out := proc.processMsg(context.Background(), msg)
 		require.Len(t, out, 1)
 		require.NotNil(t, out[0].GetError(), "expected error for empty voidsId")
 	})
@@ -807,3 +808,178 @@ func TestProcessAttestationMsg_Tombstone(t *testing.T) {
 	// covered by the existing TestProcessBatch cases for `dimo.attestation`;
 	// no need to duplicate it here.
 }
+
+func TestProcessBatchSourceConfig(t *testing.T) {
+	t.Cleanup(func() { sourceconfig.Set(&sourceconfig.Registry{}) })
+	source := common.HexToAddress("0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8").Hex()
+	modules.CloudEventRegistry.Override(source, &mockCloudEventModule{
+		hdrs: []cloudevent.CloudEventHeader{{
+			ID:       "1",
+			Type:     cloudevent.TypeStatus,
+			Producer: "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:1",
+			Subject:  "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:2",
+			Time:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+	})
+	maxPayload := int64(10)
+
+	tests := []struct {
+		name          string
+		overrides     sourceconfig.Overrides
+		expectedError error
+	}{
+		{name: "no overrides"},
+		{name: "allowed type", overrides: sourceconfig.Overrides{AllowedTypes: []string{cloudevent.TypeStatus}}},
+		{name: "type not allowed", overrides: sourceconfig.Overrides{AllowedTypes: []string{cloudevent.TypeFingerprint}}, expectedError: errTypeNotAllowed},
+		{name: "payload too large", overrides: sourceconfig.Overrides{MaxPayloadBytes: &maxPayload}, expectedError: errPayloadTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceconfig.Set(&sourceconfig.Registry{Sources: map[string]sourceconfig.Overrides{source: tt.overrides}})
+			msg := service.NewMessage([]byte(`{"test": "data"}`))
+			msg.MetaSet(httpinputserver.DIMOCloudEventSource, source)
+			msg.MetaSet(processors.MessageContentKey, httpinputserver.ConnectionContent)
+
+			// Convert applies the same checks without changing the message.
+			_, validateErr := Convert(context.Background(), msg)
+			if tt.expectedError != nil {
+				require.ErrorIs(t, validateErr, tt.expectedError)
+			} else {
+				require.NoError(t, validateErr)
+			}
+			body, err := msg.AsBytes()
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"test": "data"}`, string(body))
+
+			result, err := (&cloudeventProcessor{}).ProcessBatch(context.Background(), service.MessageBatch{msg})
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			if tt.expectedError != nil {
+				require.ErrorIs(t, result[0][0].GetError(), tt.expectedError)
+				return
+			}
+			require.NoError(t, result[0][0].GetError())
+		})
+	}
+}
+
+// countingCloudEventModule counts the payloads it converts.
+type countingCloudEventModule struct {
+	mockCloudEventModule
+	calls int
+}
+
+func (m *countingCloudEventModule) CloudEventConvert(ctx context.Context, data []byte) ([]cloudevent.CloudEventHeader, []byte, error) {
+	m.calls++
+	return m.mockCloudEventModule.CloudEventConvert(ctx, data)
+}
+
+func TestConvertReusesConversion(t *testing.T) {
+	source := common.HexToAddress("0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8").Hex()
+	module := &countingCloudEventModule{mockCloudEventModule: mockCloudEventModule{
+		hdrs: []cloudevent.CloudEventHeader{{
+			Type:     cloudevent.TypeStatus,
+			Producer: "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:1",
+			Subject:  "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:2",
+			Time:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+	}}
+	modules.CloudEventRegistry.Override(source, module)
+	msg := service.NewMessage([]byte(`{"test": "data"}`))
+	msg.MetaSet(httpinputserver.DIMOCloudEventSource, source)
+	msg.MetaSet(processors.MessageContentKey, httpinputserver.ConnectionContent)
+
+	conv, err := Convert(context.Background(), msg)
+	require.NoError(t, err)
+	again, err := Convert(context.Background(), msg)
+	require.NoError(t, err)
+	assert.Same(t, conv, again)
+
+	// The events keep the defaults the first conversion chose.
+	result, err := (&cloudeventProcessor{}).ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.Len(t, result[0], 1)
+	out := result[0][0]
+	require.NoError(t, out.GetError())
+	id, _ := out.MetaGet(cloudEventIDKey)
+	assert.Equal(t, conv.Headers[0].ID, id)
+	_, kept := out.MetaGetMut(ConversionKey)
+	assert.False(t, kept, "the conversion is not passed on with the events")
+	assert.Equal(t, 1, module.calls)
+
+	// A changed body is converted again.
+	msg.SetBytes([]byte(`{"test": "other"}`))
+	_, err = Convert(context.Background(), msg)
+	require.NoError(t, err)
+	assert.Equal(t, 2, module.calls)
+}
+
+func TestProcessBatchTimePolicy(t *testing.T) {
+	t.Cleanup(func() { sourceconfig.Set(&sourceconfig.Registry{}) })
+	source := common.HexToAddress("0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8").Hex()
+	eventTime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	maxAge := 24 * time.Hour
+	policy := func(action sourceconfig.TimeAction) sourceconfig.Overrides {
+		return sourceconfig.Overrides{Types: map[string]sourceconfig.TimePolicy{
+			cloudevent.TypeStatus: {MaxAge: &maxAge, TimeAction: &action},
+		}}
+	}
+
+	tests := []struct {
+		name           string
+		overrides      sourceconfig.Overrides
+		expectedError  error
+		expectedExtras map[string]any
+		clamped        bool
+	}{
+		{name: "no past bound"},
+		{name: "logged by default", overrides: sourceconfig.Overrides{Types: map[string]sourceconfig.TimePolicy{cloudevent.TypeStatus: {MaxAge: &maxAge}}}},
+		{name: "log", overrides: policy(sourceconfig.TimeActionLog)},
+		{name: "reject", overrides: policy(sourceconfig.TimeActionReject), expectedError: processors.ErrPastTimestamp},
+		{
+			name:           "clamp",
+			overrides:      policy(sourceconfig.TimeActionClamp),
+			expectedExtras: map[string]any{processors.ExtensionTimeViolation: "past", processors.ExtensionOriginalTime: "2020-01-01T00:00:00Z"},
+			clamped:        true,
+		},
+		{
+			name:           "tag",
+			overrides:      policy(sourceconfig.TimeActionTag),
+			expectedExtras: map[string]any{processors.ExtensionTimeViolation: "past"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceconfig.Set(&sourceconfig.Registry{Sources: map[string]sourceconfig.Overrides{source: tt.overrides}})
+			modules.CloudEventRegistry.Override(source, &mockCloudEventModule{
+				hdrs: []cloudevent.CloudEventHeader{{
+					ID:       "1",
+					Type:     cloudevent.TypeStatus,
+					Producer: "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:1",
+					Subject:  "did:erc721:1:0x06012c8cf97BEaD5deAe237070F9587f8E7A266d:2",
+					Time:     eventTime,
+				}},
+			})
+			msg := service.NewMessage([]byte(`{"test": "data"}`))
+			msg.MetaSet(httpinputserver.DIMOCloudEventSource, source)
+			msg.MetaSet(processors.MessageContentKey, httpinputserver.ConnectionContent)
+
+			result, err := (&cloudeventProcessor{}).ProcessBatch(context.Background(), service.MessageBatch{msg})
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			if tt.expectedError != nil {
+				require.ErrorIs(t, result[0][0].GetError(), tt.expectedError)
+				return
+			}
+			require.NoError(t, result[0][0].GetError())
+			event, err := processors.MsgToEvent(result[0][0])
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedExtras, event.Extras)
+			if tt.clamped {
+				assert.WithinDuration(t, time.Now().Add(-maxAge), event.Time, time.Minute)
+			} else {
+				assert.Equal(t, eventTime, event.Time)
+			}
+		})
+	}
+}
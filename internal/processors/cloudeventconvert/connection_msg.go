@@ -2,39 +2,64 @@ package cloudeventconvert
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/DIMO-Network/cloudevent"
 	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
 	"github.com/DIMO-Network/dis/internal/ratedlogger"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
 	"github.com/DIMO-Network/model-garage/pkg/modules"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/redpanda-data/benthos/v4/public/service"
 	"github.com/segmentio/ksuid"
 )
 
-func (c *cloudeventProcessor) processConnectionMsg(ctx context.Context, msg *service.Message, msgBytes []byte, source string) service.MessageBatch {
-	hdrs, eventData, err := modules.ConvertToCloudEvents(ctx, source, msgBytes)
+// reasonConvertFailed is reported when the module of the source cannot convert a payload.
+const reasonConvertFailed = "failed to convert to cloud event"
+
+// convertConnection converts a connection payload with the module of its
+// source and validates the resulting headers.
+func convertConnection(ctx context.Context, msg *service.Message, conv *Conversion, msgBytes []byte) error {
+	contentType, _ := msg.MetaGet(contentTypeKey)
+	eventJSON, isBinary, err := binaryEventToJSON(contentType, msgBytes)
 	if err != nil {
-		// Try to unmarshal convert errors
-		data, marshalErr := json.Marshal(err)
-		if marshalErr == nil {
-			msg.SetBytes(data)
-		}
-		processors.SetError(msg, processorName, "failed to convert to cloud event", err)
-		return service.MessageBatch{msg}
+		return &conversionError{reason: "failed to decode binary cloud event", err: err}
+	}
+	if isBinary {
+		msgBytes = eventJSON
+		conv.binary = true
+	}
+
+	hdrs, eventData, err := modules.ConvertToCloudEvents(ctx, conv.source, msgBytes)
+	if err != nil {
+		return &conversionError{reason: reasonConvertFailed, err: err}
 	}
 	if len(hdrs) == 0 {
-		processors.SetError(msg, processorName, "no cloud events headers returned", nil)
-		return service.MessageBatch{msg}
+		return &conversionError{reason: "no cloud events headers returned"}
 	}
 	if len(eventData) == 0 {
 		// If the module chooses not to return data, use the original message
 		eventData = msgBytes
 	}
-	retBatch, err := c.createConnectionMsgs(msg, source, hdrs, eventData)
+	defaultID := ksuid.New().String()
+	settings := sourceconfig.For(conv.source)
+	for i := range hdrs {
+		if err := validateConnectionHeader(&hdrs[i], conv.source, defaultID, settings); err != nil {
+			return &conversionError{reason: "failed to create event messages", err: err}
+		}
+	}
+	conv.Headers = hdrs
+	conv.Data = eventData
+	return nil
+}
+
+func (c *cloudeventProcessor) processConnectionMsg(msg *service.Message, conv *Conversion) service.MessageBatch {
+	if conv.binary {
+		msg.MetaSetMut(contentTypeKey, "application/json")
+	}
+	retBatch, err := c.createConnectionMsgs(msg, conv.source, conv.headers(), conv.Data)
 	if err != nil {
 		processors.SetError(msg, processorName, "failed to create event messages", err)
 		return service.MessageBatch{msg}
@@ -47,27 +72,23 @@ func (c *cloudeventProcessor) createConnectionMsgs(origMsg *service.Message, sou
 		return nil, fmt.Errorf("no cloud events headers returned")
 	}
 	messages := make([]*service.Message, len(hdrs))
-	defaultID := ksuid.New().String()
-	// set defaults and metadata for each header, then create a message for each header
+	receivedAt, ok := httpinputserver.ReceivedAt(origMsg)
+	if !ok {
+		receivedAt = time.Now()
+	}
+	// set metadata for each header, then create a message for each header
 	for i := range hdrs {
 		hdr := &hdrs[i]
-		if processors.IsFutureTimestamp(hdr.Time) {
-			if c.producerLoggers == nil {
-				c.producerLoggers = make(map[string]*ratedlogger.Logger)
-			}
-			logger, ok := c.producerLoggers[hdrs[i].Producer]
-			if !ok {
-				logger = ratedlogger.New(c.logger, time.Hour)
-				c.producerLoggers[hdrs[i].Producer] = logger
-			}
-			logger.Warnf("Cloud event time is in the future: now() = %v is before event.time = %v \n %+v", time.Now(), hdrs[i].Time, hdrs[i])
-		}
 		newMsg := origMsg.Copy()
-		if err := validateHeadersAndSetDefaults(hdr, source, defaultID, false); err != nil {
-			return nil, fmt.Errorf("invalid cloud event header string: %w", err)
+		if c.clockDrift != nil {
+			c.clockDrift.apply(hdr, source, receivedAt)
 		}
-		if !isValidConnectionType(hdr) {
-			return nil, fmt.Errorf("unsupported cloud event type: %s", hdr.Type)
+		timeCheck := processors.CheckTimestamp(hdr.Time, source, hdr.Type, sourceconfig.TimeActionLog)
+		if timeCheck.Violation != "" {
+			c.producerLogger(hdr.Producer).Warnf("Cloud event time is outside of the time policy: now() = %v, event.time = %v \n %+v", time.Now(), hdr.Time, *hdr)
+		}
+		if err := timeCheck.Apply(hdr); err != nil {
+			return nil, fmt.Errorf("invalid cloud event time: %w", err)
 		}
 		setConnectionContentType(hdr, newMsg, c.logger)
 		setMetaData(hdr, newMsg)
@@ -83,6 +104,34 @@ func (c *cloudeventProcessor) createConnectionMsgs(origMsg *service.Message, sou
 	return messages, nil
 }
 
+// validateConnectionHeader fills in the defaults of a header returned by a
+// connection module and checks that the source may send it.
+func validateConnectionHeader(hdr *cloudevent.CloudEventHeader, source, defaultID string, settings sourceconfig.Settings) error {
+	if err := validateHeadersAndSetDefaults(hdr, source, defaultID, false); err != nil {
+		return fmt.Errorf("invalid cloud event header string: %w", err)
+	}
+	if !isValidConnectionType(hdr) {
+		return fmt.Errorf("unsupported cloud event type: %s", hdr.Type)
+	}
+	if !settings.AllowsType(hdr.Type) {
+		return fmt.Errorf("%w: %s", errTypeNotAllowed, hdr.Type)
+	}
+	return nil
+}
+
+// producerLogger returns the rate limited logger of a producer.
+func (c *cloudeventProcessor) producerLogger(producer string) *ratedlogger.Logger {
+	if c.producerLoggers == nil {
+		c.producerLoggers = make(map[string]*ratedlogger.Logger)
+	}
+	logger, ok := c.producerLoggers[producer]
+	if !ok {
+		logger = ratedlogger.New(c.logger, time.Hour)
+		c.producerLoggers[producer] = logger
+	}
+	return logger
+}
+
 func setConnectionContentType(eventHdr *cloudevent.CloudEventHeader, msg *service.Message, logger *service.Logger) {
 	if !isValidConnectionHeader(eventHdr, logger) {
 		logger.Warnf("invalid cloud event header for header=%+v", eventHdr)
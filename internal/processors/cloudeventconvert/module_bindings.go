@@ -0,0 +1,264 @@
+package cloudeventconvert
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/DIMO-Network/model-garage/pkg/autopi"
+	"github.com/DIMO-Network/model-garage/pkg/defaultmodule"
+	"github.com/DIMO-Network/model-garage/pkg/hashdog"
+	"github.com/DIMO-Network/model-garage/pkg/modules"
+	"github.com/DIMO-Network/model-garage/pkg/ruptela"
+	"github.com/DIMO-Network/model-garage/pkg/tesla"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/redpanda-data/benthos/v4/public/bloblang"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	bindingSourceFieldName          = "source"
+	bindingModuleFieldName          = "module"
+	bindingDeviceContractFieldName  = "device_contract"
+	bindingVehicleContractFieldName = "vehicle_contract"
+	bindingCloudEventMappingField   = "cloudevent_mapping"
+	bindingSignalsMappingField      = "signals_mapping"
+	bindingPluginFileFieldName      = "plugin_file"
+	bindingPluginMemoryFieldName    = "plugin_max_memory_mb"
+	bindingPluginTimeoutFieldName   = "plugin_timeout"
+
+	deviceContractAftermarket = "aftermarket"
+	deviceContractSynthetic   = "synthetic"
+
+	moduleTypeDefault  = "default"
+	moduleTypeBloblang = "bloblang"
+	moduleTypeWASM     = "wasm"
+)
+
+// namedSources are the connection sources that can be bound by name instead of address.
+var namedSources = map[string]common.Address{
+	"autopi":   modules.AutoPiSource,
+	"ruptela":  modules.RuptelaSource,
+	"kaufmann": modules.KaufmannSource,
+	"hashdog":  modules.HashDogSource,
+	"tesla":    modules.TeslaSource,
+}
+
+// contractModules are the module types that build DIDs from the contract addresses of their binding.
+var contractModules = map[string]func(chainID uint64, deviceAddr, vehicleAddr common.Address) any{
+	"autopi": func(chainID uint64, deviceAddr, vehicleAddr common.Address) any {
+		return &autopi.Module{ChainID: chainID, AftermarketContractAddr: deviceAddr, VehicleContractAddr: vehicleAddr}
+	},
+	"ruptela": func(chainID uint64, deviceAddr, vehicleAddr common.Address) any {
+		return &ruptela.Module{ChainID: chainID, AftermarketContractAddr: deviceAddr, VehicleContractAddr: vehicleAddr}
+	},
+	"hashdog": func(chainID uint64, deviceAddr, vehicleAddr common.Address) any {
+		return &hashdog.Module{ChainID: chainID, AftermarketContractAddr: deviceAddr, VehicleContractAddr: vehicleAddr}
+	},
+}
+
+// plainModules are the module types without contract settings.
+var plainModules = map[string]func() any{
+	"tesla":           func() any { return &tesla.Module{} },
+	moduleTypeDefault: func() any { return &defaultmodule.Module{} },
+}
+
+// defaultModuleBindings are the bindings used when none are configured.
+var defaultModuleBindings = []any{
+	defaultBinding("autopi", "autopi", ""),
+	defaultBinding("ruptela", "ruptela", ""),
+	// Ruptela Protocol - currently handled by Kaufmann Oracle only
+	defaultBinding("kaufmann", "ruptela", deviceContractSynthetic),
+	defaultBinding("hashdog", "hashdog", ""),
+}
+
+// defaultBinding spells out every field, as field defaults do not apply to the default of an object list.
+func defaultBinding(source, module, deviceContract string) map[string]any {
+	return map[string]any{
+		bindingSourceFieldName:          source,
+		bindingModuleFieldName:          module,
+		bindingDeviceContractFieldName:  deviceContract,
+		bindingVehicleContractFieldName: "",
+	}
+}
+
+var moduleBindingsField = service.NewObjectListField(moduleBindingsFieldName,
+	service.NewStringField(bindingSourceFieldName).Description("Connection source address, or one of autopi, ruptela, kaufmann, hashdog and tesla."),
+	service.NewStringField(bindingModuleFieldName).Description("Module type: autopi, ruptela, hashdog, tesla, default, bloblang or wasm."),
+	service.NewStringField(bindingDeviceContractFieldName).Default("").Description("Device contract of the module: aftermarket, synthetic or a contract address. Defaults to aftermarket for module types with contracts."),
+	service.NewStringField(bindingVehicleContractFieldName).Default("").Description("Vehicle contract address of the module. Defaults to vehicle_nft_address."),
+	service.NewBloblangField(bindingCloudEventMappingField).Optional().Description("Mapping of a JSON payload to one CloudEvent header or a list of them. Required for bloblang modules. The payload becomes the event data."),
+	service.NewBloblangField(bindingSignalsMappingField).Optional().Description("Mapping of the event data to a list of signals with name, timestamp and value fields, for bloblang modules."),
+	service.NewStringField(bindingPluginFileFieldName).Optional().Description("Path to the WebAssembly plugin of a wasm module."),
+	service.NewIntField(bindingPluginMemoryFieldName).Default(64).Description("Maximum memory of a wasm module plugin in MiB."),
+	service.NewDurationField(bindingPluginTimeoutFieldName).Default("1s").Description("Maximum duration of a wasm module plugin call."),
+).Default(defaultModuleBindings).
+	Description("Binds connection sources to conversion modules. Sources without a binding use the module model-garage registers for them, or the default module.")
+
+// moduleBinding binds a connection source to the conversions of a module. A
+// nil conversion is left to the module registered for the source otherwise.
+type moduleBinding struct {
+	source      string
+	cloudEvent  modules.CloudEventModule
+	signal      modules.SignalModule
+	fingerprint modules.FingerprintModule
+	event       modules.EventModule
+}
+
+// bindModule binds source to every conversion module implements.
+func bindModule(source common.Address, module any) moduleBinding {
+	binding := moduleBinding{source: source.Hex()}
+	binding.cloudEvent, _ = module.(modules.CloudEventModule)
+	binding.signal, _ = module.(modules.SignalModule)
+	binding.fingerprint, _ = module.(modules.FingerprintModule)
+	binding.event, _ = module.(modules.EventModule)
+	return binding
+}
+
+// contractAddrs are the contract addresses bindings may refer to by name.
+type contractAddrs struct {
+	vehicle     common.Address
+	aftermarket common.Address
+	synthetic   common.Address
+}
+
+// parseModuleBindings validates the configured bindings and builds their modules.
+func parseModuleBindings(confs []*service.ParsedConfig, chainID uint64, addrs contractAddrs) ([]moduleBinding, error) {
+	bindings := make([]moduleBinding, 0, len(confs))
+	seen := make(map[string]struct{}, len(confs))
+	for i, conf := range confs {
+		binding, err := parseModuleBinding(conf, chainID, addrs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid module binding %d: %w", i, err)
+		}
+		if _, ok := seen[binding.source]; ok {
+			return nil, fmt.Errorf("invalid module binding %d: source %s is bound more than once", i, binding.source)
+		}
+		seen[binding.source] = struct{}{}
+		bindings = append(bindings, binding)
+	}
+	return bindings, nil
+}
+
+func parseModuleBinding(conf *service.ParsedConfig, chainID uint64, addrs contractAddrs) (moduleBinding, error) {
+	sourceName, err := conf.FieldString(bindingSourceFieldName)
+	if err != nil {
+		return moduleBinding{}, fmt.Errorf("failed to get %s: %w", bindingSourceFieldName, err)
+	}
+	source, ok := namedSources[strings.ToLower(sourceName)]
+	if !ok {
+		if !common.IsHexAddress(sourceName) {
+			return moduleBinding{}, fmt.Errorf("invalid source: %s", sourceName)
+		}
+		source = common.HexToAddress(sourceName)
+	}
+	moduleType, err := conf.FieldString(bindingModuleFieldName)
+	if err != nil {
+		return moduleBinding{}, fmt.Errorf("failed to get %s: %w", bindingModuleFieldName, err)
+	}
+	deviceContract, err := conf.FieldString(bindingDeviceContractFieldName)
+	if err != nil {
+		return moduleBinding{}, fmt.Errorf("failed to get %s: %w", bindingDeviceContractFieldName, err)
+	}
+	vehicleContract, err := conf.FieldString(bindingVehicleContractFieldName)
+	if err != nil {
+		return moduleBinding{}, fmt.Errorf("failed to get %s: %w", bindingVehicleContractFieldName, err)
+	}
+
+	cloudEventMapping, err := optionalMapping(conf, bindingCloudEventMappingField)
+	if err != nil {
+		return moduleBinding{}, err
+	}
+	signalsMapping, err := optionalMapping(conf, bindingSignalsMappingField)
+	if err != nil {
+		return moduleBinding{}, err
+	}
+	hasContracts := deviceContract != "" || vehicleContract != ""
+	hasMappings := cloudEventMapping != nil || signalsMapping != nil
+	hasPlugin := conf.Contains(bindingPluginFileFieldName)
+	if _, ok := contractModules[moduleType]; hasContracts && !ok {
+		return moduleBinding{}, fmt.Errorf("module %s does not take contract addresses", moduleType)
+	}
+	if hasMappings && moduleType != moduleTypeBloblang {
+		return moduleBinding{}, fmt.Errorf("module %s does not take mappings", moduleType)
+	}
+	if hasPlugin && moduleType != moduleTypeWASM {
+		return moduleBinding{}, fmt.Errorf("module %s does not take a plugin", moduleType)
+	}
+
+	switch moduleType {
+	case moduleTypeBloblang:
+		if cloudEventMapping == nil {
+			return moduleBinding{}, fmt.Errorf("module %s requires %s", moduleType, bindingCloudEventMappingField)
+		}
+		module, err := newBloblangModule(cloudEventMapping, signalsMapping)
+		if err != nil {
+			return moduleBinding{}, err
+		}
+		return bindModule(source, module), nil
+	case moduleTypeWASM:
+		if !hasPlugin {
+			return moduleBinding{}, fmt.Errorf("module %s requires %s", moduleType, bindingPluginFileFieldName)
+		}
+		plugin, err := loadPlugin(conf)
+		if err != nil {
+			return moduleBinding{}, err
+		}
+		return bindWASMModule(source, plugin), nil
+	}
+	if newModule, ok := plainModules[moduleType]; ok {
+		return bindModule(source, newModule()), nil
+	}
+	newModule, ok := contractModules[moduleType]
+	if !ok {
+		return moduleBinding{}, fmt.Errorf("unknown module: %s", moduleType)
+	}
+	var deviceAddr common.Address
+	switch deviceContract {
+	case "", deviceContractAftermarket:
+		deviceAddr = addrs.aftermarket
+	case deviceContractSynthetic:
+		deviceAddr = addrs.synthetic
+	default:
+		if !common.IsHexAddress(deviceContract) {
+			return moduleBinding{}, fmt.Errorf("invalid device contract: %s", deviceContract)
+		}
+		deviceAddr = common.HexToAddress(deviceContract)
+	}
+	vehicleAddr := addrs.vehicle
+	if vehicleContract != "" {
+		if !common.IsHexAddress(vehicleContract) {
+			return moduleBinding{}, fmt.Errorf("invalid vehicle contract: %s", vehicleContract)
+		}
+		vehicleAddr = common.HexToAddress(vehicleContract)
+	}
+	return bindModule(source, newModule(chainID, deviceAddr, vehicleAddr)), nil
+}
+
+func optionalMapping(conf *service.ParsedConfig, name string) (*bloblang.Executor, error) {
+	if !conf.Contains(name) {
+		return nil, nil
+	}
+	mapping, err := conf.FieldBloblang(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", name, err)
+	}
+	return mapping, nil
+}
+
+// registerModules registers the conversions of each binding in their registries.
+func registerModules(bindings []moduleBinding) {
+	for _, binding := range bindings {
+		if binding.cloudEvent != nil {
+			modules.CloudEventRegistry.Override(binding.source, binding.cloudEvent)
+		}
+		if binding.signal != nil {
+			modules.SignalRegistry.Override(binding.source, binding.signal)
+		}
+		if binding.fingerprint != nil {
+			modules.FingerprintRegistry.Override(binding.source, binding.fingerprint)
+		}
+		if binding.event != nil {
+			modules.EventRegistry.Override(binding.source, binding.event)
+		}
+	}
+}
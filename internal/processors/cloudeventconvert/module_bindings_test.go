@@ -0,0 +1,103 @@
+package cloudeventconvert
+
+import (
+	"testing"
+
+	"github.com/DIMO-Network/model-garage/pkg/defaultmodule"
+	"github.com/DIMO-Network/model-garage/pkg/modules"
+	"github.com/DIMO-Network/model-garage/pkg/ruptela"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testContractAddrs = contractAddrs{
+	vehicle:     common.HexToAddress("0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8"),
+	aftermarket: common.HexToAddress("0x325b45949C833986bC98e98a49F3CA5C5c4643B5"),
+	synthetic:   common.HexToAddress("0x78513c8CB4D6B6079f813850376bc9c7fc8aE67f"),
+}
+
+func parseTestBindings(t *testing.T, yaml string) ([]moduleBinding, error) {
+	t.Helper()
+	spec := service.NewConfigSpec().Field(moduleBindingsField)
+	conf, err := spec.ParseYAML(yaml, nil)
+	require.NoError(t, err)
+	confs, err := conf.FieldObjectList(moduleBindingsFieldName)
+	require.NoError(t, err)
+	return parseModuleBindings(confs, 80002, testContractAddrs)
+}
+
+func TestParseModuleBindingsDefault(t *testing.T) {
+	bindings, err := parseTestBindings(t, "{}")
+	require.NoError(t, err)
+	require.Len(t, bindings, 4)
+
+	// Kaufmann runs the Ruptela module against the synthetic device contract.
+	assert.Equal(t, modules.KaufmannSource.Hex(), bindings[2].source)
+	assert.Equal(t, &ruptela.Module{
+		ChainID:                 80002,
+		AftermarketContractAddr: testContractAddrs.synthetic,
+		VehicleContractAddr:     testContractAddrs.vehicle,
+	}, bindings[2].cloudEvent)
+}
+
+func TestParseModuleBindings(t *testing.T) {
+	const customSource = "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b"
+	customDevice := common.HexToAddress("0x9c94C395cBcBDe662235E0A9d3bB87Ad708561BA")
+	tests := []struct {
+		name        string
+		yaml        string
+		expected    moduleBinding
+		expectError bool
+	}{
+		{
+			name: "address with explicit contracts",
+			yaml: `
+module_bindings:
+  - source: "` + customSource + `"
+    module: ruptela
+    device_contract: "` + customDevice.Hex() + `"
+    vehicle_contract: "` + testContractAddrs.aftermarket.Hex() + `"
+`,
+			expected: bindModule(common.HexToAddress(customSource),
+				&ruptela.Module{ChainID: 80002, AftermarketContractAddr: customDevice, VehicleContractAddr: testContractAddrs.aftermarket}),
+		},
+		{
+			name:     "default module",
+			yaml:     "module_bindings:\n  - source: tesla\n    module: default\n",
+			expected: bindModule(modules.TeslaSource, &defaultmodule.Module{}),
+		},
+		{name: "unknown module", yaml: "module_bindings:\n  - source: autopi\n    module: unknown\n", expectError: true},
+		{name: "invalid source", yaml: "module_bindings:\n  - source: unknown\n    module: autopi\n", expectError: true},
+		{name: "duplicate source", yaml: "module_bindings:\n  - source: autopi\n    module: autopi\n  - source: \"" + modules.AutoPiSource.Hex() + "\"\n    module: default\n", expectError: true},
+		{name: "contract on module without contracts", yaml: "module_bindings:\n  - source: tesla\n    module: tesla\n    device_contract: synthetic\n", expectError: true},
+		{name: "invalid device contract", yaml: "module_bindings:\n  - source: autopi\n    module: autopi\n    device_contract: other\n", expectError: true},
+		{name: "invalid vehicle contract", yaml: "module_bindings:\n  - source: autopi\n    module: autopi\n    vehicle_contract: other\n", expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bindings, err := parseTestBindings(t, tt.yaml)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, bindings, 1)
+			assert.Equal(t, tt.expected, bindings[0])
+		})
+	}
+}
+
+func TestRegisterModules(t *testing.T) {
+	source := common.HexToAddress("0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b")
+	module := &ruptela.Module{ChainID: 80002}
+	registerModules([]moduleBinding{bindModule(source, module)})
+
+	ceModule, ok := modules.CloudEventRegistry.Get(source.Hex())
+	require.True(t, ok)
+	assert.Same(t, module, ceModule)
+	eventModule, ok := modules.EventRegistry.Get(source.Hex())
+	require.True(t, ok)
+	assert.Same(t, module, eventModule)
+}
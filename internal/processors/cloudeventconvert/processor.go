@@ -3,6 +3,7 @@ package cloudeventconvert
 import (
 	"fmt"
 
+	"github.com/DIMO-Network/dis/internal/clockdrift"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/redpanda-data/benthos/v4/public/service"
@@ -16,6 +17,13 @@ const (
 	syntheticAddressFieldName   = "synthetic_nft_address"
 	chainIDFieldName            = "chain_id"
 	rpcURLFieldName             = "rpc_url"
+	attestationPolicyFieldName  = "attestation_policy_file"
+	moduleBindingsFieldName     = "module_bindings"
+	clockProducersFieldName     = "clock_drift_producers"
+	clockCorrectionFieldName    = "clock_correction"
+	clockMinOffsetFieldName     = "clock_correction_min_offset"
+	clockMaxDeviationFieldName  = "clock_correction_max_deviation"
+	clockMinSamplesFieldName    = "clock_correction_min_samples"
 )
 
 var configSpec = service.NewConfigSpec().
@@ -24,7 +32,17 @@ var configSpec = service.NewConfigSpec().
 	Field(service.NewStringField(vehicleAddressFieldName).Description("Ethereum address for the vehicles contract")).
 	Field(service.NewStringField(aftermarketAddressFieldName).Description("Ethereum address for the aftermarket contract")).
 	Field(service.NewStringField(syntheticAddressFieldName).Description("Ethereum address for the synthetic device contract")).
-	Field(service.NewStringField(rpcURLFieldName).Description("RPC URL"))
+	Field(service.NewStringField(rpcURLFieldName).Description("RPC URL")).
+	Field(service.NewStringField(attestationPolicyFieldName).Default("").Description("Path to a YAML policy restricting which attestation types and subjects each caller may write. Every attestation is allowed when empty.")).
+	Field(moduleBindingsField).
+	Field(service.NewIntField(clockProducersFieldName).Default(100000).Description("Maximum number of producers whose clock offset is tracked at once.")).
+	Field(service.NewBoolField(clockCorrectionFieldName).Default(false).Description("Correct the signals of connection events from producers whose clock is stably ahead by their clock offset. The events keep the time they were sent with and record the offset in the dimoclockoffset extension.")).
+	Field(service.NewDurationField(clockMinOffsetFieldName).Default("1m").Description("Smallest clock offset that is corrected.")).
+	Field(service.NewDurationField(clockMaxDeviationFieldName).Default("5s").Description("Largest average deviation of a producer's offsets for its offset to be considered stable.")).
+	Field(service.NewIntField(clockMinSamplesFieldName).Default(20).Description("Number of events a producer's offset must be based on before it is corrected."))
+
+// clockDriftKey identifies the clock drift tracker shared by all processor threads.
+type clockDriftKey struct{}
 
 func init() {
 	err := service.RegisterBatchProcessor(processorName, configSpec, ctor)
@@ -64,13 +82,77 @@ func ctor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchProce
 		return nil, fmt.Errorf("failed to get %s: %w", rpcURLFieldName, err)
 	}
 
+	bindingConfs, err := cfg.FieldObjectList(moduleBindingsFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", moduleBindingsFieldName, err)
+	}
+	bindings, err := parseModuleBindings(bindingConfs, uint64(chainID), contractAddrs{
+		vehicle:     common.HexToAddress(vehicleAddress),
+		aftermarket: common.HexToAddress(aftermarketAddress),
+		synthetic:   common.HexToAddress(syntheticAddress),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	policyFile, err := cfg.FieldString(attestationPolicyFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", attestationPolicyFieldName, err)
+	}
+	policy, err := LoadAttestationPolicy(policyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	drift, err := parseClockDrift(cfg, mgr)
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := ethclient.Dial(rpcUrl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to rpc url %s: %w", rpcURLFieldName, err)
 	}
 
-	return newCloudConvertProcessor(client, mgr.Logger(), uint64(chainID),
-		common.HexToAddress(vehicleAddress),
-		common.HexToAddress(aftermarketAddress),
-		common.HexToAddress(syntheticAddress)), nil
+	proc := newCloudConvertProcessor(client, mgr.Logger(), bindings, policy)
+	proc.clockDrift = drift
+	return proc, nil
+}
+
+func parseClockDrift(cfg *service.ParsedConfig, mgr *service.Resources) (*clockDrift, error) {
+	producers, err := cfg.FieldInt(clockProducersFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", clockProducersFieldName, err)
+	}
+	correct, err := cfg.FieldBool(clockCorrectionFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", clockCorrectionFieldName, err)
+	}
+	minOffset, err := cfg.FieldDuration(clockMinOffsetFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", clockMinOffsetFieldName, err)
+	}
+	maxDeviation, err := cfg.FieldDuration(clockMaxDeviationFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", clockMaxDeviationFieldName, err)
+	}
+	minSamples, err := cfg.FieldInt(clockMinSamplesFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", clockMinSamplesFieldName, err)
+	}
+	tracker, err := clockdrift.NewTracker(producers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", clockProducersFieldName, err)
+	}
+	// Every pipeline thread gets its own processor, but they must share estimates.
+	shared, _ := mgr.GetOrSetGeneric(clockDriftKey{}, tracker)
+	return &clockDrift{
+		tracker:      shared.(*clockdrift.Tracker),
+		offsets:      mgr.Metrics().NewTimer(MetricClockOffset, "source", "direction"),
+		corrected:    mgr.Metrics().NewCounter(MetricClockCorrected, "source"),
+		correct:      correct,
+		minOffset:    minOffset,
+		maxDeviation: maxDeviation,
+		minSamples:   minSamples,
+	}, nil
 }
@@ -0,0 +1,104 @@
+package cloudeventconvert
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/wasmplugin"
+	"github.com/DIMO-Network/model-garage/pkg/defaultmodule"
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+// wasmModule converts payloads with a WebAssembly plugin. Signals and events
+// returned by the plugin use the default module format and are validated by it.
+type wasmModule struct {
+	plugin   *wasmplugin.Plugin
+	defaults *defaultmodule.Module
+}
+
+func loadPlugin(conf *service.ParsedConfig) (*wasmplugin.Plugin, error) {
+	path, err := conf.FieldString(bindingPluginFileFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", bindingPluginFileFieldName, err)
+	}
+	memoryMB, err := conf.FieldInt(bindingPluginMemoryFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", bindingPluginMemoryFieldName, err)
+	}
+	timeout, err := conf.FieldDuration(bindingPluginTimeoutFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", bindingPluginTimeoutFieldName, err)
+	}
+	plugin, err := wasmplugin.Load(context.Background(), path, wasmplugin.Limits{
+		MemoryBytes: int64(memoryMB) << 20,
+		Timeout:     timeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load plugin: %w", err)
+	}
+	return plugin, nil
+}
+
+// bindWASMModule binds source to the conversions the plugin exports.
+func bindWASMModule(source common.Address, plugin *wasmplugin.Plugin) moduleBinding {
+	module := &wasmModule{plugin: plugin, defaults: &defaultmodule.Module{}}
+	binding := moduleBinding{source: source.Hex()}
+	if plugin.Exports(wasmplugin.FuncCloudEventConvert) {
+		binding.cloudEvent = module
+	}
+	if plugin.Exports(wasmplugin.FuncSignalConvert) {
+		binding.signal = module
+	}
+	if plugin.Exports(wasmplugin.FuncEventConvert) {
+		binding.event = module
+	}
+	return binding
+}
+
+// CloudEventConvert passes the payload to the plugin, which returns the headers and optionally the event data.
+func (m *wasmModule) CloudEventConvert(ctx context.Context, msgData []byte) ([]cloudevent.CloudEventHeader, []byte, error) {
+	var out struct {
+		Headers []cloudevent.CloudEventHeader `json:"headers"`
+		Data    json.RawMessage               `json:"data"`
+	}
+	if err := m.plugin.Call(ctx, wasmplugin.FuncCloudEventConvert, msgData, &out); err != nil {
+		return nil, nil, err
+	}
+	return out.Headers, out.Data, nil
+}
+
+// SignalConvert passes the event to the plugin, which returns {"signals": [...]}.
+func (m *wasmModule) SignalConvert(ctx context.Context, event cloudevent.RawEvent) ([]vss.Signal, error) {
+	data, err := m.convertEvent(ctx, wasmplugin.FuncSignalConvert, event)
+	if err != nil {
+		return nil, err
+	}
+	event.Data = data
+	return m.defaults.SignalConvert(ctx, event)
+}
+
+// EventConvert passes the event to the plugin, which returns {"events": [...]}.
+func (m *wasmModule) EventConvert(ctx context.Context, event cloudevent.RawEvent) ([]vss.Event, error) {
+	data, err := m.convertEvent(ctx, wasmplugin.FuncEventConvert, event)
+	if err != nil {
+		return nil, err
+	}
+	event.Data = data
+	return m.defaults.EventConvert(ctx, event)
+}
+
+func (m *wasmModule) convertEvent(ctx context.Context, name string, event cloudevent.RawEvent) (json.RawMessage, error) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	var out json.RawMessage
+	if err := m.plugin.Call(ctx, name, input, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,81 @@
+package cloudeventconvert
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// buildTestPlugin compiles the wasmplugin test plugin to WebAssembly.
+func buildTestPlugin(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", path, ".")
+	cmd.Dir = filepath.Join("..", "..", "wasmplugin", "testdata", "plugin")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return path
+}
+
+func TestWASMModule(t *testing.T) {
+	bindings, err := parseTestBindings(t, `
+module_bindings:
+  - source: "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b"
+    module: wasm
+    plugin_file: "`+buildTestPlugin(t)+`"
+    plugin_timeout: 5s
+`)
+	require.NoError(t, err)
+	binding := bindings[0]
+	require.NotNil(t, binding.cloudEvent)
+	require.NotNil(t, binding.signal)
+	// The plugin does not export event_convert, so events stay with the default module.
+	assert.Nil(t, binding.event)
+
+	hdrs, data, err := binding.cloudEvent.CloudEventConvert(context.Background(), []byte(`{"id":"p1","vehicle":12,"time":"2025-01-02T03:04:05Z","speed":42.5}`))
+	require.NoError(t, err)
+	require.Len(t, hdrs, 1)
+	assert.Equal(t, "p1", hdrs[0].ID)
+	assert.Equal(t, cloudevent.TypeStatus, hdrs[0].Type)
+	assert.Equal(t, "did:erc721:80002:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:12", hdrs[0].Subject)
+	assert.JSONEq(t, `{"speed":42.5,"time":"2025-01-02T03:04:05Z"}`, string(data))
+
+	signals, err := binding.signal.SignalConvert(context.Background(), cloudevent.RawEvent{CloudEventHeader: hdrs[0], Data: data})
+	require.NoError(t, err)
+	require.Len(t, signals, 1)
+	assert.Equal(t, vss.SignalData{
+		Timestamp:    time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Name:         vss.FieldSpeed,
+		ValueNumber:  42.5,
+		CloudEventID: "p1",
+	}, signals[0].Data)
+
+	_, _, err = binding.cloudEvent.CloudEventConvert(context.Background(), []byte(`{"mode":"fail"}`))
+	require.ErrorContains(t, err, "bad payload")
+}
+
+func TestWASMBindingErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{name: "missing plugin", yaml: "module_bindings:\n  - source: autopi\n    module: wasm\n"},
+		{name: "plugin on go module", yaml: "module_bindings:\n  - source: autopi\n    module: autopi\n    plugin_file: plugin.wasm\n"},
+		{name: "plugin not found", yaml: "module_bindings:\n  - source: autopi\n    module: wasm\n    plugin_file: " + filepath.Join(t.TempDir(), "missing.wasm") + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseTestBindings(t, tt.yaml)
+			require.Error(t, err)
+		})
+	}
+}
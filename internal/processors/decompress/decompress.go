@@ -0,0 +1,213 @@
+// Package decompress decodes gzip and zstd encoded request bodies so devices on
+// metered links can send compressed payloads.
+package decompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/klauspost/compress/zstd"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// ContentEncodingKey is the metadata key the HTTP inputs store the Content-Encoding header under.
+	ContentEncodingKey = "Content-Encoding"
+	// RawBytesKey is the metadata key holding the size of the body as received.
+	RawBytesKey = "dimo_raw_bytes"
+	// DecompressedBytesKey is the metadata key holding the size of the decoded body.
+	DecompressedBytesKey = "dimo_decompressed_bytes"
+
+	// MetricRawBytes counts request body bytes as received, labelled by source and encoding.
+	MetricRawBytes = "dis_request_raw_bytes_total"
+	// MetricDecompressedBytes counts request body bytes after decoding, labelled by source and encoding.
+	MetricDecompressedBytes = "dis_request_decompressed_bytes_total"
+
+	// DefaultMaxBytes is the default maximum size of a decompressed body.
+	DefaultMaxBytes = 16 << 20
+
+	// decodedKey is the metadata key holding a body decoded by Decode.
+	decodedKey = "dimo_decoded_body"
+
+	encodingIdentity = "identity"
+	// defaultZstdWindow is the window size used by the reference encoder at its default level.
+	defaultZstdWindow = 8 << 20
+)
+
+var (
+	errUnsupportedEncoding = errors.New("unsupported content encoding")
+	errTooLarge            = errors.New("decompressed body exceeds size limit")
+)
+
+type processor struct {
+	maxBytes          int64
+	rawBytes          *service.MetricCounter
+	decompressedBytes *service.MetricCounter
+}
+
+func newProcessor(maxBytes int64, mgr *service.Resources) *processor {
+	return &processor{
+		maxBytes:          maxBytes,
+		rawBytes:          mgr.Metrics().NewCounter(MetricRawBytes, "source", "encoding"),
+		decompressedBytes: mgr.Metrics().NewCounter(MetricDecompressedBytes, "source", "encoding"),
+	}
+}
+
+// Close to fulfill the service.Processor interface.
+func (*processor) Close(context.Context) error {
+	return nil
+}
+
+// ProcessBatch to fulfill the service.BatchProcessor interface.
+func (p *processor) ProcessBatch(_ context.Context, msgs service.MessageBatch) ([]service.MessageBatch, error) {
+	for _, msg := range msgs {
+		p.processMsg(msg)
+	}
+	return []service.MessageBatch{msgs}, nil
+}
+
+func (p *processor) processMsg(msg *service.Message) {
+	raw, err := msg.AsBytes()
+	if err != nil {
+		processors.SetError(msg, processorName, "failed to get message as bytes", err)
+		return
+	}
+	header, _ := msg.MetaGet(ContentEncodingKey)
+	encodings := parseContentEncoding(header)
+	encodingLabel := encodingIdentity
+	if len(encodings) > 0 {
+		encodingLabel = strings.Join(encodings, ",")
+	}
+	source, _ := msg.MetaGet(httpinputserver.DIMOCloudEventSource)
+	p.rawBytes.Incr(int64(len(raw)), source, encodingLabel)
+	msg.MetaSetMut(RawBytesKey, strconv.Itoa(len(raw)))
+
+	if len(encodings) == 0 {
+		msg.MetaSetMut(DecompressedBytesKey, strconv.Itoa(len(raw)))
+		p.decompressedBytes.Incr(int64(len(raw)), source, encodingLabel)
+		return
+	}
+
+	decoded, ok := decodedBefore(msg, raw, p.maxBytes)
+	if !ok {
+		decoded, err = decode(raw, encodings, p.maxBytes)
+	}
+	if err != nil {
+		processors.SetError(msg, processorName, "failed to decompress body", err)
+		return
+	}
+	msg.SetBytes(decoded)
+	msg.MetaDelete(ContentEncodingKey)
+	msg.MetaSetMut(DecompressedBytesKey, strconv.Itoa(len(decoded)))
+	p.decompressedBytes.Incr(int64(len(decoded)), source, encodingLabel)
+}
+
+// parseContentEncoding returns the encodings in the order they were applied,
+// ignoring identity.
+func parseContentEncoding(header string) []string {
+	var encodings []string
+	for _, enc := range strings.Split(header, ",") {
+		enc = strings.ToLower(strings.TrimSpace(enc))
+		if enc == "" || enc == encodingIdentity {
+			continue
+		}
+		encodings = append(encodings, enc)
+	}
+	return encodings
+}
+
+// decodedBody is a body decoded by Decode and the body it was decoded from.
+type decodedBody struct {
+	raw     []byte
+	decoded []byte
+}
+
+// Decode returns the body of msg with its Content-Encoding undone and leaves
+// msg unchanged. The result is kept in the metadata of msg, so a body decoded
+// ahead of the processor, e.g. to classify it, is not decoded twice.
+func Decode(msg *service.Message, maxBytes int64) ([]byte, error) {
+	raw, err := msg.AsBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get message as bytes: %w", err)
+	}
+	header, _ := msg.MetaGet(ContentEncodingKey)
+	encodings := parseContentEncoding(header)
+	if len(encodings) == 0 {
+		return raw, nil
+	}
+	decoded, err := decode(raw, encodings, maxBytes)
+	if err != nil {
+		return nil, err
+	}
+	msg.MetaSetMut(decodedKey, &decodedBody{raw: raw, decoded: decoded})
+	return decoded, nil
+}
+
+// decodedBefore returns the body Decode decoded from raw, if it is within maxBytes.
+func decodedBefore(msg *service.Message, raw []byte, maxBytes int64) ([]byte, bool) {
+	kept, ok := msg.MetaGetMut(decodedKey)
+	if !ok {
+		return nil, false
+	}
+	msg.MetaDelete(decodedKey)
+	body, ok := kept.(*decodedBody)
+	if !ok || !bytes.Equal(body.raw, raw) || int64(len(body.decoded)) > maxBytes {
+		return nil, false
+	}
+	return body.decoded, true
+}
+
+// decode undoes the encodings in reverse order of application.
+func decode(body []byte, encodings []string, maxBytes int64) ([]byte, error) {
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		body, err = decodeOne(body, encodings[i], maxBytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+func decodeOne(body []byte, encoding string, maxBytes int64) ([]byte, error) {
+	var reader io.Reader
+	switch encoding {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer gz.Close()
+		reader = gz
+	case "zstd":
+		// The output is bounded below; the window bound only stops a forged frame
+		// header from allocating more than a typical encoder would use.
+		maxWindow := uint64(max(maxBytes, defaultZstdWindow))
+		zr, err := zstd.NewReader(bytes.NewReader(body), zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxWindow))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd body: %w", err)
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return nil, fmt.Errorf("%w: %s", errUnsupportedEncoding, encoding)
+	}
+
+	// Read one byte past the limit to tell a body of exactly maxBytes from a larger one.
+	decoded, err := io.ReadAll(io.LimitReader(reader, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s body: %w", encoding, err)
+	}
+	if int64(len(decoded)) > maxBytes {
+		return nil, fmt.Errorf("%w of %d bytes", errTooLarge, maxBytes)
+	}
+	return decoded, nil
+}
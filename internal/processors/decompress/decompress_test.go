@@ -0,0 +1,182 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	enc, err := zstd.NewWriter(nil)
+	require.NoError(t, err)
+	defer enc.Close()
+	return enc.EncodeAll(data, nil)
+}
+
+func TestProcessBatch(t *testing.T) {
+	payload := []byte(`{"data":{"signals":[{"name":"speed","value":55}]}}`)
+	bomb := []byte(strings.Repeat("a", 1024))
+
+	tests := []struct {
+		name             string
+		body             []byte
+		encoding         string
+		expected         []byte
+		expectedRawBytes string
+		errorIs          error
+	}{
+		{
+			name:             "uncompressed",
+			body:             payload,
+			expected:         payload,
+			expectedRawBytes: "50",
+		},
+		{
+			name:     "identity",
+			body:     payload,
+			encoding: "identity",
+			expected: payload,
+		},
+		{
+			name:     "gzip",
+			body:     gzipBytes(t, payload),
+			encoding: "gzip",
+			expected: payload,
+		},
+		{
+			name:     "zstd",
+			body:     zstdBytes(t, payload),
+			encoding: "ZSTD",
+			expected: payload,
+		},
+		{
+			name:     "gzip then zstd",
+			body:     zstdBytes(t, gzipBytes(t, payload)),
+			encoding: "gzip, zstd",
+			expected: payload,
+		},
+		{
+			name:     "gzip over limit",
+			body:     gzipBytes(t, bomb),
+			encoding: "gzip",
+			errorIs:  errTooLarge,
+		},
+		{
+			name:     "zstd over limit",
+			body:     zstdBytes(t, bomb),
+			encoding: "zstd",
+			errorIs:  errTooLarge,
+		},
+		{
+			name:     "unsupported encoding",
+			body:     payload,
+			encoding: "br",
+			errorIs:  errUnsupportedEncoding,
+		},
+		{
+			name:     "corrupt gzip",
+			body:     payload,
+			encoding: "gzip",
+		},
+	}
+
+	proc := newProcessor(512, service.MockResources())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := service.NewMessage(tt.body)
+			if tt.encoding != "" {
+				msg.MetaSetMut(ContentEncodingKey, tt.encoding)
+			}
+			_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+			require.NoError(t, err)
+
+			if tt.expected == nil {
+				require.Error(t, msg.GetError())
+				if tt.errorIs != nil {
+					require.ErrorIs(t, msg.GetError(), tt.errorIs)
+				}
+				return
+			}
+			require.NoError(t, msg.GetError())
+			out, err := msg.AsBytes()
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, out)
+
+			_, hasEncoding := msg.MetaGet(ContentEncodingKey)
+			assert.Equal(t, tt.encoding == "identity", hasEncoding)
+			decompressed, _ := msg.MetaGet(DecompressedBytesKey)
+			assert.Equal(t, "50", decompressed)
+			if tt.expectedRawBytes != "" {
+				raw, _ := msg.MetaGet(RawBytesKey)
+				assert.Equal(t, tt.expectedRawBytes, raw)
+			}
+		})
+	}
+}
+
+func TestDecodeReused(t *testing.T) {
+	payload := []byte(`{"data":{"signals":[{"name":"speed","value":55}]}}`)
+	proc := newProcessor(512, service.MockResources())
+
+	msg := service.NewMessage(gzipBytes(t, payload))
+	msg.MetaSetMut(ContentEncodingKey, "gzip")
+	decoded, err := Decode(msg, 512)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+	encoding, _ := msg.MetaGet(ContentEncodingKey)
+	assert.Equal(t, "gzip", encoding, "Decode must leave the message unchanged")
+
+	// The processor takes the kept body instead of decoding again.
+	kept, _ := msg.MetaGetMut(decodedKey)
+	kept.(*decodedBody).decoded = []byte("kept")
+	_, err = proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.NoError(t, msg.GetError())
+	out, err := msg.AsBytes()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("kept"), out)
+	_, hasKept := msg.MetaGetMut(decodedKey)
+	assert.False(t, hasKept)
+
+	// A body changed after Decode is decoded again.
+	msg = service.NewMessage(gzipBytes(t, payload))
+	msg.MetaSetMut(ContentEncodingKey, "gzip")
+	_, err = Decode(msg, 512)
+	require.NoError(t, err)
+	kept, _ = msg.MetaGetMut(decodedKey)
+	kept.(*decodedBody).decoded = []byte("stale")
+	msg.SetBytes(zstdBytes(t, payload))
+	msg.MetaSetMut(ContentEncodingKey, "zstd")
+	_, err = proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	out, err = msg.AsBytes()
+	require.NoError(t, err)
+	assert.Equal(t, payload, out)
+
+	// The processor still enforces its own limit on a kept body.
+	msg = service.NewMessage(gzipBytes(t, bytes.Repeat([]byte("a"), 1024)))
+	msg.MetaSetMut(ContentEncodingKey, "gzip")
+	_, err = Decode(msg, 4096)
+	require.NoError(t, err)
+	_, err = proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.ErrorIs(t, msg.GetError(), errTooLarge)
+}
@@ -0,0 +1,34 @@
+package decompress
+
+import (
+	"fmt"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	processorName                 = "dimo_decompress"
+	maxDecompressedBytesFieldName = "max_decompressed_bytes"
+)
+
+var configSpec = service.NewConfigSpec().
+	Summary("Decodes request bodies according to their Content-Encoding header (gzip or zstd)").
+	Field(service.NewIntField(maxDecompressedBytesFieldName).Default(DefaultMaxBytes).Description("Maximum size of a decompressed body. Larger bodies are rejected to protect against decompression bombs."))
+
+func init() {
+	err := service.RegisterBatchProcessor(processorName, configSpec, ctor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func ctor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchProcessor, error) {
+	maxBytes, err := cfg.FieldInt(maxDecompressedBytesFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", maxDecompressedBytesFieldName, err)
+	}
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("%s must be positive", maxDecompressedBytesFieldName)
+	}
+	return newProcessor(int64(maxBytes), mgr), nil
+}
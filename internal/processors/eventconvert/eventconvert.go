@@ -6,6 +6,7 @@ import (
 
 	"github.com/DIMO-Network/cloudevent"
 	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
 	"github.com/DIMO-Network/model-garage/pkg/convert"
 	"github.com/DIMO-Network/model-garage/pkg/modules"
 	"github.com/DIMO-Network/model-garage/pkg/vss"
@@ -44,6 +45,9 @@ func (e *eventsProcessor) processMsg(ctx context.Context, msg *service.Message)
 		// leave the message as is and continue to the next message
 		return retBatch
 	}
+	if !sourceconfig.For(rawEvent.Source).ExtractEvents {
+		return retBatch
+	}
 
 	events, err := modules.ConvertToEvents(ctx, rawEvent.Source, *rawEvent)
 	if err != nil {
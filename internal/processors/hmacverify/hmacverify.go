@@ -0,0 +1,115 @@
+// Package hmacverify checks the body signature of connection messages that
+// arrived through the HMAC authenticated HTTP input.
+package hmacverify
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+var (
+	errMissingMeta     = errors.New("hmac metadata missing from message")
+	errReplayedRequest = errors.New("request signature has already been used")
+)
+
+type processor struct {
+	logger  *service.Logger
+	keyring httpinputserver.HMACKeyring
+	window  time.Duration
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+}
+
+func newProcessor(keyring httpinputserver.HMACKeyring, window time.Duration, lgr *service.Logger) *processor {
+	return &processor{
+		logger:  lgr,
+		keyring: keyring,
+		window:  window,
+		seen:    map[string]time.Time{},
+	}
+}
+
+// Close to fulfill the service.Processor interface.
+func (*processor) Close(context.Context) error {
+	return nil
+}
+
+// ProcessBatch to fulfill the service.BatchProcessor interface.
+func (p *processor) ProcessBatch(_ context.Context, msgs service.MessageBatch) ([]service.MessageBatch, error) {
+	for _, msg := range msgs {
+		if content, _ := msg.MetaGet(processors.MessageContentKey); content != httpinputserver.HMACConnectionContent {
+			continue
+		}
+		if err := p.verify(msg, time.Now()); err != nil {
+			processors.SetError(msg, processorName, "failed to verify request signature", err)
+			continue
+		}
+		msg.MetaSetMut(processors.MessageContentKey, httpinputserver.ConnectionContent)
+		msg.MetaDelete(httpinputserver.HMACTimestampKey)
+		msg.MetaDelete(httpinputserver.HMACSignatureKey)
+	}
+	return []service.MessageBatch{msgs}, nil
+}
+
+func (p *processor) verify(msg *service.Message, now time.Time) error {
+	keyID, okID := msg.MetaGet(httpinputserver.HMACKeyIDKey)
+	timestamp, okTS := msg.MetaGet(httpinputserver.HMACTimestampKey)
+	sigHex, okSig := msg.MetaGet(httpinputserver.HMACSignatureKey)
+	if !okID || !okTS || !okSig {
+		return errMissingMeta
+	}
+	key, ok := p.keyring[keyID]
+	if !ok {
+		return httpinputserver.ErrUnknownHMACKey
+	}
+	// The input checks the timestamp too, but the message may have sat in a
+	// buffer since then.
+	if err := httpinputserver.CheckHMACTimestamp(timestamp, now, p.window); err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return httpinputserver.ErrInvalidHMACSignature
+	}
+	body, err := msg.AsBytes()
+	if err != nil {
+		return err
+	}
+	expected := httpinputserver.HMACSignature([]byte(key.Secret), timestamp, body)
+	if !hmac.Equal(signature, expected) {
+		return httpinputserver.ErrInvalidHMACSignature
+	}
+	return p.markSeen(sigHex, now)
+}
+
+// markSeen records a signature so an identical request cannot be replayed
+// while its timestamp is still accepted. A timestamp is accepted for up to
+// twice the window, so entries are kept that long and pruned at most once per
+// window, which bounds the map by the request rate.
+func (p *processor) markSeen(signature string, now time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.lastPrune) > p.window {
+		for sig, at := range p.seen {
+			if now.Sub(at) > 2*p.window {
+				delete(p.seen, sig)
+			}
+		}
+		p.lastPrune = now
+	}
+	if _, ok := p.seen[signature]; ok {
+		return errReplayedRequest
+	}
+	p.seen[signature] = now
+	return nil
+}
@@ -0,0 +1,134 @@
+package hmacverify
+
+import (
+	"context"
+	"encoding/hex"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessBatch(t *testing.T) {
+	keyring := httpinputserver.HMACKeyring{
+		"integrator-a": {ID: "integrator-a", Secret: "super-secret", Source: "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b"},
+	}
+	body := []byte(`{"id":"1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	sign := func(secret, ts string, b []byte) string {
+		return hex.EncodeToString(httpinputserver.HMACSignature([]byte(secret), ts, b))
+	}
+	newMsg := func(keyID, ts, sig string, b []byte) *service.Message {
+		msg := service.NewMessage(b)
+		msg.MetaSetMut(processors.MessageContentKey, httpinputserver.HMACConnectionContent)
+		msg.MetaSetMut(httpinputserver.HMACKeyIDKey, keyID)
+		msg.MetaSetMut(httpinputserver.HMACTimestampKey, ts)
+		msg.MetaSetMut(httpinputserver.HMACSignatureKey, sig)
+		return msg
+	}
+
+	tests := []struct {
+		name          string
+		msg           func() *service.Message
+		expectedError error
+	}{
+		{
+			name: "valid signature",
+			msg:  func() *service.Message { return newMsg("integrator-a", now, sign("super-secret", now, body), body) },
+		},
+		{
+			name: "tampered body",
+			msg: func() *service.Message {
+				return newMsg("integrator-a", now, sign("super-secret", now, body), []byte(`{"id":"2"}`))
+			},
+			expectedError: httpinputserver.ErrInvalidHMACSignature,
+		},
+		{
+			name:          "wrong secret",
+			msg:           func() *service.Message { return newMsg("integrator-a", now, sign("other-secret", now, body), body) },
+			expectedError: httpinputserver.ErrInvalidHMACSignature,
+		},
+		{
+			name: "timestamp not covered by signature",
+			msg: func() *service.Message {
+				other := strconv.FormatInt(time.Now().Unix()-1, 10)
+				return newMsg("integrator-a", now, sign("super-secret", other, body), body)
+			},
+			expectedError: httpinputserver.ErrInvalidHMACSignature,
+		},
+		{
+			name:          "unknown key",
+			msg:           func() *service.Message { return newMsg("integrator-b", now, sign("super-secret", now, body), body) },
+			expectedError: httpinputserver.ErrUnknownHMACKey,
+		},
+		{
+			name: "missing metadata",
+			msg: func() *service.Message {
+				msg := service.NewMessage(body)
+				msg.MetaSetMut(processors.MessageContentKey, httpinputserver.HMACConnectionContent)
+				return msg
+			},
+			expectedError: errMissingMeta,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proc := newProcessor(keyring, time.Minute, nil)
+			result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{tt.msg()})
+			require.NoError(t, err)
+			require.Len(t, result, 1)
+			require.Len(t, result[0], 1)
+			out := result[0][0]
+			content, _ := out.MetaGet(processors.MessageContentKey)
+			if tt.expectedError != nil {
+				require.ErrorIs(t, out.GetError(), tt.expectedError)
+				assert.Equal(t, httpinputserver.HMACConnectionContent, content)
+				return
+			}
+			require.NoError(t, out.GetError())
+			assert.Equal(t, httpinputserver.ConnectionContent, content)
+		})
+	}
+}
+
+func TestProcessBatchRejectsReplay(t *testing.T) {
+	keyring := httpinputserver.HMACKeyring{
+		"integrator-a": {ID: "integrator-a", Secret: "super-secret", Source: "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b"},
+	}
+	proc := newProcessor(keyring, time.Minute, nil)
+	body := []byte(`{"id":"1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := hex.EncodeToString(httpinputserver.HMACSignature([]byte("super-secret"), now, body))
+
+	for i, expectErr := range []bool{false, true} {
+		msg := service.NewMessage(body)
+		msg.MetaSetMut(processors.MessageContentKey, httpinputserver.HMACConnectionContent)
+		msg.MetaSetMut(httpinputserver.HMACKeyIDKey, "integrator-a")
+		msg.MetaSetMut(httpinputserver.HMACTimestampKey, now)
+		msg.MetaSetMut(httpinputserver.HMACSignatureKey, sig)
+
+		result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+		require.NoError(t, err)
+		if expectErr {
+			require.ErrorIs(t, result[0][0].GetError(), errReplayedRequest, "attempt %d", i)
+		} else {
+			require.NoError(t, result[0][0].GetError(), "attempt %d", i)
+		}
+	}
+}
+
+func TestProcessBatchIgnoresOtherContent(t *testing.T) {
+	proc := newProcessor(httpinputserver.HMACKeyring{}, time.Minute, nil)
+	msg := service.NewMessage([]byte(`{}`))
+	msg.MetaSetMut(processors.MessageContentKey, httpinputserver.ConnectionContent)
+
+	result, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.NoError(t, result[0][0].GetError())
+}
@@ -0,0 +1,42 @@
+package hmacverify
+
+import (
+	"fmt"
+
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	processorName         = "dimo_hmac_verify"
+	keysFileFieldName     = "keys_file"
+	replayWindowFieldName = "replay_window"
+)
+
+var configSpec = service.NewConfigSpec().
+	Summary("Verifies the body signature of requests received by dimo_http_hmac_connection_server").
+	Field(service.NewStringField(keysFileFieldName).Default("").Description("Path to the HMAC keys file; must match the one used by the input")).
+	Field(service.NewDurationField(replayWindowFieldName).Default("5m").Description("How long a signature is remembered to reject replayed requests"))
+
+func init() {
+	err := service.RegisterBatchProcessor(processorName, configSpec, ctor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func ctor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchProcessor, error) {
+	keysFile, err := cfg.FieldString(keysFileFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", keysFileFieldName, err)
+	}
+	window, err := cfg.FieldDuration(replayWindowFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", replayWindowFieldName, err)
+	}
+	keyring, err := httpinputserver.LoadHMACKeyring(keysFile)
+	if err != nil {
+		return nil, err
+	}
+	return newProcessor(keyring, window, mgr.Logger()), nil
+}
@@ -0,0 +1,183 @@
+package httpinputserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/redpanda-data/benthos/v4/public/components/io"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// HMACConnectionContent marks a connection message whose headers passed the
+	// HMAC middleware but whose body signature has not been verified yet. The
+	// dimo_hmac_verify processor promotes it to ConnectionContent; anything else
+	// in the pipeline rejects it as an unknown content type.
+	HMACConnectionContent = "dimo_content_connection_hmac"
+
+	// HMACKeyIDKey is the metadata key holding the key ID used to sign the request.
+	HMACKeyIDKey = "dimo_hmac_key_id"
+	// HMACTimestampKey is the metadata key holding the signed request timestamp.
+	HMACTimestampKey = "dimo_hmac_timestamp"
+	// HMACSignatureKey is the metadata key holding the hex encoded request signature.
+	HMACSignatureKey = "dimo_hmac_signature"
+
+	// HeaderHMACKeyID identifies which shared secret signed the request.
+	HeaderHMACKeyID = "X-DIMO-Key-ID"
+	// HeaderHMACTimestamp is the request time in unix seconds. It is covered by the signature.
+	HeaderHMACTimestamp = "X-DIMO-Timestamp"
+	// HeaderHMACSignature is the hex encoded HMAC-SHA256 of "<timestamp>.<body>".
+	HeaderHMACSignature = "X-DIMO-Signature"
+
+	hmacKeysFile     = "keys_file"
+	hmacReplayWindow = "replay_window"
+
+	defaultReplayWindow = 5 * time.Minute
+)
+
+var (
+	ErrUnknownHMACKey       = errors.New("unknown hmac key id")
+	ErrHMACTimestampExpired = errors.New("hmac timestamp outside of replay window")
+	ErrInvalidHMACSignature = errors.New("invalid hmac signature")
+)
+
+var hmacField = service.NewObjectField("hmac",
+	service.NewStringField(hmacKeysFile).Default("").Description("Path to a YAML file mapping HMAC key IDs to shared secrets and connection license addresses. When empty every request is rejected."),
+	service.NewDurationField(hmacReplayWindow).Default(defaultReplayWindow.String()).Description("Maximum allowed difference between the signed request timestamp and the server time."),
+)
+
+func init() {
+	io.RegisterCustomHTTPServerInput("dimo_http_hmac_connection_server", HMACMiddlewareConstructor, hmacField)
+}
+
+// HMACKey is a shared secret issued to a connection integrator that cannot use
+// client certificates.
+type HMACKey struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+	// Source is the connection license address requests signed with this key are attributed to.
+	Source string `yaml:"source"`
+}
+
+// HMACKeyring holds HMAC keys indexed by key ID.
+type HMACKeyring map[string]HMACKey
+
+type hmacKeysFileContent struct {
+	Keys []HMACKey `yaml:"keys"`
+}
+
+// LoadHMACKeyring reads and validates a keys file. An empty path yields an
+// empty keyring. Sources are normalized to their EIP-55 checksum form.
+func LoadHMACKeyring(path string) (HMACKeyring, error) {
+	keyring := HMACKeyring{}
+	if path == "" {
+		return keyring, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hmac keys file: %w", err)
+	}
+	var content hmacKeysFileContent
+	if err := yaml.Unmarshal(raw, &content); err != nil {
+		return nil, fmt.Errorf("failed to parse hmac keys file: %w", err)
+	}
+	for _, key := range content.Keys {
+		if key.ID == "" {
+			return nil, errors.New("hmac key id must not be empty")
+		}
+		if key.Secret == "" {
+			return nil, fmt.Errorf("hmac key %s has an empty secret", key.ID)
+		}
+		if !common.IsHexAddress(key.Source) {
+			return nil, fmt.Errorf("hmac key %s has invalid source address: %s", key.ID, key.Source)
+		}
+		if _, ok := keyring[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate hmac key id: %s", key.ID)
+		}
+		key.Source = common.HexToAddress(key.Source).Hex()
+		keyring[key.ID] = key
+	}
+	return keyring, nil
+}
+
+// HMACSignature returns the HMAC-SHA256 of "<timestamp>.<body>" using secret.
+func HMACSignature(secret []byte, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// CheckHMACTimestamp parses a unix seconds timestamp and verifies it is within
+// window of now.
+func CheckHMACTimestamp(timestamp string, now time.Time, window time.Duration) error {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q is not a unix timestamp", ErrHMACTimestampExpired, timestamp)
+	}
+	diff := now.Sub(time.Unix(secs, 0))
+	if diff > window || diff < -window {
+		return fmt.Errorf("%w: %s", ErrHMACTimestampExpired, diff)
+	}
+	return nil
+}
+
+func HMACMiddlewareConstructor(conf *service.ParsedConfig) (io.HTTPInputMiddlewareMeta, error) {
+	return hmacMiddleware(conf)
+}
+
+// hmacMiddleware authenticates the request headers. The body is not available
+// to input middleware, so the signature itself is checked by dimo_hmac_verify.
+func hmacMiddleware(conf *service.ParsedConfig) (func(*http.Request) (map[string]any, error), error) {
+	subConf := conf.Namespace("hmac")
+	keysFile, err := subConf.FieldString(hmacKeysFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch hmac keys file from config: %w", err)
+	}
+	window, err := subConf.FieldDuration(hmacReplayWindow)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch hmac replay window from config: %w", err)
+	}
+	keyring, err := LoadHMACKeyring(keysFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(r *http.Request) (map[string]any, error) {
+		retMeta := map[string]any{}
+		keyID := r.Header.Get(HeaderHMACKeyID)
+		key, ok := keyring[keyID]
+		if !ok {
+			return retMeta, fmt.Errorf("%w: %q", ErrUnknownHMACKey, keyID)
+		}
+
+		timestamp := r.Header.Get(HeaderHMACTimestamp)
+		if err := CheckHMACTimestamp(timestamp, time.Now(), window); err != nil {
+			return retMeta, err
+		}
+
+		signature, err := hex.DecodeString(r.Header.Get(HeaderHMACSignature))
+		if err != nil || len(signature) != sha256.Size {
+			return retMeta, ErrInvalidHMACSignature
+		}
+
+		retMeta[DIMOCloudEventSource] = key.Source
+		retMeta[processors.MessageContentKey] = HMACConnectionContent
+		retMeta[HMACKeyIDKey] = keyID
+		retMeta[HMACTimestampKey] = timestamp
+		retMeta[HMACSignatureKey] = hex.EncodeToString(signature)
+		retMeta[ReceivedAtKey] = ReceivedAtValue(time.Now())
+		return retMeta, nil
+	}, nil
+}
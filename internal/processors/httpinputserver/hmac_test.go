@@ -0,0 +1,143 @@
+package httpinputserver
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testHMACKeys = `
+keys:
+  - id: integrator-a
+    secret: super-secret
+    source: "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b"
+`
+
+func writeHMACKeys(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadHMACKeyring(t *testing.T) {
+	keyring, err := LoadHMACKeyring(writeHMACKeys(t, testHMACKeys))
+	require.NoError(t, err)
+	require.Contains(t, keyring, "integrator-a")
+	assert.Equal(t, "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b", keyring["integrator-a"].Source)
+
+	keyring, err = LoadHMACKeyring("")
+	require.NoError(t, err)
+	assert.Empty(t, keyring)
+
+	invalid := []string{
+		"keys:\n  - id: a\n    secret: s\n    source: not-an-address\n",
+		"keys:\n  - id: a\n    source: \"0x07b584f6a7125491c991ca2a45ab9e641b1cee1b\"\n",
+		"keys:\n  - secret: s\n    source: \"0x07b584f6a7125491c991ca2a45ab9e641b1cee1b\"\n",
+		"keys:\n  - id: a\n    secret: s\n    source: \"0x07b584f6a7125491c991ca2a45ab9e641b1cee1b\"\n  - id: a\n    secret: t\n    source: \"0x07b584f6a7125491c991ca2a45ab9e641b1cee1b\"\n",
+	}
+	for _, content := range invalid {
+		_, err := LoadHMACKeyring(writeHMACKeys(t, content))
+		require.Error(t, err, content)
+	}
+}
+
+func TestHMACMiddleware(t *testing.T) {
+	config := service.NewConfigSpec().Field(hmacField)
+	parsedConfig, err := config.ParseYAML("hmac:\n  keys_file: "+writeHMACKeys(t, testHMACKeys)+"\n  replay_window: 1m\n", nil)
+	require.NoError(t, err)
+
+	middleware, err := hmacMiddleware(parsedConfig)
+	require.NoError(t, err)
+
+	body := []byte(`{"id":"1"}`)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	validSig := hex.EncodeToString(HMACSignature([]byte("super-secret"), now, body))
+
+	tests := []struct {
+		name      string
+		keyID     string
+		timestamp string
+		signature string
+		errorIs   error
+	}{
+		{
+			name:      "valid headers",
+			keyID:     "integrator-a",
+			timestamp: now,
+			signature: validSig,
+		},
+		{
+			name:      "unknown key id",
+			keyID:     "integrator-b",
+			timestamp: now,
+			signature: validSig,
+			errorIs:   ErrUnknownHMACKey,
+		},
+		{
+			name:      "missing key id",
+			timestamp: now,
+			signature: validSig,
+			errorIs:   ErrUnknownHMACKey,
+		},
+		{
+			name:      "stale timestamp",
+			keyID:     "integrator-a",
+			timestamp: strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10),
+			signature: validSig,
+			errorIs:   ErrHMACTimestampExpired,
+		},
+		{
+			name:      "future timestamp",
+			keyID:     "integrator-a",
+			timestamp: strconv.FormatInt(time.Now().Add(2*time.Minute).Unix(), 10),
+			signature: validSig,
+			errorIs:   ErrHMACTimestampExpired,
+		},
+		{
+			name:      "malformed timestamp",
+			keyID:     "integrator-a",
+			timestamp: "yesterday",
+			signature: validSig,
+			errorIs:   ErrHMACTimestampExpired,
+		},
+		{
+			name:      "malformed signature",
+			keyID:     "integrator-a",
+			timestamp: now,
+			signature: "zz",
+			errorIs:   ErrInvalidHMACSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			req.Header.Set(HeaderHMACKeyID, tt.keyID)
+			req.Header.Set(HeaderHMACTimestamp, tt.timestamp)
+			req.Header.Set(HeaderHMACSignature, tt.signature)
+
+			meta, err := middleware(req)
+			if tt.errorIs != nil {
+				require.ErrorIs(t, err, tt.errorIs)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b", meta[DIMOCloudEventSource])
+			assert.Equal(t, HMACConnectionContent, meta[processors.MessageContentKey])
+			assert.Equal(t, "integrator-a", meta[HMACKeyIDKey])
+			assert.Equal(t, tt.timestamp, meta[HMACTimestampKey])
+			assert.Equal(t, validSig, meta[HMACSignatureKey])
+		})
+	}
+}
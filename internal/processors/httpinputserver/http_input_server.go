@@ -1,11 +1,15 @@
 package httpinputserver
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/DIMO-Network/dis/internal/processors"
 	"github.com/MicahParks/keyfunc/v3"
@@ -21,74 +25,224 @@ const (
 	AttestationContent     = "dimo_content_attestation"
 	tokenExchangeIssuer    = "token_exchange_issuer"
 	tokenExchangeKeySetURL = "token_exchange_key_set_url"
+	jwtAlgorithms          = "algorithms"
+	jwtAudience            = "audience"
+	jwtLeeway              = "leeway"
+
+	// JWTIssuerKey is the metadata key holding the issuer of the token that authenticated an attestation.
+	JWTIssuerKey = "dimo_jwt_issuer"
+	// JWTClaimsKey is the metadata key holding the JSON encoded claims of the verified token.
+	JWTClaimsKey = "dimo_jwt_claims"
+	// ReceivedAtKey is the metadata key holding the RFC 3339 time an input received a message.
+	ReceivedAtKey = "dimo_received_at"
 )
 
 var ErrInvalidEthAddr = errors.New("ethereum address not set in claim")
 
-var field = service.NewObjectField("jwt",
+var field = service.NewObjectListField("jwt",
 	service.NewStringField(tokenExchangeIssuer).Description("Specifies issuer url for token exchange service."),
 	service.NewStringField(tokenExchangeKeySetURL).Description("Specified the url that provides public keys for JWT signature validation."),
-)
+	service.NewStringListField(jwtAlgorithms).Default([]string{"RS256"}).Description("Signing algorithms accepted from this issuer."),
+	service.NewStringListField(jwtAudience).Default([]string{}).Description("When set, the token aud claim must contain at least one of these values."),
+	service.NewDurationField(jwtLeeway).Default("0s").Description("Allowed clock skew when validating exp, nbf and iat."),
+).Description("Trusted token issuers. The issuer entry is selected by the token iss claim.")
+
+// ClientCertField configures validation of mTLS client certificates for connection inputs.
+var ClientCertField = service.NewObjectField("client_cert",
+	revocationField,
+	identityField,
+).Description("Validation of mTLS client certificates.")
 
 var zeroAddress common.Address
 
 func init() {
-	io.RegisterCustomHTTPServerInput("dimo_http_connection_server", CertRoutingMiddlewareConstructor, nil)
+	io.RegisterCustomHTTPServerInput("dimo_http_connection_server", CertRoutingMiddlewareConstructor, ClientCertField)
 	io.RegisterCustomHTTPServerInput("dimo_http_attestation_server", AttestationMiddlewareConstructor, field)
 }
 
-func CertRoutingMiddlewareConstructor(*service.ParsedConfig) (io.HTTPInputMiddlewareMeta, error) {
-	return CertRoutingMiddlewarefunc, nil
+// CertRoutingMiddlewareConstructor builds the middleware of the connection
+// input. The HTTP server input has no close hook, so its resolver keeps
+// reloading the CRL for the lifetime of the process.
+func CertRoutingMiddlewareConstructor(conf *service.ParsedConfig) (io.HTTPInputMiddlewareMeta, error) {
+	resolver, err := NewCertSourceResolver(conf)
+	if err != nil {
+		return nil, err
+	}
+	return certRoutingMiddleware(resolver), nil
 }
 
-func CertRoutingMiddlewarefunc(r *http.Request) (map[string]any, error) {
-	retMeta := map[string]any{}
-	verifiedChains := r.TLS.VerifiedChains
-	for _, certChain := range verifiedChains {
-		firstCert := certChain[0]
-		retMeta[DIMOCloudEventSource] = firstCert.Subject.CommonName
+// certRoutingMiddleware rejects revoked client certificates and routes by the
+// connection license address the certificate identifies.
+func certRoutingMiddleware(resolver *CertSourceResolver) func(*http.Request) (map[string]any, error) {
+	return func(r *http.Request) (map[string]any, error) {
+		retMeta := map[string]any{}
+		if r.TLS == nil {
+			return retMeta, ErrMissingCertIdentity
+		}
+		source, err := resolver.Resolve(r.Context(), r.TLS.VerifiedChains)
+		if err != nil {
+			return retMeta, err
+		}
+		retMeta[DIMOCloudEventSource] = source.Hex()
 		retMeta[processors.MessageContentKey] = ConnectionContent
+		retMeta[ReceivedAtKey] = ReceivedAtValue(time.Now())
+		return retMeta, nil
 	}
-	return retMeta, nil
 }
 
-func AttestationMiddlewareConstructor(conf *service.ParsedConfig) (io.HTTPInputMiddlewareMeta, error) {
-	return attestationMiddleware(conf)
+// ReceivedAtValue formats the time a message was received for ReceivedAtKey.
+func ReceivedAtValue(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
 }
 
-func attestationMiddleware(conf *service.ParsedConfig) (func(*http.Request) (map[string]any, error), error) {
-	subConf := conf.Namespace("jwt")
-	issuer, err := subConf.FieldString(tokenExchangeIssuer)
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch token exchange issuer from config: %w", err)
+// ReceivedAt returns the time an input received msg, and false if the input
+// did not record it.
+func ReceivedAt(msg *service.Message) (time.Time, bool) {
+	value, ok := msg.MetaGet(ReceivedAtKey)
+	if !ok {
+		return time.Time{}, false
 	}
+	receivedAt, err := time.Parse(time.RFC3339Nano, value)
+	return receivedAt, err == nil
+}
+
+// CertSourceResolver maps verified client certificate chains to the
+// connection license address they identify, rejecting revoked certificates.
+type CertSourceResolver struct {
+	checker *revocationChecker
+	mapper  *CertIdentityMapper
+}
 
-	jwksURI, err := subConf.FieldString(tokenExchangeKeySetURL)
+// NewCertSourceResolver builds a resolver from the ClientCertField of conf.
+func NewCertSourceResolver(conf *service.ParsedConfig) (*CertSourceResolver, error) {
+	checker, err := newRevocationChecker(conf.Namespace("client_cert", "revocation"), conf.Resources())
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch token exchange key set url from config: %w", err)
+		return nil, fmt.Errorf("failed to create revocation checker: %w", err)
 	}
-
-	issuerURL, err := url.Parse(issuer)
+	fingerprintsFile, err := conf.Namespace("client_cert", "identity").FieldString(identityFingerprintsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch fingerprints file from config: %w", err)
+	}
+	mapper, err := LoadCertIdentityMapper(fingerprintsFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse issuer URL: %w", err)
+		return nil, err
 	}
+	return &CertSourceResolver{checker: checker, mapper: mapper}, nil
+}
+
+// Resolve returns the connection source of a client that presented chains.
+func (c *CertSourceResolver) Resolve(ctx context.Context, chains [][]*x509.Certificate) (common.Address, error) {
+	if err := c.checker.Check(ctx, chains); err != nil {
+		return common.Address{}, err
+	}
+	return c.mapper.Resolve(chains)
+}
+
+// Close stops the background work of the resolver.
+func (c *CertSourceResolver) Close() {
+	c.checker.Close()
+}
+
+func AttestationMiddlewareConstructor(conf *service.ParsedConfig) (io.HTTPInputMiddlewareMeta, error) {
+	return attestationMiddleware(conf)
+}
+
+// jwtIssuer validates tokens signed by a single trusted issuer.
+type jwtIssuer struct {
+	keyfunc jwt.Keyfunc
+	parser  *jwt.Parser
+}
 
-	jwksResource, err := keyfunc.NewDefault([]string{jwksURI})
+func newJWTIssuers(confs []*service.ParsedConfig) (map[string]jwtIssuer, error) {
+	issuers := make(map[string]jwtIssuer, len(confs))
+	for _, subConf := range confs {
+		issuer, err := subConf.FieldString(tokenExchangeIssuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch token exchange issuer from config: %w", err)
+		}
+
+		jwksURI, err := subConf.FieldString(tokenExchangeKeySetURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch token exchange key set url from config: %w", err)
+		}
+
+		algorithms, err := subConf.FieldStringList(jwtAlgorithms)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch jwt algorithms from config: %w", err)
+		}
+
+		audience, err := subConf.FieldStringList(jwtAudience)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch jwt audience from config: %w", err)
+		}
+
+		leeway, err := subConf.FieldDuration(jwtLeeway)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch jwt leeway from config: %w", err)
+		}
+
+		issuerURL, err := url.Parse(issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse issuer URL: %w", err)
+		}
+		if _, ok := issuers[issuerURL.String()]; ok {
+			return nil, fmt.Errorf("duplicate jwt issuer: %s", issuerURL)
+		}
+
+		jwksResource, err := keyfunc.NewDefault([]string{jwksURI})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a keyfunc.Keyfunc from the server's URL: %w", err)
+		}
+
+		opts := []jwt.ParserOption{
+			jwt.WithIssuer(issuerURL.String()),
+			jwt.WithValidMethods(algorithms),
+			jwt.WithLeeway(leeway),
+		}
+		if len(audience) > 0 {
+			opts = append(opts, jwt.WithAudience(audience...))
+		}
+		issuers[issuerURL.String()] = jwtIssuer{
+			keyfunc: jwksResource.Keyfunc,
+			parser:  jwt.NewParser(opts...),
+		}
+	}
+	if len(issuers) == 0 {
+		return nil, errors.New("at least one jwt issuer must be configured")
+	}
+	return issuers, nil
+}
+
+func attestationMiddleware(conf *service.ParsedConfig) (func(*http.Request) (map[string]any, error), error) {
+	issuerConfs, err := conf.FieldObjectList("jwt")
 	if err != nil {
-		return nil, fmt.Errorf("failed to create a keyfunc.Keyfunc from the server's URL: %w", err)
+		return nil, fmt.Errorf("failed to fetch jwt issuers from config: %w", err)
 	}
-	parser := jwt.NewParser(
-		jwt.WithIssuer(issuerURL.String()),
-		jwt.WithValidMethods([]string{"RS256"}),
-	)
+	issuers, err := newJWTIssuers(issuerConfs)
+	if err != nil {
+		return nil, err
+	}
+	unverified := jwt.NewParser()
 
 	return func(r *http.Request) (map[string]any, error) {
 		retMeta := map[string]any{}
 		authStr := r.Header.Get("Authorization")
 		tokenStr := strings.TrimSpace(strings.Replace(authStr, "Bearer ", "", 1))
 
+		// The issuer claim only selects which keys and rules to verify with; it
+		// is checked again by the issuer's parser once the signature is verified.
+		rawClaims := jwt.MapClaims{}
+		if _, _, err := unverified.ParseUnverified(tokenStr, rawClaims); err != nil {
+			return retMeta, fmt.Errorf("invalid token string: %w", err)
+		}
+		iss, _ := rawClaims.GetIssuer()
+		issuer, ok := issuers[iss]
+		if !ok {
+			return retMeta, fmt.Errorf("invalid token string: %w: %q", jwt.ErrTokenInvalidIssuer, iss)
+		}
+
 		var claims Claims
-		if _, err := parser.ParseWithClaims(tokenStr, &claims, jwksResource.Keyfunc); err != nil {
+		if _, err := issuer.parser.ParseWithClaims(tokenStr, &claims, issuer.keyfunc); err != nil {
 			return retMeta, fmt.Errorf("invalid token string: %w", err)
 		}
 
@@ -96,8 +250,15 @@ func attestationMiddleware(conf *service.ParsedConfig) (func(*http.Request) (map
 			return retMeta, ErrInvalidEthAddr
 		}
 
+		claimsJSON, err := json.Marshal(rawClaims)
+		if err != nil {
+			return retMeta, fmt.Errorf("failed to encode token claims: %w", err)
+		}
+
 		retMeta[DIMOCloudEventSource] = claims.EthereumAddress.Hex()
 		retMeta[processors.MessageContentKey] = AttestationContent
+		retMeta[JWTIssuerKey] = claims.Issuer
+		retMeta[JWTClaimsKey] = string(claimsJSON)
 
 		return retMeta, nil
 	}, nil
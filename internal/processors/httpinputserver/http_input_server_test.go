@@ -20,16 +20,12 @@ func TestAttestationMiddleware(t *testing.T) {
 	defer authServer.Close()
 
 	// Create test configuration
-	config := service.NewConfigSpec()
-	config.Field(service.NewObjectField("jwt",
-		service.NewStringField("token_exchange_issuer").Description("Specifies issuer url for token exchange service."),
-		service.NewStringField("token_exchange_key_set_url").Description("Specified the url that provides public keys for JWT signature validation."),
-	))
+	config := service.NewConfigSpec().Field(field)
 
 	parsedConfig, err := config.ParseYAML(fmt.Sprintf(`
 jwt:
-  token_exchange_issuer: "%s"
-  token_exchange_key_set_url: "%s/keys"
+  - token_exchange_issuer: "%s"
+    token_exchange_key_set_url: "%s/keys"
 `, authServer.issuerURL, authServer.issuerURL), nil)
 	require.NoError(t, err)
 
@@ -61,6 +57,7 @@ jwt:
 			expectedMeta: map[string]any{
 				DIMOCloudEventSource:         common.HexToAddress("0xabcdefabcdefabcdefabcdefabcdefabcdefabcd").Hex(),
 				processors.MessageContentKey: AttestationContent,
+				JWTIssuerKey:                 authServer.issuerURL,
 			},
 			expectedError: false,
 		},
@@ -220,3 +217,95 @@ jwt:
 		})
 	}
 }
+
+func TestAttestationMiddlewareMultipleIssuers(t *testing.T) {
+	rsaServer := setupMockAuthServer(t)
+	defer rsaServer.Close()
+	ecServer := setupMockES256AuthServer(t)
+	defer ecServer.Close()
+
+	config := service.NewConfigSpec().Field(field)
+	parsedConfig, err := config.ParseYAML(fmt.Sprintf(`
+jwt:
+  - token_exchange_issuer: "%[1]s"
+    token_exchange_key_set_url: "%[1]s/keys"
+    audience: ["dimo.zone"]
+    leeway: 1m
+  - token_exchange_issuer: "%[2]s"
+    token_exchange_key_set_url: "%[2]s/keys"
+    algorithms: ["ES256"]
+    audience: ["attest.dimo.zone"]
+`, rsaServer.issuerURL, ecServer.issuerURL), nil)
+	require.NoError(t, err)
+
+	middleware, err := attestationMiddleware(parsedConfig)
+	require.NoError(t, err)
+
+	ethereumAddr := common.HexToAddress("0xabcdefabcdefabcdefabcdefabcdefabcdefabcd")
+	tests := []struct {
+		name           string
+		token          string
+		expectedIssuer string
+		errorIs        error
+	}{
+		{
+			name:           "rs256 issuer",
+			token:          rsaServer.createToken(t, ethereumAddr, nil),
+			expectedIssuer: rsaServer.issuerURL,
+		},
+		{
+			name:           "es256 issuer",
+			token:          ecServer.createToken(t, ethereumAddr, map[string]any{"aud": []string{"attest.dimo.zone"}}),
+			expectedIssuer: ecServer.issuerURL,
+		},
+		{
+			name:           "expired within leeway",
+			token:          rsaServer.createToken(t, ethereumAddr, map[string]any{"exp": time.Now().Add(-30 * time.Second).Unix()}),
+			expectedIssuer: rsaServer.issuerURL,
+		},
+		{
+			name:    "expired past leeway",
+			token:   rsaServer.createToken(t, ethereumAddr, map[string]any{"exp": time.Now().Add(-2 * time.Minute).Unix()}),
+			errorIs: jwt.ErrTokenExpired,
+		},
+		{
+			name:    "wrong audience",
+			token:   ecServer.createToken(t, ethereumAddr, nil),
+			errorIs: jwt.ErrTokenInvalidAudience,
+		},
+		{
+			name:    "missing audience",
+			token:   rsaServer.createToken(t, ethereumAddr, map[string]any{"aud": nil}),
+			errorIs: jwt.ErrTokenRequiredClaimMissing,
+		},
+		{
+			name: "algorithm not allowed for issuer",
+			token: rsaServer.createToken(t, ethereumAddr, map[string]any{
+				"iss": ecServer.issuerURL,
+				"aud": []string{"attest.dimo.zone"},
+			}),
+			errorIs: jwt.ErrTokenSignatureInvalid,
+		},
+		{
+			name:    "unknown issuer",
+			token:   rsaServer.createToken(t, ethereumAddr, map[string]any{"iss": "https://wrong-issuer.com"}),
+			errorIs: jwt.ErrTokenInvalidIssuer,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+
+			meta, err := middleware(req)
+			if tt.errorIs != nil {
+				require.ErrorIs(t, err, tt.errorIs)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedIssuer, meta[JWTIssuerKey])
+			assert.Contains(t, meta[JWTClaimsKey], `"iss":"`+tt.expectedIssuer+`"`)
+			assert.Equal(t, ethereumAddr.Hex(), meta[DIMOCloudEventSource])
+		})
+	}
+}
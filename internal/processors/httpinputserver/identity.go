@@ -0,0 +1,170 @@
+package httpinputserver
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	identityFingerprintsFile = "fingerprints_file"
+
+	didEthrPrefix = "did:ethr:"
+)
+
+var (
+	ErrMissingCertIdentity   = errors.New("client certificate has no connection identity")
+	ErrMalformedCertIdentity = errors.New("client certificate identity is not a valid address")
+	ErrAmbiguousCertIdentity = errors.New("client certificate identity is ambiguous")
+)
+
+var identityField = service.NewObjectField("identity",
+	service.NewStringField(identityFingerprintsFile).Default("").Description("Path to a YAML file mapping SHA-256 client certificate fingerprints to connection license addresses. A mapped certificate ignores its CN and SANs."),
+).Description("Mapping of client certificates to connection license addresses.")
+
+// CertFingerprint is a client certificate fingerprint bound to a connection license address.
+type CertFingerprint struct {
+	// Fingerprint is the hex encoded SHA-256 of the DER certificate.
+	Fingerprint string `yaml:"fingerprint"`
+	Source      string `yaml:"source"`
+}
+
+type fingerprintsFileContent struct {
+	Fingerprints []CertFingerprint `yaml:"fingerprints"`
+}
+
+// CertIdentityMapper resolves the connection license address a client certificate speaks for.
+type CertIdentityMapper struct {
+	// fingerprints maps lower case hex SHA-256 fingerprints to checksummed addresses.
+	fingerprints map[string]common.Address
+}
+
+// LoadCertIdentityMapper reads and validates a fingerprints file. An empty path
+// yields a mapper that only uses the certificate CN and SANs.
+func LoadCertIdentityMapper(path string) (*CertIdentityMapper, error) {
+	mapper := &CertIdentityMapper{fingerprints: map[string]common.Address{}}
+	if path == "" {
+		return mapper, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fingerprints file: %w", err)
+	}
+	var content fingerprintsFileContent
+	if err := yaml.Unmarshal(raw, &content); err != nil {
+		return nil, fmt.Errorf("failed to parse fingerprints file: %w", err)
+	}
+	for _, entry := range content.Fingerprints {
+		fingerprint := strings.ToLower(strings.ReplaceAll(entry.Fingerprint, ":", ""))
+		if decoded, err := hex.DecodeString(fingerprint); err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate fingerprint: %s", entry.Fingerprint)
+		}
+		addr, err := parseIdentityAddress(entry.Source)
+		if err != nil {
+			return nil, fmt.Errorf("fingerprint %s: %w", entry.Fingerprint, err)
+		}
+		if _, ok := mapper.fingerprints[fingerprint]; ok {
+			return nil, fmt.Errorf("duplicate certificate fingerprint: %s", entry.Fingerprint)
+		}
+		mapper.fingerprints[fingerprint] = addr
+	}
+	return mapper, nil
+}
+
+// CertFingerprintHex returns the lower case hex SHA-256 fingerprint of cert.
+func CertFingerprintHex(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Resolve returns the connection license address for the leaf certificate of
+// the verified chains. Every chain must resolve to the same address.
+func (m *CertIdentityMapper) Resolve(chains [][]*x509.Certificate) (common.Address, error) {
+	var resolved common.Address
+	for i, chain := range chains {
+		if len(chain) == 0 {
+			continue
+		}
+		addr, err := m.resolveCert(chain[0])
+		if err != nil {
+			return zeroAddress, err
+		}
+		if i > 0 && addr != resolved {
+			return zeroAddress, fmt.Errorf("%w: verified chains resolve to %s and %s", ErrAmbiguousCertIdentity, resolved.Hex(), addr.Hex())
+		}
+		resolved = addr
+	}
+	if resolved == zeroAddress {
+		return zeroAddress, ErrMissingCertIdentity
+	}
+	return resolved, nil
+}
+
+func (m *CertIdentityMapper) resolveCert(cert *x509.Certificate) (common.Address, error) {
+	if addr, ok := m.fingerprints[CertFingerprintHex(cert)]; ok {
+		return addr, nil
+	}
+
+	var candidates []common.Address
+	if cert.Subject.CommonName != "" {
+		addr, err := parseIdentityAddress(cert.Subject.CommonName)
+		if err != nil {
+			return zeroAddress, fmt.Errorf("common name %q: %w", cert.Subject.CommonName, err)
+		}
+		candidates = append(candidates, addr)
+	}
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "did" || !strings.HasPrefix(uri.String(), didEthrPrefix) {
+			continue
+		}
+		addr, err := parseDIDEthr(uri.String())
+		if err != nil {
+			return zeroAddress, fmt.Errorf("uri SAN %q: %w", uri, err)
+		}
+		candidates = append(candidates, addr)
+	}
+
+	if len(candidates) == 0 {
+		return zeroAddress, ErrMissingCertIdentity
+	}
+	for _, addr := range candidates[1:] {
+		if addr != candidates[0] {
+			return zeroAddress, fmt.Errorf("%w: certificate names %s and %s", ErrAmbiguousCertIdentity, candidates[0].Hex(), addr.Hex())
+		}
+	}
+	return candidates[0], nil
+}
+
+// parseDIDEthr parses did:ethr:<address> and did:ethr:<network>:<address>.
+func parseDIDEthr(did string) (common.Address, error) {
+	parts := strings.Split(strings.TrimPrefix(did, didEthrPrefix), ":")
+	if len(parts) > 2 {
+		return zeroAddress, ErrMalformedCertIdentity
+	}
+	return parseIdentityAddress(parts[len(parts)-1])
+}
+
+// parseIdentityAddress requires a 0x prefixed, non-zero hex address. Mixed case
+// input must carry a valid EIP-55 checksum.
+func parseIdentityAddress(s string) (common.Address, error) {
+	if !strings.HasPrefix(s, "0x") || !common.IsHexAddress(s) {
+		return zeroAddress, ErrMalformedCertIdentity
+	}
+	addr := common.HexToAddress(s)
+	if addr == zeroAddress {
+		return zeroAddress, ErrMalformedCertIdentity
+	}
+	hexPart := s[2:]
+	if hexPart != strings.ToLower(hexPart) && hexPart != strings.ToUpper(hexPart) && s != addr.Hex() {
+		return zeroAddress, fmt.Errorf("%w: bad checksum", ErrMalformedCertIdentity)
+	}
+	return addr, nil
+}
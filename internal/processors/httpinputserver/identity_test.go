@@ -0,0 +1,145 @@
+package httpinputserver
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCertIdentityMapperResolve(t *testing.T) {
+	ca := newTestCA(t, "root")
+	otherSource := "0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8"
+	newCert := func(cn string, uris ...string) *x509.Certificate {
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: pkix.Name{CommonName: cn}}
+		for _, raw := range uris {
+			u, err := url.Parse(raw)
+			require.NoError(t, err)
+			tmpl.URIs = append(tmpl.URIs, u)
+		}
+		return ca.issueTemplate(t, tmpl)
+	}
+	rotated := newCert("integrator-a")
+
+	path := filepath.Join(t.TempDir(), "fingerprints.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("fingerprints:\n  - fingerprint: "+CertFingerprintHex(rotated)+"\n    source: \""+otherSource+"\"\n"), 0o600))
+	mapper, err := LoadCertIdentityMapper(path)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		chains   [][]*x509.Certificate
+		expected string
+		errorIs  error
+	}{
+		{
+			name:     "checksummed common name",
+			chains:   [][]*x509.Certificate{{newCert(testCertSource), ca.cert}},
+			expected: testCertSource,
+		},
+		{
+			name:     "lower case common name is normalized",
+			chains:   [][]*x509.Certificate{{newCert("0x07b584f6a7125491c991ca2a45ab9e641b1cee1b"), ca.cert}},
+			expected: testCertSource,
+		},
+		{
+			name:     "did:ethr uri san",
+			chains:   [][]*x509.Certificate{{newCert("", "did:ethr:137:"+testCertSource), ca.cert}},
+			expected: testCertSource,
+		},
+		{
+			name:     "matching common name and uri san",
+			chains:   [][]*x509.Certificate{{newCert(testCertSource, "did:ethr:"+testCertSource, "https://example.com"), ca.cert}},
+			expected: testCertSource,
+		},
+		{
+			name:     "fingerprint mapping overrides common name",
+			chains:   [][]*x509.Certificate{{rotated, ca.cert}},
+			expected: otherSource,
+		},
+		{
+			name:    "common name is not an address",
+			chains:  [][]*x509.Certificate{{newCert("0xTestSourceAddr1234567890abcdef1234"), ca.cert}},
+			errorIs: ErrMalformedCertIdentity,
+		},
+		{
+			name:    "bad checksum",
+			chains:  [][]*x509.Certificate{{newCert("0x07B584f6a7125491C991ca2a45ab9e641B1CeE1B"), ca.cert}},
+			errorIs: ErrMalformedCertIdentity,
+		},
+		{
+			name:    "zero address",
+			chains:  [][]*x509.Certificate{{newCert("0x0000000000000000000000000000000000000000"), ca.cert}},
+			errorIs: ErrMalformedCertIdentity,
+		},
+		{
+			name:    "malformed uri san",
+			chains:  [][]*x509.Certificate{{newCert("", "did:ethr:1:2:"+testCertSource), ca.cert}},
+			errorIs: ErrMalformedCertIdentity,
+		},
+		{
+			name:    "common name and uri san disagree",
+			chains:  [][]*x509.Certificate{{newCert(testCertSource, "did:ethr:"+otherSource), ca.cert}},
+			errorIs: ErrAmbiguousCertIdentity,
+		},
+		{
+			name:    "verified chains disagree",
+			chains:  [][]*x509.Certificate{{newCert(testCertSource), ca.cert}, {newCert(otherSource), ca.cert}},
+			errorIs: ErrAmbiguousCertIdentity,
+		},
+		{
+			name:    "no identity",
+			chains:  [][]*x509.Certificate{{newCert(""), ca.cert}},
+			errorIs: ErrMissingCertIdentity,
+		},
+		{
+			name:    "no verified chains",
+			errorIs: ErrMissingCertIdentity,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := mapper.Resolve(tt.chains)
+			if tt.errorIs != nil {
+				require.ErrorIs(t, err, tt.errorIs)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, addr.Hex())
+		})
+	}
+}
+
+func TestLoadCertIdentityMapperInvalid(t *testing.T) {
+	invalid := []string{
+		"fingerprints:\n  - fingerprint: abcd\n    source: \"" + testCertSource + "\"\n",
+		"fingerprints:\n  - fingerprint: " + CertFingerprintHex(&x509.Certificate{}) + "\n    source: not-an-address\n",
+	}
+	for _, content := range invalid {
+		path := filepath.Join(t.TempDir(), "fingerprints.yaml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := LoadCertIdentityMapper(path)
+		require.Error(t, err, content)
+	}
+}
+
+func TestCertRoutingMiddlewareIdentity(t *testing.T) {
+	ca := newTestCA(t, "root")
+	middleware := newCertRoutingMiddleware(t, "client_cert: {}\n")
+
+	meta, err := middleware(tlsRequest(ca.issue(t, 2, "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b", ""), ca.cert))
+	require.NoError(t, err)
+	assert.Equal(t, testCertSource, meta[DIMOCloudEventSource])
+	assert.Equal(t, ConnectionContent, meta[processors.MessageContentKey])
+
+	meta, err = middleware(tlsRequest(ca.issue(t, 3, "integrator-a", ""), ca.cert))
+	require.ErrorIs(t, err, ErrMalformedCertIdentity)
+	assert.Empty(t, meta)
+}
@@ -1,6 +1,8 @@
 package httpinputserver
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
@@ -21,6 +23,10 @@ type mockAuthServer struct {
 	publicKey  *rsa.PublicKey
 	keyID      string
 	issuerURL  string
+
+	// signingMethod and signingKey are used by createToken.
+	signingMethod jwt.SigningMethod
+	signingKey    any
 }
 
 func setupMockAuthServer(t *testing.T) *mockAuthServer {
@@ -36,28 +42,63 @@ func setupMockAuthServer(t *testing.T) *mockAuthServer {
 	keyID := fmt.Sprintf("key-%d", time.Now().Unix())
 
 	auth := &mockAuthServer{
-		privateKey: sk,
-		publicKey:  &sk.PublicKey,
-		keyID:      keyID,
+		privateKey:    sk,
+		publicKey:     &sk.PublicKey,
+		keyID:         keyID,
+		signingMethod: jwt.SigningMethodRS256,
+		signingKey:    sk,
+	}
+
+	// Create JWK
+	jwk := map[string]any{
+		"kty": "RSA",
+		"kid": keyID,
+		"alg": "RS256",
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(sk.N.Bytes()),
+		"e":   "AQAB",
+	}
+	auth.serveJWK(jwk)
+	return auth
+}
+
+// setupMockES256AuthServer starts an issuer that signs tokens with a P-256 key.
+func setupMockES256AuthServer(t *testing.T) *mockAuthServer {
+	t.Helper()
+
+	sk, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate EC key: %v", err)
+	}
+
+	keyID := fmt.Sprintf("ec-key-%d", time.Now().Unix())
+	auth := &mockAuthServer{
+		keyID:         keyID,
+		signingMethod: jwt.SigningMethodES256,
+		signingKey:    sk,
+	}
+
+	jwk := map[string]any{
+		"kty": "EC",
+		"kid": keyID,
+		"alg": "ES256",
+		"use": "sig",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(sk.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(sk.Y.FillBytes(make([]byte, 32))),
 	}
+	auth.serveJWK(jwk)
+	return auth
+}
 
-	// Create test server with JWKS endpoint
+// serveJWK starts the test server with a JWKS endpoint serving jwk.
+func (m *mockAuthServer) serveJWK(jwk map[string]any) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path != "/keys" {
 			http.NotFound(w, r)
 			return
 		}
 
-		// Create JWK
-		jwk := map[string]any{
-			"kty": "RSA",
-			"kid": keyID,
-			"alg": "RS256",
-			"use": "sig",
-			"n":   base64.RawURLEncoding.EncodeToString(sk.N.Bytes()),
-			"e":   "AQAB",
-		}
-
 		jwks := map[string]any{
 			"keys": []map[string]any{jwk},
 		}
@@ -69,9 +110,8 @@ func setupMockAuthServer(t *testing.T) *mockAuthServer {
 		}
 	}))
 
-	auth.server = server
-	auth.issuerURL = server.URL
-	return auth
+	m.server = server
+	m.issuerURL = server.URL
 }
 
 func (m *mockAuthServer) createToken(t *testing.T, ethereumAddress common.Address, customClaims map[string]any) string {
@@ -95,11 +135,11 @@ func (m *mockAuthServer) createToken(t *testing.T, ethereumAddress common.Addres
 	}
 
 	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token := jwt.NewWithClaims(m.signingMethod, claims)
 	token.Header["kid"] = m.keyID
 
 	// Sign the token
-	tokenString, err := token.SignedString(m.privateKey)
+	tokenString, err := token.SignedString(m.signingKey)
 	if err != nil {
 		t.Fatalf("Failed to sign token: %v", err)
 	}
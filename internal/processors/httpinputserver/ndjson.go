@@ -0,0 +1,211 @@
+package httpinputserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	ndjsonInputName    = "dimo_http_ndjson_connection_server"
+	ndjsonAddress      = "address"
+	ndjsonPath         = "path"
+	ndjsonRateLimit    = "rate_limit"
+	ndjsonMaxLineBytes = "max_line_bytes"
+	ndjsonMaxInFlight  = "max_in_flight"
+	ndjsonLineTimeout  = "line_timeout"
+
+	// NDJSONContentType is the media type of NDJSON request and response bodies.
+	NDJSONContentType = "application/x-ndjson"
+)
+
+var errLineTooLong = errors.New("line exceeds max_line_bytes")
+
+var ndjsonConfigSpec = service.NewConfigSpec().
+	Summary("Streams newline delimited JSON connection payloads over a single mTLS request. Each line is processed as its own message and one outcome per line is streamed back.").
+	Field(service.NewStringField(ndjsonAddress).Default("0.0.0.0:9445").Description("Address to listen on.")).
+	Field(service.NewStringField(ndjsonPath).Default("/").Description("Path to accept bulk requests on.")).
+	Field(ServerTLSField).
+	Field(ClientCertField).
+	Field(service.NewStringField(ndjsonRateLimit).Default("").Description("Optional rate limit resource that every request and every line must pass. Limited requests are answered with 429 and limited lines are reported with status 429 without being processed.")).
+	Field(service.NewIntField(ndjsonMaxLineBytes).Default(1 << 20).Description("Maximum size of a single line. The stream is aborted at the first longer line.")).
+	Field(service.NewIntField(ndjsonMaxInFlight).Default(64).Description("Maximum number of lines of one request that are processed concurrently. Reading from the request stops while the limit is reached.")).
+	Field(service.NewDurationField(ndjsonLineTimeout).Default("30s").Description("Maximum time to wait for a line to be processed before reporting it as failed."))
+
+func init() {
+	err := service.RegisterBatchInput(ndjsonInputName, ndjsonConfigSpec, ndjsonCtor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// lineOutcome is written to the response for every non-empty line.
+type lineOutcome struct {
+	Line   int    `json:"line"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// streamSummary is the last line of every response.
+type streamSummary struct {
+	Lines    int    `json:"lines"`
+	Accepted int    `json:"accepted"`
+	Rejected int    `json:"rejected"`
+	Error    string `json:"error,omitempty"`
+}
+
+type ndjsonInput struct {
+	*streamInput
+	path string
+}
+
+func ndjsonCtor(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+	address, err := conf.FieldString(ndjsonAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ndjsonAddress, err)
+	}
+	path, err := conf.FieldString(ndjsonPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ndjsonPath, err)
+	}
+	rateLimit, err := conf.FieldString(ndjsonRateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ndjsonRateLimit, err)
+	}
+	if rateLimit != "" && !mgr.HasRateLimit(rateLimit) {
+		return nil, fmt.Errorf("rate limit resource %q was not found", rateLimit)
+	}
+	tlsConfig, err := ServerTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := NewCertSourceResolver(conf)
+	if err != nil {
+		return nil, err
+	}
+	maxLineBytes, err := conf.FieldInt(ndjsonMaxLineBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ndjsonMaxLineBytes, err)
+	}
+	maxInFlight, err := conf.FieldInt(ndjsonMaxInFlight)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ndjsonMaxInFlight, err)
+	}
+	lineTimeout, err := conf.FieldDuration(ndjsonLineTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ndjsonLineTimeout, err)
+	}
+	if maxLineBytes <= 0 || maxInFlight <= 0 {
+		return nil, fmt.Errorf("%s and %s must be positive", ndjsonMaxLineBytes, ndjsonMaxInFlight)
+	}
+	input := newNDJSONInput(certRoutingMiddleware(resolver), maxLineBytes, maxInFlight, lineTimeout, mgr)
+	input.address = address
+	input.path = path
+	input.rateLimit = rateLimit
+	input.tlsConfig = tlsConfig
+	input.resolver = resolver
+	return input, nil
+}
+
+func newNDJSONInput(authenticate func(*http.Request) (map[string]any, error), maxLineBytes, maxInFlight int, lineTimeout time.Duration, res *service.Resources) *ndjsonInput {
+	input := &ndjsonInput{streamInput: newStreamInput(authenticate, maxLineBytes, maxInFlight, lineTimeout, res.Logger())}
+	input.res = res
+	return input
+}
+
+// Connect starts the HTTP server.
+func (n *ndjsonInput) Connect(context.Context) error {
+	mux := http.NewServeMux()
+	mux.Handle(n.path, n)
+	return n.serve(mux)
+}
+
+// ServeHTTP streams the request body line by line into the pipeline and the
+// outcome of every line back to the client, in request order.
+func (n *ndjsonInput) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if enc := r.Header.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		http.Error(w, "content encoding is not supported for bulk requests", http.StatusUnsupportedMediaType)
+		return
+	}
+	if n.rateLimited(w, r) {
+		return
+	}
+	meta, err := n.authenticate(r)
+	if err != nil {
+		n.rejectUnauthenticated(w, err)
+		return
+	}
+
+	ctx := r.Context()
+	pending := make(chan *ndjsonLine, n.maxInFlight)
+	var readErr error
+	go func() {
+		defer close(pending)
+		readErr = n.readLines(ctx, r, meta, pending)
+	}()
+
+	w.Header().Set("Content-Type", NDJSONContentType)
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	var summary streamSummary
+	for line := range pending {
+		outcome := lineOutcome{Line: line.number}
+		outcome.Status, outcome.Error = n.waitForLine(ctx, line)
+		summary.Lines++
+		if outcome.Status < http.StatusMultipleChoices {
+			summary.Accepted++
+		} else {
+			summary.Rejected++
+		}
+		if err := enc.Encode(outcome); err != nil {
+			// The client is gone; keep draining so every line is acknowledged.
+			continue
+		}
+		_ = rc.Flush()
+	}
+	if readErr != nil {
+		summary.Error = readErr.Error()
+	}
+	_ = enc.Encode(summary)
+}
+
+// readLines sends every non-empty line to the pipeline and queues it for a
+// response. It blocks once maxInFlight lines are waiting, which stops reading
+// from the client.
+func (n *ndjsonInput) readLines(ctx context.Context, r *http.Request, meta map[string]any, pending chan<- *ndjsonLine) error {
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(make([]byte, 0, min(n.maxLineBytes, 64<<10)), n.maxLineBytes)
+	number := 0
+	for scanner.Scan() {
+		number++
+		raw := bytes.TrimSpace(scanner.Bytes())
+		if len(raw) == 0 {
+			continue
+		}
+		line := n.newLine(number, bytes.Clone(raw), meta, "application/json")
+		if err := n.submit(ctx, line, pending); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return fmt.Errorf("line %d: %w", number+1, errLineTooLong)
+		}
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+	return nil
+}
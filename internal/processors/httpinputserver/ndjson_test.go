@@ -0,0 +1,189 @@
+package httpinputserver
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runTestPipeline consumes lines like the stream would: lines containing
+// "bad" get a 400 sync response and lines containing "nack" fail delivery.
+func runTestPipeline(t *testing.T, input service.BatchInput) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		for {
+			batch, ack, err := input.ReadBatch(ctx)
+			if err != nil {
+				return
+			}
+			msg := batch[0]
+			body, _ := msg.AsBytes()
+			source, _ := msg.MetaGet(DIMOCloudEventSource)
+			content, _ := msg.MetaGet(processors.MessageContentKey)
+			_, received := ReceivedAt(msg)
+			switch {
+			case source != testCertSource || content != ConnectionContent || !received:
+				msg.MetaSetMut(responseStatusKey, 500)
+				msg.SetBytes([]byte("missing metadata"))
+				_ = msg.AddSyncResponse()
+				_ = ack(ctx, nil)
+			case strings.Contains(string(body), "bad"):
+				msg.MetaSetMut(responseStatusKey, 400)
+				msg.SetBytes([]byte("failed to convert to cloudevent"))
+				_ = msg.AddSyncResponse()
+				_ = ack(ctx, nil)
+			case strings.Contains(string(body), "nack"):
+				_ = ack(ctx, errors.New("output unavailable"))
+			default:
+				_ = ack(ctx, nil)
+			}
+		}
+	}()
+}
+
+func readNDJSONResponse(t *testing.T, body io.Reader) ([]lineOutcome, streamSummary) {
+	t.Helper()
+	var outcomes []lineOutcome
+	var summary streamSummary
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		var outcome lineOutcome
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &outcome))
+		if outcome.Line == 0 {
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &summary))
+			continue
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes, summary
+}
+
+func TestNDJSONServeHTTP(t *testing.T) {
+	ca := newTestCA(t, "root")
+	middleware := newCertRoutingMiddleware(t, "client_cert: {}")
+	input := newNDJSONInput(middleware, 64, 2, time.Second, service.MockResources())
+	runTestPipeline(t, input)
+
+	t.Run("per line outcomes", func(t *testing.T) {
+		req := tlsRequest(ca.issue(t, 2, testCertSource, ""), ca.cert)
+		req.Body = io.NopCloser(strings.NewReader("{\"id\":\"1\"}\n\n{\"id\":\"bad\"}\r\n{\"id\":\"nack\"}\n{\"id\":\"4\"}"))
+		rec := httptest.NewRecorder()
+		input.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, NDJSONContentType, rec.Header().Get("Content-Type"))
+		outcomes, summary := readNDJSONResponse(t, rec.Body)
+		assert.Equal(t, []lineOutcome{
+			{Line: 1, Status: http.StatusOK},
+			{Line: 3, Status: http.StatusBadRequest, Error: "failed to convert to cloudevent"},
+			{Line: 4, Status: http.StatusInternalServerError, Error: "output unavailable"},
+			{Line: 5, Status: http.StatusOK},
+		}, outcomes)
+		assert.Equal(t, streamSummary{Lines: 4, Accepted: 2, Rejected: 2}, summary)
+	})
+
+	t.Run("line too long", func(t *testing.T) {
+		req := tlsRequest(ca.issue(t, 3, testCertSource, ""), ca.cert)
+		req.Body = io.NopCloser(strings.NewReader("{\"id\":\"1\"}\n{\"id\":\"" + strings.Repeat("a", 100) + "\"}\n{\"id\":\"3\"}\n"))
+		rec := httptest.NewRecorder()
+		input.ServeHTTP(rec, req)
+
+		outcomes, summary := readNDJSONResponse(t, rec.Body)
+		assert.Equal(t, []lineOutcome{{Line: 1, Status: http.StatusOK}}, outcomes)
+		assert.Equal(t, 1, summary.Accepted)
+		assert.Contains(t, summary.Error, "line 2")
+		assert.Contains(t, summary.Error, errLineTooLong.Error())
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("{}\n"))
+		rec := httptest.NewRecorder()
+		input.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, "unauthorized\n", rec.Body.String())
+	})
+
+	t.Run("compressed body", func(t *testing.T) {
+		req := tlsRequest(ca.issue(t, 4, testCertSource, ""), ca.cert)
+		req.Header.Set("Content-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		input.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	})
+}
+
+func TestNDJSONLineTimeout(t *testing.T) {
+	ca := newTestCA(t, "root")
+	// Nothing reads from the input, so the first line is never acknowledged.
+	input := newNDJSONInput(newCertRoutingMiddleware(t, "client_cert: {}"), 64, 1, 10*time.Millisecond, service.MockResources())
+
+	req := tlsRequest(ca.issue(t, 2, testCertSource, ""), ca.cert)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req = req.WithContext(ctx)
+	req.Body = io.NopCloser(strings.NewReader("{}\n"))
+	rec := httptest.NewRecorder()
+	go func() {
+		// Unblock the reader once the line has timed out.
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	input.ServeHTTP(rec, req)
+
+	outcomes, summary := readNDJSONResponse(t, rec.Body)
+	require.Len(t, outcomes, 1)
+	assert.Equal(t, http.StatusInternalServerError, outcomes[0].Status)
+	assert.Equal(t, errLineTimeout.Error(), outcomes[0].Error)
+	assert.Equal(t, 1, summary.Rejected)
+}
+
+func TestNDJSONRateLimit(t *testing.T) {
+	ca := newTestCA(t, "root")
+	var calls atomic.Int32
+	var limitFrom atomic.Int32
+	res := service.MockResources(service.MockResourcesOptAddRateLimit("limit", func(context.Context) (time.Duration, error) {
+		if from := limitFrom.Load(); from > 0 && calls.Add(1) >= from {
+			return 2 * time.Second, nil
+		}
+		return 0, nil
+	}))
+	input := newNDJSONInput(newCertRoutingMiddleware(t, "client_cert: {}"), 64, 2, time.Second, res)
+	input.rateLimit = "limit"
+	runTestPipeline(t, input)
+
+	// The request and its first line pass, the second line is limited.
+	limitFrom.Store(3)
+	req := tlsRequest(ca.issue(t, 2, testCertSource, ""), ca.cert)
+	req.Body = io.NopCloser(strings.NewReader("{\"id\":\"1\"}\n{\"id\":\"2\"}\n"))
+	rec := httptest.NewRecorder()
+	input.ServeHTTP(rec, req)
+	outcomes, summary := readNDJSONResponse(t, rec.Body)
+	assert.Equal(t, []lineOutcome{
+		{Line: 1, Status: http.StatusOK},
+		{Line: 2, Status: http.StatusTooManyRequests, Error: "Too Many Requests"},
+	}, outcomes)
+	assert.Equal(t, streamSummary{Lines: 2, Accepted: 1, Rejected: 1}, summary)
+
+	// New requests are refused while limited.
+	req = tlsRequest(ca.issue(t, 3, testCertSource, ""), ca.cert)
+	req.Body = io.NopCloser(strings.NewReader("{}\n"))
+	rec = httptest.NewRecorder()
+	input.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get(retryAfterKey))
+}
@@ -0,0 +1,385 @@
+package httpinputserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	// MetricRevokedCertificates counts requests rejected because the client
+	// certificate was revoked, labelled by certificate CN.
+	MetricRevokedCertificates = "dis_revoked_certificates_total"
+
+	revocationCRL            = "crl"
+	revocationReloadInterval = "crl_reload_interval"
+	revocationRejectStale    = "crl_reject_stale"
+	revocationOCSP           = "ocsp"
+	revocationOCSPFailOpen   = "ocsp_fail_open"
+	revocationOCSPCacheTTL   = "ocsp_cache_ttl"
+	revocationOCSPTimeout    = "ocsp_timeout"
+
+	// ocspCacheSize is the maximum number of cached OCSP responses.
+	ocspCacheSize = 100000
+
+	maxRevocationResponseBytes = 10 << 20
+)
+
+var (
+	// ErrCertificateRevoked is returned when a certificate in the verified chain has been revoked.
+	ErrCertificateRevoked = errors.New("client certificate has been revoked")
+	// ErrRevocationUnknown is returned when the revocation status of a certificate
+	// cannot be established and the checker is configured to fail closed.
+	ErrRevocationUnknown = errors.New("client certificate revocation status is unknown")
+)
+
+var revocationField = service.NewObjectField("revocation",
+	service.NewStringField(revocationCRL).Default("").Description("File path or http(s) URL of a PEM or DER encoded CRL issued by the client root CA. Revocation lists are not checked when empty."),
+	service.NewDurationField(revocationReloadInterval).Default("5m").Description("How often the CRL is reloaded. The previous list is kept if a reload fails."),
+	service.NewBoolField(revocationRejectStale).Default(false).Description("Reject certificates whose issuer's CRL is past its next update time. When false, a stale CRL is still used and a warning is logged on every reload."),
+	service.NewBoolField(revocationOCSP).Default(false).Description("Query the OCSP responder listed in the client certificate."),
+	service.NewBoolField(revocationOCSPFailOpen).Default(true).Description("Accept the request when the OCSP responder cannot be reached, returns an invalid response, or reports an unknown status. When false, such requests are rejected."),
+	service.NewDurationField(revocationOCSPCacheTTL).Default("1h").Description("Maximum time an OCSP response is cached. Responses are never cached past their next update time."),
+	service.NewDurationField(revocationOCSPTimeout).Default("5s").Description("Timeout for a single OCSP request."),
+).Description("Certificate revocation checking for client certificates.")
+
+// revocationChecker rejects verified chains that contain a revoked certificate
+// according to a periodically reloaded CRL and, optionally, OCSP.
+type revocationChecker struct {
+	logger  *service.Logger
+	revoked *service.MetricCounter
+
+	crlSource   string
+	rejectStale bool
+	crlMu       sync.RWMutex
+	// crls holds the loaded revocation lists indexed by raw issuer name.
+	crls map[string]*loadedCRL
+
+	ocspEnabled  bool
+	ocspFailOpen bool
+	ocspTTL      time.Duration
+	httpClient   *http.Client
+	// ocspCache evicts responses after ocspTTL, and the least recently used
+	// first once it is full.
+	ocspCache *expirable.LRU[string, ocspCacheEntry]
+
+	// stopReload stops the CRL reload loop.
+	stopReload context.CancelFunc
+}
+
+// loadedCRL is a parsed revocation list with its serials indexed for lookup.
+type loadedCRL struct {
+	list    *x509.RevocationList
+	serials map[string]struct{}
+
+	mu sync.Mutex
+	// verifiedIssuer is the raw issuer certificate the CRL signature was last
+	// verified against, so the signature is not re-checked on every request.
+	verifiedIssuer []byte
+}
+
+func newLoadedCRL(list *x509.RevocationList) *loadedCRL {
+	serials := make(map[string]struct{}, len(list.RevokedCertificateEntries))
+	for _, entry := range list.RevokedCertificateEntries {
+		serials[entry.SerialNumber.String()] = struct{}{}
+	}
+	return &loadedCRL{list: list, serials: serials}
+}
+
+// staleAt reports whether the CRL is past its next update time at now.
+func (l *loadedCRL) staleAt(now time.Time) bool {
+	return !l.list.NextUpdate.IsZero() && now.After(l.list.NextUpdate)
+}
+
+// signedBy reports whether the CRL was signed by issuer.
+func (l *loadedCRL) signedBy(issuer *x509.Certificate) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if bytes.Equal(l.verifiedIssuer, issuer.Raw) {
+		return true
+	}
+	if err := l.list.CheckSignatureFrom(issuer); err != nil {
+		return false
+	}
+	l.verifiedIssuer = issuer.Raw
+	return true
+}
+
+// ocspCacheEntry is a cached OCSP response. expires is at most ocspTTL after
+// the response, and earlier when the response has an earlier next update.
+type ocspCacheEntry struct {
+	revoked bool
+	expires time.Time
+}
+
+func newRevocationChecker(conf *service.ParsedConfig, res *service.Resources) (*revocationChecker, error) {
+	crlSource, err := conf.FieldString(revocationCRL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch crl from config: %w", err)
+	}
+	interval, err := conf.FieldDuration(revocationReloadInterval)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch crl reload interval from config: %w", err)
+	}
+	rejectStale, err := conf.FieldBool(revocationRejectStale)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch crl reject stale from config: %w", err)
+	}
+	ocspEnabled, err := conf.FieldBool(revocationOCSP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ocsp from config: %w", err)
+	}
+	ocspFailOpen, err := conf.FieldBool(revocationOCSPFailOpen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ocsp fail open from config: %w", err)
+	}
+	ocspTTL, err := conf.FieldDuration(revocationOCSPCacheTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ocsp cache ttl from config: %w", err)
+	}
+	ocspTimeout, err := conf.FieldDuration(revocationOCSPTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ocsp timeout from config: %w", err)
+	}
+
+	c := &revocationChecker{
+		logger:       res.Logger(),
+		revoked:      res.Metrics().NewCounter(MetricRevokedCertificates, "cn"),
+		crlSource:    crlSource,
+		rejectStale:  rejectStale,
+		ocspEnabled:  ocspEnabled,
+		ocspFailOpen: ocspFailOpen,
+		ocspTTL:      ocspTTL,
+		httpClient:   &http.Client{Timeout: ocspTimeout},
+		ocspCache:    expirable.NewLRU[string, ocspCacheEntry](ocspCacheSize, nil, ocspTTL),
+		stopReload:   func() {},
+	}
+	if crlSource == "" {
+		return c, nil
+	}
+	// Fail startup on a bad CRL rather than silently accepting revoked certificates.
+	if err := c.reloadCRL(context.Background()); err != nil {
+		return nil, err
+	}
+	c.warnIfStale(time.Now())
+	if interval > 0 {
+		var ctx context.Context
+		ctx, c.stopReload = context.WithCancel(context.Background())
+		go c.reloadLoop(ctx, interval)
+	}
+	return c, nil
+}
+
+// Close stops reloading the CRL.
+func (c *revocationChecker) Close() {
+	c.stopReload()
+}
+
+func (c *revocationChecker) reloadLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if err := c.reloadCRL(ctx); err != nil {
+			c.logger.Errorf("Failed to reload CRL, keeping previous list: %v", err)
+		}
+		c.warnIfStale(time.Now())
+	}
+}
+
+// warnIfStale logs every loaded CRL that is past its next update time.
+func (c *revocationChecker) warnIfStale(now time.Time) {
+	c.crlMu.RLock()
+	defer c.crlMu.RUnlock()
+	for _, crl := range c.crls {
+		if crl.staleAt(now) {
+			c.logger.Warnf("CRL of %s is past its next update time %s", crl.list.Issuer, crl.list.NextUpdate.Format(time.RFC3339))
+		}
+	}
+}
+
+func (c *revocationChecker) reloadCRL(ctx context.Context) error {
+	raw, err := c.fetch(ctx, c.crlSource)
+	if err != nil {
+		return fmt.Errorf("failed to load crl %s: %w", c.crlSource, err)
+	}
+	crls, err := parseCRLs(raw)
+	if err != nil {
+		return fmt.Errorf("failed to parse crl %s: %w", c.crlSource, err)
+	}
+	c.crlMu.Lock()
+	c.crls = crls
+	c.crlMu.Unlock()
+	return nil
+}
+
+func (c *revocationChecker) fetch(ctx context.Context, source string) ([]byte, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		return os.ReadFile(source)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxRevocationResponseBytes))
+}
+
+// parseCRLs accepts a single DER encoded CRL or one or more PEM "X509 CRL" blocks.
+func parseCRLs(raw []byte) (map[string]*loadedCRL, error) {
+	crls := map[string]*loadedCRL{}
+	if !bytes.Contains(raw, []byte("-----BEGIN")) {
+		crl, err := x509.ParseRevocationList(raw)
+		if err != nil {
+			return nil, err
+		}
+		crls[string(crl.RawIssuer)] = newLoadedCRL(crl)
+		return crls, nil
+	}
+	for {
+		var block *pem.Block
+		block, raw = pem.Decode(raw)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		crls[string(crl.RawIssuer)] = newLoadedCRL(crl)
+	}
+	if len(crls) == 0 {
+		return nil, errors.New("no X509 CRL blocks found")
+	}
+	return crls, nil
+}
+
+// Check returns ErrCertificateRevoked if any certificate in any verified chain
+// is revoked, and ErrRevocationUnknown if the status of one cannot be
+// established while failing closed. The root of each chain is trusted through
+// configuration and is not checked.
+func (c *revocationChecker) Check(ctx context.Context, chains [][]*x509.Certificate) error {
+	for _, chain := range chains {
+		for i := 0; i < len(chain)-1; i++ {
+			cert, issuer := chain[i], chain[i+1]
+			revoked, err := c.inCRL(cert, issuer)
+			if err == nil && !revoked {
+				revoked, err = c.ocspRevoked(ctx, cert, issuer)
+			}
+			if err != nil {
+				return fmt.Errorf("%w: serial %s issued by %s: %w", ErrRevocationUnknown, cert.SerialNumber, cert.Issuer.CommonName, err)
+			}
+			if revoked {
+				c.revoked.Incr(1, chain[0].Subject.CommonName)
+				return fmt.Errorf("%w: serial %s issued by %s", ErrCertificateRevoked, cert.SerialNumber, cert.Issuer.CommonName)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *revocationChecker) inCRL(cert, issuer *x509.Certificate) (bool, error) {
+	c.crlMu.RLock()
+	crl, ok := c.crls[string(cert.RawIssuer)]
+	c.crlMu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	// A CRL that was not signed by the issuer in this chain says nothing about it.
+	if !crl.signedBy(issuer) {
+		return false, nil
+	}
+	if c.rejectStale && crl.staleAt(time.Now()) {
+		return false, fmt.Errorf("crl is past its next update time %s", crl.list.NextUpdate.Format(time.RFC3339))
+	}
+	_, revoked := crl.serials[cert.SerialNumber.String()]
+	return revoked, nil
+}
+
+// ocspRevoked queries the certificate's OCSP responder. When failing open, an
+// unreachable responder, an invalid response or an unknown status does not
+// reject the request; otherwise they are returned as an error.
+func (c *revocationChecker) ocspRevoked(ctx context.Context, cert, issuer *x509.Certificate) (bool, error) {
+	if !c.ocspEnabled || len(cert.OCSPServer) == 0 {
+		return false, nil
+	}
+	key := string(cert.RawIssuer) + cert.SerialNumber.String()
+	now := time.Now()
+	entry, ok := c.ocspCache.Get(key)
+	if ok && now.Before(entry.expires) {
+		return entry.revoked, nil
+	}
+
+	resp, err := c.queryOCSP(ctx, cert, issuer)
+	if err == nil && resp.Status == ocsp.Unknown {
+		err = errors.New("responder reported an unknown status")
+	}
+	if err != nil {
+		c.logger.Warnf("OCSP check failed for %s: %v", cert.Subject.CommonName, err)
+		if c.ocspFailOpen {
+			return false, nil
+		}
+		return false, fmt.Errorf("ocsp check failed: %w", err)
+	}
+	entry = ocspCacheEntry{
+		revoked: resp.Status == ocsp.Revoked,
+		expires: now.Add(c.ocspTTL),
+	}
+	if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(entry.expires) {
+		entry.expires = resp.NextUpdate
+	}
+	c.ocspCache.Add(key, entry)
+	return entry.revoked, nil
+}
+
+func (c *revocationChecker) queryOCSP(ctx context.Context, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	reqBytes, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ocsp request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cert.OCSPServer[0], bytes.NewReader(reqBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	httpResp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", httpResp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxRevocationResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	return ocsp.ParseResponseForCert(body, cert, issuer)
+}
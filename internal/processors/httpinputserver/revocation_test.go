@@ -0,0 +1,295 @@
+package httpinputserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+const testCertSource = "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b"
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, cn string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64, cn string, ocspServer string) *x509.Certificate {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+	}
+	if ocspServer != "" {
+		tmpl.OCSPServer = []string{ocspServer}
+	}
+	return ca.issueTemplate(t, tmpl)
+}
+
+func (ca *testCA) issueTemplate(t *testing.T, tmpl *x509.Certificate) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl.NotBefore = time.Now().Add(-time.Hour)
+	tmpl.NotAfter = time.Now().Add(time.Hour)
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func (ca *testCA) writeCRL(t *testing.T, revoked ...int64) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRLTo(t, path, time.Now().Add(time.Hour), revoked...)
+	return path
+}
+
+func (ca *testCA) writeCRLTo(t *testing.T, path string, nextUpdate time.Time, revoked ...int64) {
+	t.Helper()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: nextUpdate.Add(-2 * time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, serial := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries, x509.RevocationListEntry{
+			SerialNumber:   big.NewInt(serial),
+			RevocationTime: time.Now().Add(-time.Minute),
+		})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o600))
+}
+
+func tlsRequest(chain ...*x509.Certificate) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{chain}}
+	return req
+}
+
+func newCertRoutingMiddleware(t *testing.T, yaml string) func(*http.Request) (map[string]any, error) {
+	t.Helper()
+	parsedConfig, err := service.NewConfigSpec().Field(ClientCertField).ParseYAML(yaml, nil)
+	require.NoError(t, err)
+	resolver, err := NewCertSourceResolver(parsedConfig)
+	require.NoError(t, err)
+	t.Cleanup(resolver.Close)
+	return certRoutingMiddleware(resolver)
+}
+
+func TestCertRoutingMiddlewareCRL(t *testing.T) {
+	ca := newTestCA(t, "root")
+	otherCA := newTestCA(t, "other")
+	crlPath := ca.writeCRL(t, 3)
+	middleware := newCertRoutingMiddleware(t, "client_cert:\n  revocation:\n    crl: "+crlPath+"\n")
+
+	source := testCertSource
+	tests := []struct {
+		name    string
+		req     *http.Request
+		wantErr bool
+	}{
+		{
+			name: "valid certificate",
+			req:  tlsRequest(ca.issue(t, 2, source, ""), ca.cert),
+		},
+		{
+			name:    "revoked certificate",
+			req:     tlsRequest(ca.issue(t, 3, source, ""), ca.cert),
+			wantErr: true,
+		},
+		{
+			name: "same serial from a different issuer",
+			req:  tlsRequest(otherCA.issue(t, 3, source, ""), otherCA.cert),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			meta, err := middleware(tt.req)
+			if tt.wantErr {
+				require.ErrorIs(t, err, ErrCertificateRevoked)
+				assert.Empty(t, meta)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, source, meta[DIMOCloudEventSource])
+			assert.Equal(t, ConnectionContent, meta[processors.MessageContentKey])
+		})
+	}
+}
+
+func TestCRLReloadStopsOnClose(t *testing.T) {
+	ca := newTestCA(t, "root")
+	crlPath := ca.writeCRL(t)
+	parsedConfig, err := service.NewConfigSpec().Field(ClientCertField).ParseYAML("client_cert:\n  revocation:\n    crl: "+crlPath+"\n    crl_reload_interval: 5ms\n", nil)
+	require.NoError(t, err)
+	resolver, err := NewCertSourceResolver(parsedConfig)
+	require.NoError(t, err)
+	middleware := certRoutingMiddleware(resolver)
+
+	ca.writeCRLTo(t, crlPath, time.Now().Add(time.Hour), 2)
+	require.Eventually(t, func() bool {
+		_, err := middleware(tlsRequest(ca.issue(t, 2, testCertSource, ""), ca.cert))
+		return errors.Is(err, ErrCertificateRevoked)
+	}, time.Second, 5*time.Millisecond)
+
+	resolver.Close()
+	ca.writeCRLTo(t, crlPath, time.Now().Add(time.Hour), 2, 3)
+	time.Sleep(50 * time.Millisecond)
+	_, err = middleware(tlsRequest(ca.issue(t, 3, testCertSource, ""), ca.cert))
+	require.NoError(t, err)
+}
+
+func TestCertRoutingMiddlewareStaleCRL(t *testing.T) {
+	ca := newTestCA(t, "root")
+	crlPath := filepath.Join(t.TempDir(), "ca.crl")
+	ca.writeCRLTo(t, crlPath, time.Now().Add(-time.Minute), 3)
+
+	// A stale list is still used unless stale lists are rejected.
+	middleware := newCertRoutingMiddleware(t, "client_cert:\n  revocation:\n    crl: "+crlPath+"\n")
+	_, err := middleware(tlsRequest(ca.issue(t, 2, testCertSource, ""), ca.cert))
+	require.NoError(t, err)
+	_, err = middleware(tlsRequest(ca.issue(t, 3, testCertSource, ""), ca.cert))
+	require.ErrorIs(t, err, ErrCertificateRevoked)
+
+	middleware = newCertRoutingMiddleware(t, "client_cert:\n  revocation:\n    crl: "+crlPath+"\n    crl_reject_stale: true\n")
+	_, err = middleware(tlsRequest(ca.issue(t, 2, testCertSource, ""), ca.cert))
+	require.ErrorIs(t, err, ErrRevocationUnknown)
+}
+
+func TestNewRevocationCheckerInvalidCRL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.crl")
+	require.NoError(t, os.WriteFile(path, []byte("not a crl"), 0o600))
+	parsedConfig, err := service.NewConfigSpec().Field(ClientCertField).ParseYAML("client_cert:\n  revocation:\n    crl: "+path+"\n", nil)
+	require.NoError(t, err)
+	_, err = NewCertSourceResolver(parsedConfig)
+	require.Error(t, err)
+}
+
+func TestCertRoutingMiddlewareOCSP(t *testing.T) {
+	ca := newTestCA(t, "root")
+	var requests atomic.Int32
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		ocspReq, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+		status := ocsp.Good
+		if ocspReq.SerialNumber.Int64() == 3 {
+			status = ocsp.Revoked
+		}
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       status,
+			SerialNumber: ocspReq.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+			RevokedAt:    time.Now().Add(-time.Minute),
+		}, ca.key)
+		require.NoError(t, err)
+		_, _ = w.Write(resp)
+	}))
+	defer responder.Close()
+
+	middleware := newCertRoutingMiddleware(t, "client_cert:\n  revocation:\n    ocsp: true\n")
+
+	_, err := middleware(tlsRequest(ca.issue(t, 2, testCertSource, responder.URL), ca.cert))
+	require.NoError(t, err)
+
+	revoked := ca.issue(t, 3, testCertSource, responder.URL)
+	_, err = middleware(tlsRequest(revoked, ca.cert))
+	require.ErrorIs(t, err, ErrCertificateRevoked)
+
+	// The second lookup for the same certificate is served from the cache.
+	_, err = middleware(tlsRequest(revoked, ca.cert))
+	require.ErrorIs(t, err, ErrCertificateRevoked)
+	assert.Equal(t, int32(2), requests.Load())
+
+	// An unreachable responder does not reject the request unless failing closed.
+	unreachable := ca.issue(t, 4, testCertSource, "http://127.0.0.1:1")
+	_, err = middleware(tlsRequest(unreachable, ca.cert))
+	require.NoError(t, err)
+
+	failClosed := newCertRoutingMiddleware(t, "client_cert:\n  revocation:\n    ocsp: true\n    ocsp_fail_open: false\n")
+	_, err = failClosed(tlsRequest(unreachable, ca.cert))
+	require.ErrorIs(t, err, ErrRevocationUnknown)
+}
+
+func TestOCSPCacheExpiresAtNextUpdate(t *testing.T) {
+	ca := newTestCA(t, "root")
+	var requests atomic.Int32
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		ocspReq, err := ocsp.ParseRequest(body)
+		require.NoError(t, err)
+		resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: ocspReq.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			// Response times have a resolution of a second.
+			NextUpdate: time.Now().Add(2 * time.Second),
+		}, ca.key)
+		require.NoError(t, err)
+		_, _ = w.Write(resp)
+	}))
+	defer responder.Close()
+
+	middleware := newCertRoutingMiddleware(t, "client_cert:\n  revocation:\n    ocsp: true\n")
+	req := tlsRequest(ca.issue(t, 2, testCertSource, responder.URL), ca.cert)
+	for range 2 {
+		_, err := middleware(req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int32(1), requests.Load())
+
+	// The response is not used past its next update, although the TTL is longer.
+	time.Sleep(2100 * time.Millisecond)
+	_, err := middleware(req)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+}
@@ -0,0 +1,60 @@
+package httpinputserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	serverTLS            = "tls"
+	tlsCertFile          = "cert_file"
+	tlsKeyFile           = "key_file"
+	tlsClientRootCAsFile = "client_root_cas_file"
+)
+
+// ServerTLSField configures the listener of inputs that run their own server
+// rather than the benthos http_server. Client certificates are always required.
+var ServerTLSField = service.NewObjectField(serverTLS,
+	service.NewStringField(tlsCertFile).Description("Server certificate file."),
+	service.NewStringField(tlsKeyFile).Description("Server private key file."),
+	service.NewStringField(tlsClientRootCAsFile).Description("Root CAs that client certificates must chain to."),
+).Description("Server TLS settings. Client certificates are always required.")
+
+// ServerTLSConfig builds a mutual TLS server config from the ServerTLSField of conf.
+func ServerTLSConfig(conf *service.ParsedConfig) (*tls.Config, error) {
+	conf = conf.Namespace(serverTLS)
+	certFile, err := conf.FieldString(tlsCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", tlsCertFile, err)
+	}
+	keyFile, err := conf.FieldString(tlsKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", tlsKeyFile, err)
+	}
+	caFile, err := conf.FieldString(tlsClientRootCAsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", tlsClientRootCAsFile, err)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client root CAs: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
@@ -0,0 +1,246 @@
+package httpinputserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// responseStatusKey is the metadata key the sync response resources store the status under.
+	responseStatusKey = "response_status"
+	// retryAfterKey is the header that tells rate limited clients when to retry.
+	retryAfterKey = "Retry-After"
+)
+
+var errLineTimeout = errors.New("timed out waiting for line to be processed")
+
+type ndjsonLine struct {
+	number int
+	msg    *service.Message
+	store  *service.SyncResponseStore
+	done   chan error
+	// status and reason are set for lines answered without the pipeline.
+	status int
+	reason string
+}
+
+// streamInput is the part shared by the inputs that run their own mTLS
+// server. Handlers submit every received message as a line and wait for the
+// pipeline to acknowledge it, so one request or session can carry many
+// messages with one outcome each.
+type streamInput struct {
+	address      string
+	tlsConfig    *tls.Config
+	authenticate func(*http.Request) (map[string]any, error)
+	maxLineBytes int
+	maxInFlight  int
+	lineTimeout  time.Duration
+	logger       *service.Logger
+	// rateLimit names the rate limit resource of res every message must pass.
+	rateLimit string
+	res       *service.Resources
+	// resolver is closed with the input when set.
+	resolver *CertSourceResolver
+
+	lines    chan *ndjsonLine
+	server   *http.Server
+	shutdown chan struct{}
+	once     sync.Once
+}
+
+func newStreamInput(authenticate func(*http.Request) (map[string]any, error), maxLineBytes, maxInFlight int, lineTimeout time.Duration, logger *service.Logger) *streamInput {
+	return &streamInput{
+		authenticate: authenticate,
+		maxLineBytes: maxLineBytes,
+		maxInFlight:  maxInFlight,
+		lineTimeout:  lineTimeout,
+		logger:       logger,
+		lines:        make(chan *ndjsonLine),
+		shutdown:     make(chan struct{}),
+	}
+}
+
+// serve starts the HTTPS server with handler unless it is already running.
+func (s *streamInput) serve(handler http.Handler) error {
+	if s.server != nil {
+		return nil
+	}
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.address, err)
+	}
+	s.server = &http.Server{
+		Addr:              s.address,
+		Handler:           handler,
+		TLSConfig:         s.tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := s.server.ServeTLS(listener, "", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorf("server on %s stopped: %v", s.address, err)
+		}
+	}()
+	return nil
+}
+
+// ReadBatch hands the next line of any open request to the pipeline.
+func (s *streamInput) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	select {
+	case line := <-s.lines:
+		return service.MessageBatch{line.msg}, func(_ context.Context, err error) error {
+			line.done <- err
+			return nil
+		}, nil
+	case <-s.shutdown:
+		return nil, nil, service.ErrNotConnected
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// Close stops the HTTP server.
+func (s *streamInput) Close(ctx context.Context) error {
+	s.once.Do(func() {
+		close(s.shutdown)
+		if s.resolver != nil {
+			s.resolver.Close()
+		}
+	})
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
+
+// rejectUnauthenticated answers a request whose client could not be
+// authenticated. The reason is logged rather than returned to the client.
+func (s *streamInput) rejectUnauthenticated(w http.ResponseWriter, err error) {
+	s.logger.Warnf("Client authentication failed: %v", err)
+	http.Error(w, "unauthorized", http.StatusUnauthorized)
+}
+
+// accessRateLimit returns how long to wait before the rate limit resource
+// admits another message. It is zero when the input has no rate limit.
+func (s *streamInput) accessRateLimit(ctx context.Context) (time.Duration, error) {
+	if s.rateLimit == "" {
+		return 0, nil
+	}
+	var wait time.Duration
+	var err error
+	if rerr := s.res.AccessRateLimit(ctx, s.rateLimit, func(rl service.RateLimit) {
+		wait, err = rl.Access(ctx)
+	}); rerr != nil {
+		return 0, rerr
+	}
+	return wait, err
+}
+
+// rateLimited answers the request and reports true when the rate limit
+// resource does not admit it.
+func (s *streamInput) rateLimited(w http.ResponseWriter, r *http.Request) bool {
+	wait, err := s.accessRateLimit(r.Context())
+	if err != nil {
+		http.Error(w, "Server error", http.StatusBadGateway)
+		s.logger.Warnf("Failed to access rate limit: %v", err)
+		return true
+	}
+	if wait > 0 {
+		w.Header().Set(retryAfterKey, strconv.Itoa(int(wait.Seconds())))
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
+
+// newLine builds the pipeline message for one line or frame of a request.
+func (s *streamInput) newLine(number int, raw []byte, meta map[string]any, contentType string) *ndjsonLine {
+	msg := service.NewMessage(raw)
+	for key, value := range meta {
+		msg.MetaSetMut(key, value)
+	}
+	msg.MetaSetMut("Content-Type", contentType)
+	msg.MetaSetMut(ReceivedAtKey, ReceivedAtValue(time.Now()))
+	msg, store := msg.WithSyncResponseStore()
+	return &ndjsonLine{number: number, msg: msg, store: store, done: make(chan error, 1)}
+}
+
+// submit queues line for a response and hands it to the pipeline unless the
+// rate limit rejects it. It blocks while maxInFlight lines of the request are
+// waiting.
+func (s *streamInput) submit(ctx context.Context, line *ndjsonLine, pending chan<- *ndjsonLine) error {
+	wait, err := s.accessRateLimit(ctx)
+	if err != nil {
+		s.logger.Warnf("Failed to access rate limit: %v", err)
+		line.status, line.reason = http.StatusBadGateway, "Server error"
+	} else if wait > 0 {
+		line.status, line.reason = http.StatusTooManyRequests, "Too Many Requests"
+	}
+	select {
+	case pending <- line:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if line.status != 0 {
+		return nil
+	}
+	select {
+	case s.lines <- line:
+		return nil
+	case <-s.shutdown:
+		line.done <- service.ErrNotConnected
+		return service.ErrNotConnected
+	case <-ctx.Done():
+		line.done <- ctx.Err()
+		return ctx.Err()
+	}
+}
+
+// waitForLine returns the status of a submitted line and the error reported for it.
+func (s *streamInput) waitForLine(ctx context.Context, line *ndjsonLine) (int, string) {
+	if line.status != 0 {
+		return line.status, line.reason
+	}
+	timer := time.NewTimer(s.lineTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case err = <-line.done:
+	case <-timer.C:
+		err = errLineTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err.Error()
+	}
+	return SyncResponseStatus(line.store)
+}
+
+// SyncResponseStatus reports the first error response set by the pipeline's
+// sync response resources. Messages without one were accepted.
+func SyncResponseStatus(store *service.SyncResponseStore) (int, string) {
+	for _, batch := range store.Read() {
+		for _, msg := range batch {
+			rawStatus, ok := msg.MetaGet(responseStatusKey)
+			if !ok {
+				continue
+			}
+			status, err := strconv.Atoi(rawStatus)
+			if err != nil || status < http.StatusMultipleChoices {
+				continue
+			}
+			body, _ := msg.AsBytes()
+			return status, string(body)
+		}
+	}
+	return http.StatusOK, ""
+}
@@ -0,0 +1,203 @@
+package httpinputserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	websocketInputName     = "dimo_websocket_connection_server"
+	websocketAddress       = "address"
+	websocketPath          = "path"
+	websocketRateLimit     = "rate_limit"
+	websocketMaxFrameBytes = "max_frame_bytes"
+	websocketMaxInFlight   = "max_in_flight"
+	websocketFrameTimeout  = "frame_timeout"
+
+	wsCloseTimeout = 5 * time.Second
+)
+
+var websocketConfigSpec = service.NewConfigSpec().
+	Summary("Accepts long-lived WebSocket sessions from mTLS clients. The client certificate is checked once per session and every frame is processed as its own connection message, with the outcome sent back on the socket.").
+	Field(service.NewStringField(websocketAddress).Default("0.0.0.0:9446").Description("Address to listen on.")).
+	Field(service.NewStringField(websocketPath).Default("/ws").Description("Path to accept WebSocket sessions on.")).
+	Field(ServerTLSField).
+	Field(ClientCertField).
+	Field(service.NewStringField(websocketRateLimit).Default("").Description("Optional rate limit resource that every upgrade request and every frame must pass. Limited upgrades are answered with 429 and limited frames are reported with status 429 without being processed.")).
+	Field(service.NewIntField(websocketMaxFrameBytes).Default(1 << 20).Description("Maximum size of a frame. The session is closed at the first larger frame.")).
+	Field(service.NewIntField(websocketMaxInFlight).Default(64).Description("Maximum number of frames of one session that are processed concurrently. Reading from the session stops while the limit is reached.")).
+	Field(service.NewDurationField(websocketFrameTimeout).Default("30s").Description("Maximum time to wait for a frame to be processed before reporting it as failed."))
+
+func init() {
+	err := service.RegisterBatchInput(websocketInputName, websocketConfigSpec, websocketCtor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+var errFrameTooLong = errors.New("frame exceeds max_frame_bytes")
+
+// frameOutcome is sent back on the socket for every received frame.
+type frameOutcome struct {
+	Frame  int    `json:"frame"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+var wsUpgrader = websocket.Upgrader{}
+
+type websocketInput struct {
+	*streamInput
+	path string
+}
+
+func websocketCtor(conf *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+	address, err := conf.FieldString(websocketAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", websocketAddress, err)
+	}
+	path, err := conf.FieldString(websocketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", websocketPath, err)
+	}
+	rateLimit, err := conf.FieldString(websocketRateLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", websocketRateLimit, err)
+	}
+	if rateLimit != "" && !mgr.HasRateLimit(rateLimit) {
+		return nil, fmt.Errorf("rate limit resource %q was not found", rateLimit)
+	}
+	maxFrameBytes, err := conf.FieldInt(websocketMaxFrameBytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", websocketMaxFrameBytes, err)
+	}
+	maxInFlight, err := conf.FieldInt(websocketMaxInFlight)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", websocketMaxInFlight, err)
+	}
+	frameTimeout, err := conf.FieldDuration(websocketFrameTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", websocketFrameTimeout, err)
+	}
+	if maxFrameBytes <= 0 || maxInFlight <= 0 {
+		return nil, fmt.Errorf("%s and %s must be positive", websocketMaxFrameBytes, websocketMaxInFlight)
+	}
+	tlsConfig, err := ServerTLSConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := NewCertSourceResolver(conf)
+	if err != nil {
+		return nil, err
+	}
+	input := newWebSocketInput(certRoutingMiddleware(resolver), maxFrameBytes, maxInFlight, frameTimeout, mgr)
+	input.address = address
+	input.path = path
+	input.rateLimit = rateLimit
+	input.tlsConfig = tlsConfig
+	input.resolver = resolver
+	return input, nil
+}
+
+func newWebSocketInput(authenticate func(*http.Request) (map[string]any, error), maxFrameBytes, maxInFlight int, frameTimeout time.Duration, res *service.Resources) *websocketInput {
+	input := &websocketInput{streamInput: newStreamInput(authenticate, maxFrameBytes, maxInFlight, frameTimeout, res.Logger())}
+	input.res = res
+	return input
+}
+
+// Connect starts the HTTP server.
+func (ws *websocketInput) Connect(context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(ws.path, ws.serveWebSocket)
+	return ws.serve(mux)
+}
+
+// serveWebSocket authenticates the client certificate once and then processes
+// every data frame of the session as a connection message. The outcome of
+// each frame is sent back on the socket in the order the frames were received.
+func (ws *websocketInput) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if ws.rateLimited(w, r) {
+		return
+	}
+	meta, err := ws.authenticate(r)
+	if err != nil {
+		ws.rejectUnauthenticated(w, err)
+		return
+	}
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has already answered the request.
+		return
+	}
+	defer conn.Close()
+	// The upgrader does not bound frames; larger ones fail the next read.
+	conn.SetReadLimit(int64(ws.maxLineBytes))
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		// Hijacked connections are not closed by the server on shutdown.
+		select {
+		case <-ws.shutdown:
+			_ = conn.Close()
+		case <-ctx.Done():
+		}
+	}()
+
+	pending := make(chan *ndjsonLine, ws.maxInFlight)
+	var readErr error
+	go func() {
+		defer close(pending)
+		readErr = ws.readFrames(ctx, conn, meta, contentType, pending)
+	}()
+
+	for line := range pending {
+		outcome := frameOutcome{Frame: line.number}
+		outcome.Status, outcome.Error = ws.waitForLine(ctx, line)
+		_ = conn.SetWriteDeadline(time.Now().Add(ws.lineTimeout))
+		if err := conn.WriteJSON(outcome); err != nil {
+			// The client is gone; keep draining so every frame is acknowledged.
+			continue
+		}
+	}
+
+	closeCode, reason := websocket.CloseNormalClosure, ""
+	if errors.Is(readErr, errFrameTooLong) {
+		closeCode, reason = websocket.CloseMessageTooBig, readErr.Error()
+	}
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, reason), time.Now().Add(wsCloseTimeout))
+}
+
+// readFrames sends every frame that passes the rate limit to the pipeline and
+// queues it for a response until the client closes the session. It blocks
+// once maxInFlight frames are waiting, which stops reading from the client.
+func (ws *websocketInput) readFrames(ctx context.Context, conn *websocket.Conn, meta map[string]any, contentType string, pending chan<- *ndjsonLine) error {
+	number := 0
+	for {
+		_, raw, err := conn.ReadMessage()
+		if err != nil {
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				return nil
+			}
+			if errors.Is(err, websocket.ErrReadLimit) {
+				return fmt.Errorf("frame %d: %w", number+1, errFrameTooLong)
+			}
+			return fmt.Errorf("failed to read frame: %w", err)
+		}
+		number++
+		line := ws.newLine(number, raw, meta, contentType)
+		if err := ws.submit(ctx, line, pending); err != nil {
+			return err
+		}
+	}
+}
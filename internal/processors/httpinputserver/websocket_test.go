@@ -0,0 +1,120 @@
+package httpinputserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newWebSocketServer serves the input's WebSocket handler as if every client
+// had presented the given TLS connection state.
+func newWebSocketServer(t *testing.T, input *websocketInput, req *http.Request) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.TLS = req.TLS
+		input.serveWebSocket(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestWebSocketSession(t *testing.T) {
+	ca := newTestCA(t, "root")
+	input := newWebSocketInput(newCertRoutingMiddleware(t, "client_cert: {}"), 64, 2, time.Second, service.MockResources())
+	runTestPipeline(t, input)
+
+	t.Run("per frame outcomes", func(t *testing.T) {
+		url := newWebSocketServer(t, input, tlsRequest(ca.issue(t, 2, testCertSource, ""), ca.cert))
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		for _, frame := range []string{`{"id":"1"}`, `{"id":"bad"}`, `{"id":"nack"}`, `{"id":"4"}`} {
+			require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(frame)))
+		}
+		var outcomes []frameOutcome
+		for range 4 {
+			var outcome frameOutcome
+			require.NoError(t, conn.ReadJSON(&outcome))
+			outcomes = append(outcomes, outcome)
+		}
+		assert.Equal(t, []frameOutcome{
+			{Frame: 1, Status: http.StatusOK},
+			{Frame: 2, Status: http.StatusBadRequest, Error: "failed to convert to cloudevent"},
+			{Frame: 3, Status: http.StatusInternalServerError, Error: "output unavailable"},
+			{Frame: 4, Status: http.StatusOK},
+		}, outcomes)
+
+		require.NoError(t, conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")))
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	})
+
+	t.Run("frame too long", func(t *testing.T) {
+		url := newWebSocketServer(t, input, tlsRequest(ca.issue(t, 3, testCertSource, ""), ca.cert))
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"`+strings.Repeat("a", 100)+`"}`)))
+		_, _, err = conn.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), err)
+	})
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		url := newWebSocketServer(t, input, httptest.NewRequest(http.MethodGet, "/", nil))
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		require.ErrorIs(t, err, websocket.ErrBadHandshake)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		_ = resp.Body.Close()
+	})
+}
+
+func TestWebSocketRateLimit(t *testing.T) {
+	ca := newTestCA(t, "root")
+	var limited atomic.Bool
+	res := service.MockResources(service.MockResourcesOptAddRateLimit("limit", func(context.Context) (time.Duration, error) {
+		if limited.Load() {
+			return 2 * time.Second, nil
+		}
+		return 0, nil
+	}))
+	input := newWebSocketInput(newCertRoutingMiddleware(t, "client_cert: {}"), 64, 2, time.Second, res)
+	input.rateLimit = "limit"
+	runTestPipeline(t, input)
+	url := newWebSocketServer(t, input, tlsRequest(ca.issue(t, 2, testCertSource, ""), ca.cert))
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	defer conn.Close()
+	var outcomes []frameOutcome
+	for _, limit := range []bool{false, true, false} {
+		limited.Store(limit)
+		require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(`{"id":"1"}`)))
+		var outcome frameOutcome
+		require.NoError(t, conn.ReadJSON(&outcome))
+		outcomes = append(outcomes, outcome)
+	}
+	assert.Equal(t, []frameOutcome{
+		{Frame: 1, Status: http.StatusOK},
+		{Frame: 2, Status: http.StatusTooManyRequests, Error: "Too Many Requests"},
+		{Frame: 3, Status: http.StatusOK},
+	}, outcomes)
+
+	// New sessions are refused while limited.
+	limited.Store(true)
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get(retryAfterKey))
+	_ = resp.Body.Close()
+}
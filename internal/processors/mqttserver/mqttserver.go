@@ -0,0 +1,318 @@
+// Package mqttserver runs an embedded MQTT broker so devices that speak MQTT
+// natively can publish connection payloads without an HTTPS bridge.
+package mqttserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	mqtt "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// TopicKey is the metadata key holding the topic a message was published on.
+	TopicKey = "dimo_mqtt_topic"
+	// ClientIDKey is the metadata key holding the MQTT client ID of the publisher.
+	ClientIDKey = "dimo_mqtt_client_id"
+
+	contentTypeKey = "Content-Type"
+	listenerID     = "dimo-mqtt-tls"
+	protocolV5     = 5
+)
+
+var errAckTimeout = errors.New("timed out waiting for message to be processed")
+
+type pendingMsg struct {
+	msg   *service.Message
+	store *service.SyncResponseStore
+	done  chan error
+}
+
+type input struct {
+	address    string
+	tlsConfig  *tls.Config
+	ackTimeout time.Duration
+	maxPacket  uint32
+	logger     *service.Logger
+	hook       *connectionHook
+
+	msgs     chan *pendingMsg
+	server   *mqtt.Server
+	shutdown chan struct{}
+	once     sync.Once
+}
+
+func newInput(address string, tlsConfig *tls.Config, resolver *httpinputserver.CertSourceResolver, topics []string, ackTimeout time.Duration, maxPacket uint32, logger *service.Logger) *input {
+	in := &input{
+		address:    address,
+		tlsConfig:  tlsConfig,
+		ackTimeout: ackTimeout,
+		maxPacket:  maxPacket,
+		logger:     logger,
+		msgs:       make(chan *pendingMsg),
+		shutdown:   make(chan struct{}),
+	}
+	in.hook = &connectionHook{
+		resolver: resolver,
+		topics:   topics,
+		publish:  in.publish,
+		logger:   logger,
+	}
+	return in
+}
+
+// Connect starts the broker.
+func (in *input) Connect(context.Context) error {
+	if in.server != nil {
+		return nil
+	}
+	caps := mqtt.NewDefaultServerCapabilities()
+	// QoS2 publishes are downgraded to QoS1; the pipeline gives at least once delivery.
+	caps.MaximumQos = 1
+	caps.RetainAvailable = 0
+	caps.WildcardSubAvailable = 0
+	caps.SharedSubAvailable = 0
+	caps.MaximumPacketSize = in.maxPacket
+	server := mqtt.New(&mqtt.Options{
+		Capabilities: caps,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err := server.AddHook(in.hook, nil); err != nil {
+		return fmt.Errorf("failed to add connection hook: %w", err)
+	}
+	listener := listeners.NewTCP(listeners.Config{ID: listenerID, Address: in.address, TLSConfig: in.tlsConfig})
+	if err := server.AddListener(listener); err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", in.address, err)
+	}
+	if err := server.Serve(); err != nil {
+		return fmt.Errorf("failed to start mqtt server: %w", err)
+	}
+	in.server = server
+	return nil
+}
+
+// ReadBatch hands the next published message to the pipeline.
+func (in *input) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	select {
+	case pending := <-in.msgs:
+		return service.MessageBatch{pending.msg}, func(_ context.Context, err error) error {
+			pending.done <- err
+			return nil
+		}, nil
+	case <-in.shutdown:
+		return nil, nil, service.ErrNotConnected
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// Close stops the broker.
+func (in *input) Close(context.Context) error {
+	in.once.Do(func() {
+		close(in.shutdown)
+		in.hook.resolver.Close()
+	})
+	if in.server == nil {
+		return nil
+	}
+	return in.server.Close()
+}
+
+// publish sends msg through the pipeline and waits until it is acknowledged.
+// It returns the status set by the sync response resources, or an error when
+// the message was not delivered.
+func (in *input) publish(msg *service.Message) (int, string, error) {
+	msg, store := msg.WithSyncResponseStore()
+	pending := &pendingMsg{msg: msg, store: store, done: make(chan error, 1)}
+	timer := time.NewTimer(in.ackTimeout)
+	defer timer.Stop()
+	select {
+	case in.msgs <- pending:
+	case <-in.shutdown:
+		return 0, "", service.ErrNotConnected
+	case <-timer.C:
+		return 0, "", errAckTimeout
+	}
+	select {
+	case err := <-pending.done:
+		if err != nil {
+			return 0, "", err
+		}
+	case <-in.shutdown:
+		return 0, "", service.ErrNotConnected
+	case <-timer.C:
+		return 0, "", errAckTimeout
+	}
+	status, reason := httpinputserver.SyncResponseStatus(store)
+	return status, reason, nil
+}
+
+// connectionHook authenticates clients by certificate, restricts them to
+// publishing on the configured topics and forwards publishes to the pipeline.
+type connectionHook struct {
+	mqtt.HookBase
+	resolver *httpinputserver.CertSourceResolver
+	topics   []string
+	publish  func(*service.Message) (int, string, error)
+	logger   *service.Logger
+	// sources maps connected clients to their connection source.
+	sources sync.Map
+}
+
+// ID to fulfill the mqtt.Hook interface.
+func (*connectionHook) ID() string {
+	return "dimo-connection"
+}
+
+// Provides to fulfill the mqtt.Hook interface.
+func (*connectionHook) Provides(b byte) bool {
+	return bytes.Contains([]byte{
+		mqtt.OnConnectAuthenticate,
+		mqtt.OnACLCheck,
+		mqtt.OnPublish,
+		mqtt.OnDisconnect,
+	}, []byte{b})
+}
+
+// OnConnectAuthenticate maps the client certificate to a connection source
+// the same way as the HTTPS connection input.
+func (h *connectionHook) OnConnectAuthenticate(cl *mqtt.Client, _ packets.Packet) bool {
+	conn, ok := cl.Net.Conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	source, err := h.resolver.Resolve(context.Background(), conn.ConnectionState().VerifiedChains)
+	if err != nil {
+		h.logger.Warnf("rejected mqtt client %s from %s: %v", cl.ID, cl.Net.Remote, err)
+		return false
+	}
+	h.sources.Store(cl, source.Hex())
+	return true
+}
+
+// OnDisconnect forgets the source of a disconnected client.
+func (h *connectionHook) OnDisconnect(cl *mqtt.Client, _ error, _ bool) {
+	h.sources.Delete(cl)
+}
+
+// OnACLCheck allows publishing on the configured topics and never allows subscribing.
+func (h *connectionHook) OnACLCheck(_ *mqtt.Client, topic string, write bool) bool {
+	if !write {
+		return false
+	}
+	for _, filter := range h.topics {
+		if matchTopic(filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+// OnPublish blocks until the pipeline has processed the message, so the
+// broker only sends the PUBACK for accepted messages. MQTT 5 clients receive
+// a reason code for rejected messages; older clients get no PUBACK and
+// redeliver after reconnecting.
+func (h *connectionHook) OnPublish(cl *mqtt.Client, pk packets.Packet) (packets.Packet, error) {
+	source, ok := h.sources.Load(cl)
+	if !ok {
+		return pk, h.reject(cl, pk, packets.ErrNotAuthorized)
+	}
+	msg := service.NewMessage(bytes.Clone(pk.Payload))
+	msg.MetaSetMut(httpinputserver.DIMOCloudEventSource, source)
+	msg.MetaSetMut(processors.MessageContentKey, httpinputserver.ConnectionContent)
+	msg.MetaSetMut(TopicKey, pk.TopicName)
+	msg.MetaSetMut(ClientIDKey, cl.ID)
+	msg.MetaSetMut(httpinputserver.ReceivedAtKey, httpinputserver.ReceivedAtValue(time.Now()))
+	if pk.Properties.ContentType != "" {
+		msg.MetaSetMut(contentTypeKey, pk.Properties.ContentType)
+	}
+
+	status, reason, err := h.publish(msg)
+	if err != nil {
+		h.logger.Warnf("failed to deliver mqtt message from %s on %s: %v", source, pk.TopicName, err)
+		return pk, h.reject(cl, pk, packets.ErrImplementationSpecificError)
+	}
+	if status >= http.StatusMultipleChoices {
+		h.logger.Debugf("rejected mqtt message from %s on %s: %s", source, pk.TopicName, reason)
+		return pk, h.reject(cl, pk, reasonCode(status))
+	}
+	// Nothing may subscribe, so only stop the broker from keeping a copy.
+	pk.FixedHeader.Retain = false
+	return pk, nil
+}
+
+// reject returns the error that makes the broker answer with code, or send no
+// PUBACK at all when the client cannot receive a reason code.
+func (*connectionHook) reject(cl *mqtt.Client, pk packets.Packet, code packets.Code) error {
+	if cl.Properties.ProtocolVersion == protocolV5 && pk.FixedHeader.Qos > 0 {
+		return code
+	}
+	return packets.ErrRejectPacket
+}
+
+// reasonCode maps the HTTP status of a sync response to an MQTT 5 reason code.
+func reasonCode(status int) packets.Code {
+	switch status {
+	case http.StatusBadRequest:
+		return packets.ErrPayloadFormatInvalid
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return packets.ErrNotAuthorized
+	case http.StatusTooManyRequests:
+		return packets.ErrQuotaExceeded
+	default:
+		return packets.ErrUnspecifiedError
+	}
+}
+
+// matchTopic reports whether topic matches the MQTT topic filter.
+func matchTopic(filter, topic string) bool {
+	// Wildcards do not match topics reserved for the broker. [MQTT-4.7.2-1]
+	if strings.HasPrefix(topic, "$") && (strings.HasPrefix(filter, "+") || strings.HasPrefix(filter, "#")) {
+		return false
+	}
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+	for i, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[i] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
+
+// validTopicFilter reports whether filter is a well formed MQTT topic filter.
+func validTopicFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || i != len(levels)-1) {
+			return false
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+package mqttserver
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSource = "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b"
+
+type testPKI struct {
+	caCert *x509.Certificate
+	caKey  *ecdsa.PrivateKey
+	pool   *x509.CertPool
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testPKI{caCert: cert, caKey: key, pool: pool}
+}
+
+func (p *testPKI) issue(t *testing.T, serial int64, cn string, usage x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.caCert, &key.PublicKey, p.caKey)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// startTestInput starts the broker and a pipeline that answers payloads
+// containing "bad" with a 400 sync response and fails delivery of "nack".
+func startTestInput(t *testing.T, pki *testPKI) (string, <-chan *service.Message) {
+	t.Helper()
+	parsed, err := service.NewConfigSpec().Field(httpinputserver.ClientCertField).ParseYAML("client_cert: {}", nil)
+	require.NoError(t, err)
+	resolver, err := httpinputserver.NewCertSourceResolver(parsed)
+	require.NoError(t, err)
+	serverTLS := &tls.Config{
+		Certificates: []tls.Certificate{pki.issue(t, 2, "server", x509.ExtKeyUsageServerAuth)},
+		ClientCAs:    pki.pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}
+	in := newInput("127.0.0.1:0", serverTLS, resolver, []string{"devices/+/status"}, time.Second, 1<<20, service.MockResources().Logger())
+	require.NoError(t, in.Connect(context.Background()))
+	t.Cleanup(func() { _ = in.Close(context.Background()) })
+
+	accepted := make(chan *service.Message, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go func() {
+		for {
+			batch, ack, err := in.ReadBatch(ctx)
+			if err != nil {
+				return
+			}
+			msg := batch[0]
+			body, _ := msg.AsBytes()
+			switch {
+			case strings.Contains(string(body), "bad"):
+				msg.MetaSetMut("response_status", 400)
+				msg.SetBytes([]byte("failed to convert to cloudevent"))
+				_ = msg.AddSyncResponse()
+				_ = ack(ctx, nil)
+			case strings.Contains(string(body), "nack"):
+				_ = ack(ctx, errors.New("output unavailable"))
+			default:
+				accepted <- msg
+				_ = ack(ctx, nil)
+			}
+		}
+	}()
+
+	listener, ok := in.server.Listeners.Get(listenerID)
+	require.True(t, ok)
+	return listener.Address(), accepted
+}
+
+type testClient struct {
+	conn    *tls.Conn
+	reader  *bufio.Reader
+	version byte
+}
+
+func dialTestClient(t *testing.T, pki *testPKI, addr string, cert tls.Certificate, version byte) (*testClient, error) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		RootCAs:      pki.pool,
+		MinVersion:   tls.VersionTLS12,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	client := &testClient{conn: conn, reader: bufio.NewReader(conn), version: version}
+
+	protocolName := "MQTT"
+	if version == 3 {
+		protocolName = "MQIsdp"
+	}
+	connect := packets.Packet{
+		FixedHeader:     packets.FixedHeader{Type: packets.Connect},
+		ProtocolVersion: version,
+		Connect: packets.ConnectParams{
+			ProtocolName:     []byte(protocolName),
+			Clean:            true,
+			Keepalive:        30,
+			ClientIdentifier: "device-1",
+		},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, connect.ConnectEncode(&buf))
+	_, err = conn.Write(buf.Bytes())
+	require.NoError(t, err)
+
+	packetType, body, err := client.read(time.Second)
+	if err != nil {
+		return nil, err
+	}
+	require.Equal(t, packets.Connack, packetType)
+	if body[1] != 0 {
+		return nil, errors.New("connection refused")
+	}
+	return client, nil
+}
+
+// publish sends a QoS1 publish and returns the PUBACK reason code, or an
+// error if no PUBACK arrives.
+func (c *testClient) publish(t *testing.T, id uint16, topic, payload string) (byte, error) {
+	t.Helper()
+	pk := packets.Packet{
+		FixedHeader:     packets.FixedHeader{Type: packets.Publish, Qos: 1},
+		ProtocolVersion: c.version,
+		TopicName:       topic,
+		PacketID:        id,
+		Payload:         []byte(payload),
+	}
+	var buf bytes.Buffer
+	require.NoError(t, pk.PublishEncode(&buf))
+	_, err := c.conn.Write(buf.Bytes())
+	require.NoError(t, err)
+
+	packetType, body, err := c.read(500 * time.Millisecond)
+	if err != nil {
+		return 0, err
+	}
+	require.Equal(t, packets.Puback, packetType)
+	if len(body) < 3 {
+		return packets.CodeSuccess.Code, nil
+	}
+	return body[2], nil
+}
+
+func (c *testClient) read(timeout time.Duration) (byte, []byte, error) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(timeout))
+	header, err := c.reader.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	length, multiplier := 0, 1
+	for {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length += int(b&0x7f) * multiplier
+		if b&0x80 == 0 {
+			break
+		}
+		multiplier *= 128
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return 0, nil, err
+	}
+	return header >> 4, body, nil
+}
+
+func TestMQTTPublish(t *testing.T) {
+	pki := newTestPKI(t)
+	addr, accepted := startTestInput(t, pki)
+	clientCert := pki.issue(t, 3, testSource, x509.ExtKeyUsageClientAuth)
+
+	t.Run("mqtt 5", func(t *testing.T) {
+		client, err := dialTestClient(t, pki, addr, clientCert, 5)
+		require.NoError(t, err)
+
+		code, err := client.publish(t, 1, "devices/1/status", `{"id":"1"}`)
+		require.NoError(t, err)
+		assert.Equal(t, packets.CodeSuccess.Code, code)
+		msg := <-accepted
+		source, _ := msg.MetaGet(httpinputserver.DIMOCloudEventSource)
+		assert.Equal(t, testSource, source)
+		topic, _ := msg.MetaGet(TopicKey)
+		assert.Equal(t, "devices/1/status", topic)
+		receivedAt, ok := httpinputserver.ReceivedAt(msg)
+		require.True(t, ok)
+		assert.WithinDuration(t, time.Now(), receivedAt, 5*time.Second)
+
+		code, err = client.publish(t, 2, "devices/1/status", `{"id":"bad"}`)
+		require.NoError(t, err)
+		assert.Equal(t, packets.ErrPayloadFormatInvalid.Code, code)
+
+		code, err = client.publish(t, 3, "devices/1/status", `{"id":"nack"}`)
+		require.NoError(t, err)
+		assert.Equal(t, packets.ErrImplementationSpecificError.Code, code)
+
+		code, err = client.publish(t, 4, "other/topic", `{"id":"4"}`)
+		require.NoError(t, err)
+		assert.Equal(t, packets.ErrNotAuthorized.Code, code)
+	})
+
+	t.Run("mqtt 3.1.1 gets no puback for rejected messages", func(t *testing.T) {
+		client, err := dialTestClient(t, pki, addr, clientCert, 4)
+		require.NoError(t, err)
+
+		_, err = client.publish(t, 1, "devices/1/status", `{"id":"bad"}`)
+		var netErr net.Error
+		require.ErrorAs(t, err, &netErr)
+		assert.True(t, netErr.Timeout())
+
+		code, err := client.publish(t, 2, "devices/1/status", `{"id":"2"}`)
+		require.NoError(t, err)
+		assert.Equal(t, packets.CodeSuccess.Code, code)
+		<-accepted
+	})
+
+	t.Run("unknown certificate identity", func(t *testing.T) {
+		_, err := dialTestClient(t, pki, addr, pki.issue(t, 4, "not an address", x509.ExtKeyUsageClientAuth), 5)
+		require.Error(t, err)
+	})
+}
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		filter string
+		topic  string
+		want   bool
+	}{
+		{filter: "#", topic: "a/b", want: true},
+		{filter: "#", topic: "$SYS/uptime", want: false},
+		{filter: "a/+/c", topic: "a/b/c", want: true},
+		{filter: "a/+/c", topic: "a/b/d", want: false},
+		{filter: "a/+", topic: "a/b/c", want: false},
+		{filter: "a/#", topic: "a", want: true},
+		{filter: "a/b", topic: "a/b", want: true},
+		{filter: "a/b", topic: "a/b/c", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchTopic(tt.filter, tt.topic), "%s %s", tt.filter, tt.topic)
+	}
+
+	for _, filter := range []string{"", "a/#/b", "a/b#", "a+/b"} {
+		assert.False(t, validTopicFilter(filter), filter)
+	}
+}
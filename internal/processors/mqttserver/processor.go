@@ -0,0 +1,69 @@
+package mqttserver
+
+import (
+	"fmt"
+
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	inputName               = "dimo_mqtt_connection_server"
+	addressFieldName        = "address"
+	topicsFieldName         = "topics"
+	ackTimeoutFieldName     = "ack_timeout"
+	maxPacketSizeFieldName  = "max_packet_size"
+	defaultMaxPacketSizeMiB = 1
+)
+
+var configSpec = service.NewConfigSpec().
+	Summary("Embedded MQTT broker endpoint for connected devices. Publishes on the configured topics become connection messages of the source identified by the client certificate.").
+	Field(service.NewStringField(addressFieldName).Default("0.0.0.0:8883").Description("Address to listen on.")).
+	Field(httpinputserver.ServerTLSField).
+	Field(httpinputserver.ClientCertField).
+	Field(service.NewStringListField(topicsFieldName).Default([]string{"#"}).Description("Topic filters clients may publish to. Subscriptions are never allowed.")).
+	Field(service.NewDurationField(ackTimeoutFieldName).Default("30s").Description("Maximum time to wait for the pipeline to accept a message before it is rejected.")).
+	Field(service.NewIntField(maxPacketSizeFieldName).Default(defaultMaxPacketSizeMiB << 20).Description("Maximum size of an MQTT packet."))
+
+func init() {
+	err := service.RegisterBatchInput(inputName, configSpec, ctor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func ctor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+	address, err := cfg.FieldString(addressFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", addressFieldName, err)
+	}
+	tlsConfig, err := httpinputserver.ServerTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	resolver, err := httpinputserver.NewCertSourceResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	topics, err := cfg.FieldStringList(topicsFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", topicsFieldName, err)
+	}
+	for _, topic := range topics {
+		if !validTopicFilter(topic) {
+			return nil, fmt.Errorf("invalid topic filter %q", topic)
+		}
+	}
+	ackTimeout, err := cfg.FieldDuration(ackTimeoutFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", ackTimeoutFieldName, err)
+	}
+	maxPacketSize, err := cfg.FieldInt(maxPacketSizeFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", maxPacketSizeFieldName, err)
+	}
+	if maxPacketSize <= 0 {
+		return nil, fmt.Errorf("%s must be positive", maxPacketSizeFieldName)
+	}
+	return newInput(address, tlsConfig, resolver, topics, ackTimeout, uint32(maxPacketSize), mgr.Logger()), nil
+}
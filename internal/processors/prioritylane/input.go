@@ -0,0 +1,293 @@
+package prioritylane
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors/decompress"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	inputName            = "dimo_priority_lane"
+	inputFieldName       = "input"
+	lanesFieldName       = "lanes"
+	laneNameFieldName    = "name"
+	laneTypesFieldName   = "types"
+	laneEventsFieldName  = "event_names"
+	laneMaxFieldName     = "max_in_flight"
+	shedLowerAtFieldName = "shed_lower_at"
+	threadsFieldName     = "threads"
+	maxDecodedFieldName  = "max_decompressed_bytes"
+
+	defaultLaneMaxInFlight = 1000
+	// readRetryDelay is the pause after the child input fails to read.
+	readRetryDelay = 100 * time.Millisecond
+)
+
+var inputConfigSpec = service.NewConfigSpec().
+	Summary("Reads from a child input and classifies each batch into a priority lane by CloudEvent type and event name before it is processed. Every lane queues a bounded number of batches, and higher priority lanes are read first. A batch is answered with 503 when its lane is full or a higher priority lane is under pressure. Its slot is freed once the batch is acknowledged.").
+	Field(service.NewInputField(inputFieldName).Description("Input to read from.")).
+	Field(service.NewObjectListField(lanesFieldName,
+		service.NewStringField(laneNameFieldName).Description("Name of the lane, used in metrics."),
+		service.NewStringListField(laneTypesFieldName).Default([]string{}).Description("CloudEvent types of the lane. Empty matches every type."),
+		service.NewStringListField(laneEventsFieldName).Default([]string{}).Description("Event names of the lane. When set, a batch only matches if it contains one of these events."),
+		service.NewIntField(laneMaxFieldName).Default(defaultLaneMaxInFlight).Description("Maximum number of batches of the lane that are queued or being processed."),
+	).Description("Lanes in order of priority, highest first. A batch belongs to the first lane any of its messages matches, and to the last lane if none match.")).
+	Field(service.NewFloatField(shedLowerAtFieldName).Default(0.5).Description("Fill ratio of a lane above which all lower priority lanes are shed.")).
+	Field(service.NewIntField(threadsFieldName).Default(-1).Description("Number of batches classified at once. -1 uses the number of CPUs.")).
+	Field(service.NewIntField(maxDecodedFieldName).Default(decompress.DefaultMaxBytes).Description("Maximum size of a compressed body decoded to classify it. Larger bodies are classified into the last lane."))
+
+func init() {
+	err := service.RegisterBatchInput(inputName, inputConfigSpec, inputCtor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// batchReader is the part of service.OwnedInput the lanes read from.
+type batchReader interface {
+	ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error)
+	Close(ctx context.Context) error
+}
+
+type input struct {
+	child   batchReader
+	lanes   *laneSet
+	threads int
+	logger  *service.Logger
+
+	// routing tracks the batches being classified.
+	routing sync.WaitGroup
+
+	// ready is signalled whenever a batch is queued.
+	ready    chan struct{}
+	done     chan struct{}
+	stop     context.CancelFunc
+	started  bool
+	once     sync.Once
+	stopOnce sync.Once
+}
+
+func inputCtor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchInput, error) {
+	child, err := cfg.FieldInput(inputFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", inputFieldName, err)
+	}
+	lanes, err := parseLanes(cfg, mgr)
+	if err != nil {
+		return nil, err
+	}
+	threads, err := cfg.FieldInt(threadsFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", threadsFieldName, err)
+	}
+	if threads <= 0 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	return newInput(child, lanes, threads, mgr.Logger()), nil
+}
+
+func parseLanes(cfg *service.ParsedConfig, mgr *service.Resources) (*laneSet, error) {
+	laneConfs, err := cfg.FieldObjectList(lanesFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", lanesFieldName, err)
+	}
+	if len(laneConfs) == 0 {
+		return nil, fmt.Errorf("%s must not be empty", lanesFieldName)
+	}
+	lanes := make([]*lane, 0, len(laneConfs))
+	for _, laneConf := range laneConfs {
+		l, err := parseLane(laneConf)
+		if err != nil {
+			return nil, err
+		}
+		lanes = append(lanes, l)
+	}
+	shedLowerAt, err := cfg.FieldFloat(shedLowerAtFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", shedLowerAtFieldName, err)
+	}
+	if shedLowerAt <= 0 || shedLowerAt > 1 {
+		return nil, fmt.Errorf("%s must be in (0, 1]", shedLowerAtFieldName)
+	}
+	maxDecoded, err := cfg.FieldInt(maxDecodedFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", maxDecodedFieldName, err)
+	}
+	if maxDecoded <= 0 {
+		return nil, fmt.Errorf("%s must be positive", maxDecodedFieldName)
+	}
+	return newLaneSet(lanes, shedLowerAt, int64(maxDecoded), mgr), nil
+}
+
+func parseLane(conf *service.ParsedConfig) (*lane, error) {
+	name, err := conf.FieldString(laneNameFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get lane %s: %w", laneNameFieldName, err)
+	}
+	types, err := conf.FieldStringList(laneTypesFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s of lane %s: %w", laneTypesFieldName, name, err)
+	}
+	events, err := conf.FieldStringList(laneEventsFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s of lane %s: %w", laneEventsFieldName, name, err)
+	}
+	maxInFlight, err := conf.FieldInt(laneMaxFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s of lane %s: %w", laneMaxFieldName, name, err)
+	}
+	if maxInFlight <= 0 {
+		return nil, fmt.Errorf("%s of lane %s must be positive", laneMaxFieldName, name)
+	}
+	return newLane(name, types, events, maxInFlight), nil
+}
+
+func newInput(child batchReader, lanes *laneSet, threads int, logger *service.Logger) *input {
+	return &input{
+		child:   child,
+		lanes:   lanes,
+		threads: threads,
+		logger:  logger,
+		ready:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+		stop:    func() {},
+	}
+}
+
+// Connect starts reading from the child input.
+func (in *input) Connect(context.Context) error {
+	in.once.Do(func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		in.stop = cancel
+		in.started = true
+		go in.readLoop(ctx)
+	})
+	return nil
+}
+
+// readLoop reads from the child input independently of the pipeline, so a
+// batch is admitted or shed as soon as it arrives rather than once the
+// pipeline has room for it. Up to threads batches are classified at once, so
+// a large batch does not hold up the ones read after it.
+func (in *input) readLoop(ctx context.Context) {
+	defer close(in.done)
+	defer in.routing.Wait()
+	slots := make(chan struct{}, in.threads)
+	for {
+		msgs, ack, err := in.child.ReadBatch(ctx)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, service.ErrEndOfInput) {
+				return
+			}
+			in.logger.Warnf("Failed to read from child input: %v", err)
+			select {
+			case <-time.After(readRetryDelay):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			_ = ack(context.Background(), service.ErrNotConnected)
+			return
+		}
+		in.routing.Add(1)
+		go func() {
+			defer in.routing.Done()
+			defer func() { <-slots }()
+			in.route(ctx, msgs, ack)
+		}()
+	}
+}
+
+// route queues a batch in its lane, or answers it with 503 when it is shed.
+func (in *input) route(ctx context.Context, msgs service.MessageBatch, ack service.AckFunc) {
+	if len(msgs) == 0 {
+		_ = ack(ctx, nil)
+		return
+	}
+	idx := in.lanes.classify(ctx, msgs)
+	l := in.lanes.lanes[idx]
+	if reason := in.lanes.admit(idx); reason != "" {
+		in.lanes.shed.Incr(1, l.name, reason)
+		in.logger.Debugf("Shed batch: %v: %s lane %s", errShed, l.name, reason)
+		shed(msgs)
+		_ = ack(ctx, nil)
+		return
+	}
+	in.lanes.admitted.Incr(1, l.name)
+	for _, msg := range msgs {
+		msg.MetaSetMut(LaneKey, l.name)
+	}
+	l.queue <- &queuedBatch{msgs: msgs, ack: ack}
+	select {
+	case in.ready <- struct{}{}:
+	default:
+	}
+}
+
+// ReadBatch hands the oldest batch of the highest priority lane with queued
+// batches to the pipeline.
+func (in *input) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	for {
+		for idx, l := range in.lanes.lanes {
+			select {
+			case queued := <-l.queue:
+				return queued.msgs, func(ctx context.Context, err error) error {
+					in.lanes.release(idx)
+					return queued.ack(ctx, err)
+				}, nil
+			default:
+			}
+		}
+		select {
+		case <-in.ready:
+		case <-in.done:
+			// The last batches may have been queued after the lanes were checked.
+			if !in.queued() {
+				return nil, nil, service.ErrEndOfInput
+			}
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
+
+// queued reports whether any lane holds a batch that was not read yet.
+func (in *input) queued() bool {
+	for _, l := range in.lanes.lanes {
+		if len(l.queue) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops reading from the child input and rejects the batches still queued.
+func (in *input) Close(ctx context.Context) error {
+	in.stopOnce.Do(func() {
+		in.stop()
+		if in.started {
+			select {
+			case <-in.done:
+			case <-ctx.Done():
+			}
+		}
+		for idx, l := range in.lanes.lanes {
+			for len(l.queue) > 0 {
+				queued := <-l.queue
+				in.lanes.release(idx)
+				_ = queued.ack(ctx, service.ErrNotConnected)
+			}
+		}
+	})
+	return in.child.Close(ctx)
+}
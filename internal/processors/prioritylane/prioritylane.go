@@ -0,0 +1,200 @@
+// Package prioritylane keeps critical traffic flowing when the outputs slow
+// down. Batches are classified into lanes as they are read from the inputs,
+// several at a time and before any processing, and each lane queues a bounded
+// number of batches. Compressed bodies are decoded to classify them, and the
+// decoded body and conversion are kept for the pipeline to reuse.
+// Lower priority lanes are shed before higher priority lanes fill up, and
+// higher priority lanes are always read first.
+package prioritylane
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/processors/cloudeventconvert"
+	"github.com/DIMO-Network/dis/internal/processors/decompress"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/DIMO-Network/dis/internal/processors/sourceratelimit"
+	"github.com/DIMO-Network/model-garage/pkg/modules"
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	// LaneKey is the metadata key holding the lane of an admitted batch.
+	LaneKey = "dimo_priority_lane"
+
+	// MetricLaneInFlight is the number of batches per lane queued or being processed.
+	MetricLaneInFlight = "dis_lane_in_flight"
+	// MetricLaneAdmitted counts admitted batches, labelled by lane.
+	MetricLaneAdmitted = "dis_lane_admitted_total"
+	// MetricLaneShed counts shed batches, labelled by lane and reason.
+	MetricLaneShed = "dis_lane_shed_total"
+
+	reasonLaneFull       = "lane_full"
+	reasonHigherPressure = "higher_priority_pressure"
+
+	responseStatusKey = "response_status"
+	retryAfterSeconds = "1"
+	shedResponse      = "service unavailable: ingest is overloaded"
+)
+
+var errShed = errors.New("ingest is overloaded")
+
+type lane struct {
+	name        string
+	types       []string
+	events      []string
+	maxInFlight int
+	inFlight    int
+	// queue holds the admitted batches that were not read yet. It is as large
+	// as the lane, so admitting never blocks.
+	queue chan *queuedBatch
+}
+
+type queuedBatch struct {
+	msgs service.MessageBatch
+	ack  service.AckFunc
+}
+
+func newLane(name string, types, events []string, maxInFlight int) *lane {
+	return &lane{name: name, types: types, events: events, maxInFlight: maxInFlight, queue: make(chan *queuedBatch, maxInFlight)}
+}
+
+// matches reports whether a message of the given types and event names belongs to the lane.
+func (l *lane) matches(ceTypes, events []string) bool {
+	if len(l.types) != 0 && !slices.ContainsFunc(ceTypes, func(ceType string) bool { return slices.Contains(l.types, ceType) }) {
+		return false
+	}
+	if len(l.events) == 0 {
+		return true
+	}
+	for _, event := range events {
+		if slices.Contains(l.events, event) {
+			return true
+		}
+	}
+	return false
+}
+
+type laneSet struct {
+	lanes       []*lane
+	shedLowerAt float64
+	// maxDecodedBytes bounds the bodies decoded to classify them.
+	maxDecodedBytes int64
+	inFlight        *service.MetricGauge
+	admitted        *service.MetricCounter
+	shed            *service.MetricCounter
+
+	mu sync.Mutex
+}
+
+func newLaneSet(lanes []*lane, shedLowerAt float64, maxDecodedBytes int64, mgr *service.Resources) *laneSet {
+	return &laneSet{
+		lanes:           lanes,
+		shedLowerAt:     shedLowerAt,
+		maxDecodedBytes: maxDecodedBytes,
+		inFlight:        mgr.Metrics().NewGauge(MetricLaneInFlight, "lane"),
+		admitted:        mgr.Metrics().NewCounter(MetricLaneAdmitted, "lane"),
+		shed:            mgr.Metrics().NewCounter(MetricLaneShed, "lane", "reason"),
+	}
+}
+
+// classify returns the index of the highest priority lane any message matches.
+func (s *laneSet) classify(ctx context.Context, msgs service.MessageBatch) int {
+	best := len(s.lanes) - 1
+	for _, msg := range msgs {
+		ceTypes, events := classifyMsg(ctx, msg, s.maxDecodedBytes)
+		for i, l := range s.lanes[:best] {
+			if l.matches(ceTypes, events) {
+				best = i
+				break
+			}
+		}
+	}
+	return best
+}
+
+// admit takes a slot of lane idx. It returns the reason when the batch is shed.
+func (s *laneSet) admit(idx int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.lanes[idx]
+	if l.inFlight >= l.maxInFlight {
+		return reasonLaneFull
+	}
+	for _, higher := range s.lanes[:idx] {
+		if float64(higher.inFlight) >= s.shedLowerAt*float64(higher.maxInFlight) {
+			return reasonHigherPressure
+		}
+	}
+	l.inFlight++
+	s.inFlight.Set(int64(l.inFlight), l.name)
+	return ""
+}
+
+// release frees the slot taken by a batch of lane idx.
+func (s *laneSet) release(idx int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.lanes[idx]
+	if l.inFlight > 0 {
+		l.inFlight--
+		s.inFlight.Set(int64(l.inFlight), l.name)
+	}
+}
+
+// classifyMsg returns the CloudEvent types a message converts to and the
+// names of the events it carries. A compressed body is classified by its
+// decoded copy, and the message itself is left for the pipeline to verify and
+// decode. Messages that cannot be decoded or converted report none and are
+// left for the pipeline to reject.
+func classifyMsg(ctx context.Context, msg *service.Message, maxDecodedBytes int64) ([]string, []string) {
+	view := msg
+	if encoding, _ := msg.MetaGet(decompress.ContentEncodingKey); encoding != "" {
+		body, err := decompress.Decode(msg, maxDecodedBytes)
+		if err != nil {
+			return nil, nil
+		}
+		view = msg.Copy()
+		view.SetBytes(body)
+		view.MetaDelete(decompress.ContentEncodingKey)
+	}
+	conv, err := cloudeventconvert.Convert(ctx, view)
+	if err != nil {
+		return nil, nil
+	}
+	if view != msg {
+		// The conversion belongs to the decoded body, which the message
+		// carries once the pipeline has decoded it.
+		msg.MetaSetMut(cloudeventconvert.ConversionKey, conv)
+	}
+	var ceTypes, events []string
+	for _, hdr := range conv.Headers {
+		ceTypes = append(ceTypes, hdr.Type)
+		if hdr.Type != cloudevent.TypeEvents {
+			continue
+		}
+		source, _ := msg.MetaGet(httpinputserver.DIMOCloudEventSource)
+		converted, err := modules.ConvertToEvents(ctx, source, cloudevent.RawEvent{CloudEventHeader: hdr, Data: conv.Data})
+		if err != nil {
+			continue
+		}
+		for _, event := range converted {
+			events = append(events, event.Data.Name)
+		}
+	}
+	return ceTypes, events
+}
+
+// shed answers every message of a batch with 503 and a retry hint.
+func shed(msgs service.MessageBatch) {
+	for _, msg := range msgs {
+		msg.MetaSetMut(responseStatusKey, "503")
+		msg.MetaSetMut(sourceratelimit.RetryAfterKey, retryAfterSeconds)
+		msg.SetBytes([]byte(shedResponse))
+		_ = msg.AddSyncResponse()
+	}
+}
@@ -0,0 +1,335 @@
+package prioritylane
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/cloudeventconvert"
+	"github.com/DIMO-Network/dis/internal/processors/decompress"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/DIMO-Network/dis/internal/processors/sourceratelimit"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+)
+
+const (
+	testSource = "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b"
+	chartPath  = "../../../charts/dis/files/streams/external-ingest.yaml"
+
+	statusData   = `{"signals":[{"name":"speed","timestamp":"2025-01-01T00:00:00Z","value":1}]}`
+	eventsData   = `{"events":[{"name":"behavior.harshBraking","timestamp":"2025-01-01T00:00:00Z"}]}`
+	criticalData = `{"events":[{"name":"behavior.harshBraking","timestamp":"2025-01-01T00:00:00Z"},{"name":"security.engineBlock","timestamp":"2025-01-01T00:00:00Z"}]}`
+)
+
+const testConfig = `
+input:
+  inproc: test
+lanes:
+  - name: critical
+    types: [dimo.events]
+    event_names: [security.engineBlock]
+    max_in_flight: 4
+  - name: events
+    types: [dimo.events, dimo.attestation]
+    max_in_flight: 4
+  - name: bulk
+    max_in_flight: 2
+`
+
+// envDefault matches environment variable references with their default.
+var envDefault = regexp.MustCompile(`\$\{[A-Z0-9_]+(?::([^}]*))?\}`)
+
+// chartLaneConfig returns the configuration of the priority lanes in the chart,
+// with every environment variable left at its default.
+func chartLaneConfig(t *testing.T) string {
+	t.Helper()
+	raw, err := os.ReadFile(chartPath)
+	require.NoError(t, err)
+	var stream struct {
+		Input struct {
+			Broker struct {
+				Inputs []map[string]any `yaml:"inputs"`
+			} `yaml:"broker"`
+		} `yaml:"input"`
+	}
+	require.NoError(t, yaml.Unmarshal(envDefault.ReplaceAll(raw, []byte("$1")), &stream))
+	for _, in := range stream.Input.Broker.Inputs {
+		conf, ok := in[inputName].(map[string]any)
+		if !ok {
+			continue
+		}
+		conf[inputFieldName] = map[string]any{"inproc": "test"}
+		out, err := yaml.Marshal(conf)
+		require.NoError(t, err)
+		return string(out)
+	}
+	t.Fatalf("no %s input in %s", inputName, chartPath)
+	return ""
+}
+
+// testReader stands in for the child input.
+type testReader struct {
+	batches chan *queuedBatch
+}
+
+func (r *testReader) ReadBatch(ctx context.Context) (service.MessageBatch, service.AckFunc, error) {
+	select {
+	case batch := <-r.batches:
+		return batch.msgs, batch.ack, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+func (*testReader) Close(context.Context) error {
+	return nil
+}
+
+func newTestInput(t *testing.T, config string) (*input, *testReader) {
+	t.Helper()
+	conf, err := inputConfigSpec.ParseYAML(config, nil)
+	require.NoError(t, err)
+	lanes, err := parseLanes(conf, service.MockResources())
+	require.NoError(t, err)
+	reader := &testReader{batches: make(chan *queuedBatch)}
+	in := newInput(reader, lanes, 4, service.MockResources().Logger())
+	require.NoError(t, in.Connect(context.Background()))
+	t.Cleanup(func() { _ = in.Close(context.Background()) })
+	return in, reader
+}
+
+// testRequest is a request of an input waiting for its outcome.
+type testRequest struct {
+	store *service.SyncResponseStore
+	acked chan error
+}
+
+func newMessage(content, body string) *service.Message {
+	msg := service.NewMessage([]byte(body))
+	msg.MetaSetMut(httpinputserver.DIMOCloudEventSource, testSource)
+	msg.MetaSetMut(processors.MessageContentKey, content)
+	return msg
+}
+
+// connectionEvent returns a connection payload in the CloudEvent format of the
+// default module, which infers the event type from data.
+func connectionEvent(data string) *service.Message {
+	return newMessage(httpinputserver.ConnectionContent, fmt.Sprintf(`{"id":"1","specversion":"1.0","subject":"did:erc721:137:0xbA5738a18d83D41847dfFbDC6101d37C69c9B0cF:1","producer":"did:erc721:137:0x9c94C395cBcBDe662235E0A9d3bB87Ad708561BA:1","time":%q,"data":%s}`,
+		time.Now().UTC().Format(time.RFC3339), data))
+}
+
+func attestation() *service.Message {
+	return newMessage(httpinputserver.AttestationContent, fmt.Sprintf(`{"id":"1","specversion":"1.0","subject":"did:erc721:137:0xbA5738a18d83D41847dfFbDC6101d37C69c9B0cF:1","time":%q,"data":{}}`,
+		time.Now().UTC().Format(time.RFC3339)))
+}
+
+// gzipped compresses the body of msg as the HTTP inputs receive it.
+func gzipped(t *testing.T, msg *service.Message) *service.Message {
+	t.Helper()
+	body, err := msg.AsBytes()
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err = w.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	msg.SetBytes(buf.Bytes())
+	msg.MetaSetMut(decompress.ContentEncodingKey, "gzip")
+	return msg
+}
+
+// send hands a message to the input as a request of the child input.
+func send(t *testing.T, reader *testReader, msg *service.Message) *testRequest {
+	t.Helper()
+	msg, store := msg.WithSyncResponseStore()
+	req := &testRequest{store: store, acked: make(chan error, 1)}
+	reader.batches <- &queuedBatch{msgs: service.MessageBatch{msg}, ack: func(_ context.Context, err error) error {
+		req.acked <- err
+		return nil
+	}}
+	return req
+}
+
+// requireShed asserts that the request was answered with 503 without being processed.
+func requireShed(t *testing.T, req *testRequest) {
+	t.Helper()
+	select {
+	case err := <-req.acked:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not answered")
+	}
+	status, body := httpinputserver.SyncResponseStatus(req.store)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, shedResponse, body)
+	for _, batch := range req.store.Read() {
+		retryAfter, _ := batch[0].MetaGet(sourceratelimit.RetryAfterKey)
+		assert.Equal(t, retryAfterSeconds, retryAfter)
+	}
+}
+
+// read returns the lane of the next batch the pipeline reads and its ack.
+func read(t *testing.T, in *input) (string, service.AckFunc) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	msgs, ack, err := in.ReadBatch(ctx)
+	require.NoError(t, err)
+	lane, _ := msgs[0].MetaGet(LaneKey)
+	return lane, ack
+}
+
+func TestClassify(t *testing.T) {
+	in, _ := newTestInput(t, testConfig)
+	unreadable := connectionEvent(statusData)
+	unreadable.SetBytes([]byte{0x1f, 0x8b, 0x08})
+	unreadable.MetaSetMut(decompress.ContentEncodingKey, "gzip")
+	tests := []struct {
+		name     string
+		batch    service.MessageBatch
+		expected string
+	}{
+		{name: "critical event", batch: service.MessageBatch{connectionEvent(criticalData)}, expected: "critical"},
+		{name: "other events", batch: service.MessageBatch{connectionEvent(eventsData)}, expected: "events"},
+		{name: "attestation", batch: service.MessageBatch{attestation()}, expected: "events"},
+		{name: "status", batch: service.MessageBatch{connectionEvent(statusData)}, expected: "bulk"},
+		{name: "compressed critical event", batch: service.MessageBatch{gzipped(t, connectionEvent(criticalData))}, expected: "critical"},
+		{name: "unreadable body", batch: service.MessageBatch{unreadable}, expected: "bulk"},
+		{name: "highest priority message wins", batch: service.MessageBatch{connectionEvent(statusData), connectionEvent(criticalData)}, expected: "critical"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := in.lanes.classify(context.Background(), tt.batch)
+			assert.Equal(t, tt.expected, in.lanes.lanes[idx].name)
+		})
+	}
+}
+
+func TestClassifyKeepsCompressedBody(t *testing.T) {
+	in, _ := newTestInput(t, testConfig)
+	msg := gzipped(t, connectionEvent(criticalData))
+	raw, err := msg.AsBytes()
+	require.NoError(t, err)
+
+	idx := in.lanes.classify(context.Background(), service.MessageBatch{msg})
+	assert.Equal(t, "critical", in.lanes.lanes[idx].name)
+
+	// The body is left compressed for verify_hmac and the decompress processor.
+	body, err := msg.AsBytes()
+	require.NoError(t, err)
+	assert.Equal(t, raw, body)
+	encoding, _ := msg.MetaGet(decompress.ContentEncodingKey)
+	assert.Equal(t, "gzip", encoding)
+	_, kept := msg.MetaGetMut(cloudeventconvert.ConversionKey)
+	assert.True(t, kept)
+}
+
+func TestShedding(t *testing.T) {
+	in, reader := newTestInput(t, testConfig)
+
+	// Bulk is shed at its own limit and freed once acknowledged.
+	var acks []service.AckFunc
+	for range 2 {
+		send(t, reader, connectionEvent(statusData))
+		lane, ack := read(t, in)
+		assert.Equal(t, "bulk", lane)
+		acks = append(acks, ack)
+	}
+	requireShed(t, send(t, reader, connectionEvent(statusData)))
+	require.NoError(t, acks[0](context.Background(), nil))
+	send(t, reader, connectionEvent(statusData))
+	lane, _ := read(t, in)
+	assert.Equal(t, "bulk", lane)
+	require.NoError(t, acks[1](context.Background(), nil))
+
+	// Once the events lane is half full, bulk is shed before events are.
+	for range 2 {
+		send(t, reader, connectionEvent(eventsData))
+		lane, _ := read(t, in)
+		assert.Equal(t, "events", lane)
+	}
+	requireShed(t, send(t, reader, connectionEvent(statusData)))
+	for range 2 {
+		send(t, reader, attestation())
+		lane, _ := read(t, in)
+		assert.Equal(t, "events", lane)
+	}
+	requireShed(t, send(t, reader, attestation()))
+
+	// Critical events still get through.
+	send(t, reader, connectionEvent(criticalData))
+	lane, _ = read(t, in)
+	assert.Equal(t, "critical", lane)
+}
+
+func TestReadOrder(t *testing.T) {
+	in, reader := newTestInput(t, testConfig)
+
+	// The pipeline is busy while requests of every lane arrive.
+	send(t, reader, connectionEvent(statusData))
+	send(t, reader, connectionEvent(eventsData))
+	send(t, reader, connectionEvent(criticalData))
+	require.Eventually(t, func() bool {
+		in.lanes.mu.Lock()
+		defer in.lanes.mu.Unlock()
+		for _, l := range in.lanes.lanes {
+			if l.inFlight != 1 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, time.Millisecond)
+
+	for _, expected := range []string{"critical", "events", "bulk"} {
+		lane, _ := read(t, in)
+		assert.Equal(t, expected, lane)
+	}
+}
+
+func TestChartDefaultsShedStatusFirst(t *testing.T) {
+	in, reader := newTestInput(t, chartLaneConfig(t))
+	bulk := in.lanes.lanes[len(in.lanes.lanes)-1]
+	events := in.lanes.lanes[1]
+	require.Less(t, bulk.maxInFlight, int(in.lanes.shedLowerAt*float64(events.maxInFlight)),
+		"bulk must fill before events put it under pressure")
+
+	// The outputs stall, so nothing read is acknowledged.
+	for range bulk.maxInFlight {
+		send(t, reader, connectionEvent(statusData))
+		lane, _ := read(t, in)
+		require.Equal(t, "bulk", lane)
+	}
+	requireShed(t, send(t, reader, connectionEvent(statusData)))
+
+	// Events and attestations are still admitted while status is shed.
+	send(t, reader, connectionEvent(eventsData))
+	lane, _ := read(t, in)
+	assert.Equal(t, "events", lane)
+	send(t, reader, attestation())
+	lane, _ = read(t, in)
+	assert.Equal(t, "events", lane)
+	requireShed(t, send(t, reader, connectionEvent(statusData)))
+}
+
+func TestCloseRejectsQueued(t *testing.T) {
+	in, reader := newTestInput(t, testConfig)
+	req := send(t, reader, connectionEvent(statusData))
+	require.Eventually(t, func() bool { return in.queued() }, 5*time.Second, time.Millisecond)
+
+	require.NoError(t, in.Close(context.Background()))
+	require.ErrorIs(t, <-req.acked, service.ErrNotConnected)
+	_, _, err := in.ReadBatch(context.Background())
+	require.ErrorIs(t, err, service.ErrEndOfInput)
+}
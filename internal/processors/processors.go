@@ -16,7 +16,11 @@ const (
 	defaultSkew       = time.Minute * 5
 )
 
-var allowableTimeSkew = getSkew()
+var (
+	allowableTimeSkew = getSkew()
+	// allowableTimeAge is zero when timestamps are not bounded in the past.
+	allowableTimeAge = getDuration("ALLOWABLE_TIME_AGE", 0)
+)
 
 // SetError sets an error on a message.
 func SetError(msg *service.Message, componentName, errorMsg string, err error) {
@@ -41,19 +45,18 @@ func MsgToEvent(msg *service.Message) (*cloudevent.RawEvent, error) {
 	return rawEvent, nil
 }
 
-// IsFutureTimestamp checks if a timestamp is in the future past the allowable time skew.
-func IsFutureTimestamp(ts time.Time) bool {
-	return ts.After(time.Now().Add(allowableTimeSkew))
+func getSkew() time.Duration {
+	return getDuration("ALLOWABLE_TIME_SKEW", defaultSkew)
 }
 
-func getSkew() time.Duration {
-	skew := os.Getenv("ALLOWABLE_TIME_SKEW")
-	if skew == "" {
-		return defaultSkew
+func getDuration(name string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return defaultValue
 	}
-	dur, err := time.ParseDuration(skew)
+	dur, err := time.ParseDuration(value)
 	if err != nil {
-		return defaultSkew
+		return defaultValue
 	}
 	return dur
 }
@@ -0,0 +1,129 @@
+package signalconvert
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// seenSignal identifies a signal of a subject.
+type seenSignal struct {
+	name      string
+	timestamp int64
+}
+
+func seenSignalOf(signal vss.Signal) seenSignal {
+	return seenSignal{name: signal.Data.Name, timestamp: signal.Data.Timestamp.UnixNano()}
+}
+
+// seenSignals are the signals of a subject within the dedupe window, in the
+// order they were remembered.
+type seenSignals struct {
+	newest int64
+	set    map[seenSignal]struct{}
+	order  []seenSignal
+}
+
+// signalDeduper removes signals that were already written in an earlier
+// report of the same subject, such as those of a report retransmitted after a
+// failed response. Signals are only remembered once their report is written,
+// so a report whose write failed is accepted again when it is retransmitted.
+// It only remembers signals whose timestamp is within the window of the
+// newest signal of their subject, and at most maxSignals per subject. It is
+// safe for concurrent use.
+type signalDeduper struct {
+	window     time.Duration
+	maxSignals int
+
+	mu       sync.Mutex
+	subjects *lru.Cache[string, *seenSignals]
+}
+
+func newSignalDeduper(subjects, maxSignals int, window time.Duration) (*signalDeduper, error) {
+	cache, err := lru.New[string, *seenSignals](subjects)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dedupe cache: %w", err)
+	}
+	return &signalDeduper{window: window, maxSignals: maxSignals, subjects: cache}, nil
+}
+
+// dedupe removes the signals of a subject that were already remembered. It
+// returns the kept signals and the number removed.
+func (d *signalDeduper) dedupe(subject string, signals []vss.Signal) ([]vss.Signal, int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen, ok := d.subjects.Get(subject)
+	if !ok {
+		return signals, 0
+	}
+	kept := signals[:0]
+	for _, signal := range signals {
+		if _, ok := seen.set[seenSignalOf(signal)]; ok {
+			continue
+		}
+		kept = append(kept, signal)
+	}
+	return kept, len(signals) - len(kept)
+}
+
+// pending returns the signals of a report to remember once it is written.
+func (d *signalDeduper) pending(subject string, signals []vss.Signal) *pendingSignals {
+	keys := make([]seenSignal, 0, len(signals))
+	for _, signal := range signals {
+		keys = append(keys, seenSignalOf(signal))
+	}
+	return &pendingSignals{deduper: d, subject: subject, keys: keys}
+}
+
+// remember marks signals of a subject as seen.
+func (d *signalDeduper) remember(subject string, keys []seenSignal) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	seen, ok := d.subjects.Get(subject)
+	if !ok {
+		seen = &seenSignals{set: map[seenSignal]struct{}{}}
+		d.subjects.Add(subject, seen)
+	}
+	for _, key := range keys {
+		// Reports written concurrently may share signals.
+		if _, ok := seen.set[key]; ok {
+			continue
+		}
+		seen.newest = max(seen.newest, key.timestamp)
+		if key.timestamp >= seen.newest-int64(d.window) {
+			seen.set[key] = struct{}{}
+			seen.order = append(seen.order, key)
+		}
+	}
+	seen.evict(d.maxSignals, seen.newest-int64(d.window))
+}
+
+// evict forgets the oldest signals until at most maxSignals remain, and the
+// first remaining signal is not before the cutoff.
+func (s *seenSignals) evict(maxSignals int, cutoff int64) {
+	n := 0
+	for n < len(s.order) && (len(s.order)-n > maxSignals || s.order[n].timestamp < cutoff) {
+		delete(s.set, s.order[n])
+		n++
+	}
+	if n > 0 {
+		s.order = append(s.order[:0], s.order[n:]...)
+	}
+}
+
+// pendingSignals are the signals of a converted report. They are carried in
+// the metadata of the report and remembered by dimo_signal_dedupe_output once
+// the report is written.
+type pendingSignals struct {
+	deduper *signalDeduper
+	subject string
+	keys    []seenSignal
+}
+
+// remember marks the signals as seen.
+func (p *pendingSignals) remember() {
+	p.deduper.remember(p.subject, p.keys)
+}
@@ -0,0 +1,138 @@
+package signalconvert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/redpanda-data/benthos/v4/public/components/pure"
+)
+
+func TestSignalDeduper(t *testing.T) {
+	const subject = "did:erc721:1:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:1"
+	const otherSubject = "did:erc721:1:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:2"
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	signal := func(name string, offset time.Duration) vss.Signal {
+		return vss.Signal{Data: vss.SignalData{Name: name, Timestamp: ts.Add(offset), ValueNumber: 1}}
+	}
+
+	type report struct {
+		subject string
+		signals []vss.Signal
+		// writeFails leaves the kept signals of the report unwritten.
+		writeFails         bool
+		expectedSignals    []vss.Signal
+		expectedDuplicates int
+	}
+	tests := []struct {
+		name       string
+		maxSignals int
+		reports    []report
+	}{
+		{
+			name:       "retransmitted report",
+			maxSignals: 10,
+			reports: []report{
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldExteriorAirTemperature, 0)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldExteriorAirTemperature, 0)}},
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldExteriorAirTemperature, 0)}, expectedSignals: []vss.Signal{}, expectedDuplicates: 2},
+			},
+		},
+		{
+			name:       "retransmitted after failed write",
+			maxSignals: 10,
+			reports: []report{
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldExteriorAirTemperature, 0)}, writeFails: true, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldExteriorAirTemperature, 0)}},
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldExteriorAirTemperature, 0)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldExteriorAirTemperature, 0)}},
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldExteriorAirTemperature, 0)}, expectedSignals: []vss.Signal{}, expectedDuplicates: 2},
+			},
+		},
+		{
+			name:       "overlapping report",
+			maxSignals: 10,
+			reports: []report{
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0)}},
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldSpeed, time.Second)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, time.Second)}, expectedDuplicates: 1},
+			},
+		},
+		{
+			name:       "subjects are separate",
+			maxSignals: 10,
+			reports: []report{
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0)}},
+				{subject: otherSubject, signals: []vss.Signal{signal(vss.FieldSpeed, 0)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0)}},
+			},
+		},
+		{
+			name:       "signals outside window forgotten",
+			maxSignals: 10,
+			reports: []report{
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0)}},
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 2*time.Minute)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 2*time.Minute)}},
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0)}},
+			},
+		},
+		{
+			name:       "oldest signals forgotten",
+			maxSignals: 2,
+			reports: []report{
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldSpeed, time.Second), signal(vss.FieldSpeed, 2*time.Second)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldSpeed, time.Second), signal(vss.FieldSpeed, 2*time.Second)}},
+				{subject: subject, signals: []vss.Signal{signal(vss.FieldSpeed, 0), signal(vss.FieldSpeed, 2*time.Second)}, expectedSignals: []vss.Signal{signal(vss.FieldSpeed, 0)}, expectedDuplicates: 1},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deduper, err := newSignalDeduper(10, tt.maxSignals, time.Minute)
+			require.NoError(t, err)
+			for i, r := range tt.reports {
+				kept, duplicates := deduper.dedupe(r.subject, r.signals)
+				assert.Equal(t, r.expectedSignals, kept, "report %d", i)
+				assert.Equal(t, r.expectedDuplicates, duplicates, "report %d", i)
+				if !r.writeFails {
+					deduper.pending(r.subject, kept).remember()
+				}
+			}
+		})
+	}
+}
+
+func TestDedupeOutput(t *testing.T) {
+	const subject = "did:erc721:1:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:1"
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	signals := func() []vss.Signal {
+		return []vss.Signal{{Data: vss.SignalData{Name: vss.FieldSpeed, Timestamp: ts, ValueNumber: 1}}}
+	}
+	deduper, err := newSignalDeduper(10, 10, time.Minute)
+	require.NoError(t, err)
+	newOutput := func(child string) service.BatchOutput {
+		conf, err := outputConfigSpec.ParseYAML("output:\n  "+child+"\n", nil)
+		require.NoError(t, err)
+		out, _, _, err := outputCtor(conf, service.MockResources())
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = out.Close(context.Background()) })
+		return out
+	}
+	write := func(out service.BatchOutput) error {
+		kept, _ := deduper.dedupe(subject, signals())
+		msg := service.NewMessage(nil)
+		msg.MetaSetMut(pendingSignalsKey, deduper.pending(subject, kept))
+		return out.WriteBatch(context.Background(), service.MessageBatch{msg})
+	}
+
+	// The write fails, so the device's retry is still accepted.
+	require.Error(t, write(newOutput(`reject: "clickhouse unavailable"`)))
+	kept, duplicates := deduper.dedupe(subject, signals())
+	assert.Len(t, kept, 1)
+	assert.Zero(t, duplicates)
+
+	// Once the retry is written, further retransmissions are removed.
+	require.NoError(t, write(newOutput("drop: {}")))
+	kept, duplicates = deduper.dedupe(subject, signals())
+	assert.Empty(t, kept)
+	assert.Equal(t, 1, duplicates)
+}
@@ -10,11 +10,9 @@ import (
 	"github.com/DIMO-Network/model-garage/pkg/vss"
 )
 
-// maxLatLongDur is the amount of time we'll wait before starting
-// a new coordinate triple.
-//
-// TODO(elffjs): Should this be a setting?
-const maxLatLongDur = 500 * time.Millisecond
+// defaultLocationWindow is the default amount of time we'll wait
+// before starting a new coordinate triple.
+const defaultLocationWindow = 500 * time.Millisecond
 
 // zeroTime is used to reset the timestamp on the coordinate store.
 var zeroTime time.Time
@@ -27,6 +25,20 @@ const (
 	fieldDIMOAftermarketHDOP      = "dimoAftermarketHDOP"
 )
 
+// Companion signals repeat the altitude and speed closest to a created
+// location at the timestamp of the location, so that the whole fix can be
+// queried at one timestamp while the samples keep their own.
+const (
+	fieldCurrentLocationFixAltitude = "currentLocationFixAltitude"
+	fieldCurrentLocationFixSpeed    = "currentLocationFixSpeed"
+)
+
+// fixFields maps the samples that get a companion signal to its name.
+var fixFields = map[string]string{
+	vss.FieldCurrentLocationAltitude: fieldCurrentLocationFixAltitude,
+	vss.FieldSpeed:                   fieldCurrentLocationFixSpeed,
+}
+
 // handleCoordinates transforms a slice of input signals in ways that
 // simplify downstream processing. Currently this means:
 //
@@ -38,25 +50,39 @@ const (
 //     close timestamps, we will also emit a location-values signal
 //     named currentLocationCoordinates which combines all three.
 //   - Remove unpaired latitudes and longitudes.
+//   - Fold the currentLocationHeading closest to each created
+//     location, and within the window of it, into the location.
+//   - Repeat the currentLocationAltitude and speed closest to each
+//     created location, and within the window of it, as
+//     currentLocationFixAltitude and currentLocationFixSpeed at the
+//     timestamp of the location so that they describe the same fix.
+//
+// The window is the amount of time we'll wait before starting a new
+// coordinate triple.
 //
 // The returned slice of signals is always meaningful, even if an error
 // is also returned.
 //
 // Note that this function may reorder the input slice.
-func handleCoordinates(signals []vss.Signal) ([]vss.Signal, error) {
-	return newCoordinateStore(signals).processSignals()
+func handleCoordinates(signals []vss.Signal, window time.Duration) ([]vss.Signal, error) {
+	return newCoordinateStore(signals, window).processSignals()
 }
 
-func newCoordinateStore(signals []vss.Signal) *coordinateStore {
+func newCoordinateStore(signals []vss.Signal, window time.Duration) *coordinateStore {
 	return &coordinateStore{
+		window:   window,
 		signals:  signals,
 		lastLat:  -1,
 		lastLon:  -1,
 		lastHDOP: -1,
+		claimed:  map[int]struct{}{},
 	}
 }
 
 type coordinateStore struct {
+	// window is the amount of time we'll wait before starting a new
+	// coordinate triple.
+	window time.Duration
 	// lastLat is the index of the signals slice holding latitude for
 	// the location triple under construction. If there is no latitude
 	// yet found for the triple then the value of lastLat is -1.
@@ -70,8 +96,11 @@ type coordinateStore struct {
 	// will be the zero value of time.Time.
 	lastTime time.Time
 
-	// signals is the input slice of signals.
+	// signals is the input slice of signals, sorted by timestamp.
 	signals []vss.Signal
+	// claimed holds the indexes of altitudes and speeds that already
+	// have a companion signal of a created location.
+	claimed map[int]struct{}
 
 	// created holds location signals that we've constructed while
 	// iterating over signals.
@@ -119,7 +148,7 @@ func (c *coordinateStore) processSignals() ([]vss.Signal, error) {
 func (c *coordinateStore) processSignal(index int) {
 	sig := c.signals[index]
 
-	if !c.lastTime.IsZero() && sig.Data.Timestamp.Sub(c.lastTime) >= maxLatLongDur {
+	if !c.lastTime.IsZero() && sig.Data.Timestamp.Sub(c.lastTime) >= c.window {
 		c.tryCreateLocation()
 	}
 
@@ -195,6 +224,10 @@ func (c *coordinateStore) tryCreateLocation() {
 	}
 
 	if create {
+		var companions []vss.Signal
+		if loc.Latitude != 0 || loc.Longitude != 0 {
+			companions = c.attachSamples(&loc)
+		}
 		c.created = append(c.created, vss.Signal{
 			CloudEventHeader: template.CloudEventHeader,
 			Data: vss.SignalData{
@@ -204,6 +237,7 @@ func (c *coordinateStore) tryCreateLocation() {
 				CloudEventID:  template.Data.CloudEventID,
 			},
 		})
+		c.created = append(c.created, companions...)
 	}
 
 	c.lastLat = -1
@@ -211,3 +245,56 @@ func (c *coordinateStore) tryCreateLocation() {
 	c.lastHDOP = -1
 	c.lastTime = zeroTime
 }
+
+// attachSamples folds the heading closest to the active triple into
+// loc, and returns companion signals of the closest altitude and speed.
+// Only samples less than the window away from the triple are considered.
+func (c *coordinateStore) attachSamples(loc *vss.Location) []vss.Signal {
+	start, _ := slices.BinarySearchFunc(c.signals, c.lastTime.Add(-c.window), func(sig vss.Signal, t time.Time) int {
+		if sig.Data.Timestamp.After(t) {
+			return 1
+		}
+		return -1
+	})
+
+	closest := map[string]int{}
+	for i := start; i < len(c.signals) && c.signals[i].Data.Timestamp.Before(c.lastTime.Add(c.window)); i++ {
+		name := c.signals[i].Data.Name
+		if name != vss.FieldCurrentLocationHeading && name != vss.FieldCurrentLocationAltitude && name != vss.FieldSpeed {
+			continue
+		}
+		if _, ok := c.claimed[i]; ok {
+			continue
+		}
+		if prev, ok := closest[name]; !ok || c.distance(i) < c.distance(prev) {
+			closest[name] = i
+		}
+	}
+
+	if i, ok := closest[vss.FieldCurrentLocationHeading]; ok {
+		loc.Heading = c.signals[i].Data.ValueNumber
+	}
+	var companions []vss.Signal
+	for _, name := range []string{vss.FieldCurrentLocationAltitude, vss.FieldSpeed} {
+		i, ok := closest[name]
+		if !ok {
+			continue
+		}
+		c.claimed[i] = struct{}{}
+		companion := c.signals[i]
+		companion.Data.Name = fixFields[name]
+		companion.Data.Timestamp = c.lastTime
+		companions = append(companions, companion)
+	}
+	return companions
+}
+
+// distance returns the absolute time between a signal and the active
+// triple.
+func (c *coordinateStore) distance(index int) time.Duration {
+	d := c.signals[index].Data.Timestamp.Sub(c.lastTime)
+	if d < 0 {
+		return -d
+	}
+	return d
+}
@@ -0,0 +1,80 @@
+package signalconvert
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	outputName                 = "dimo_signal_dedupe_output"
+	outputFieldName            = "output"
+	outputMaxInFlightFieldName = "max_in_flight"
+)
+
+var outputConfigSpec = service.NewConfigSpec().
+	Summary("Writes to a child output and remembers the signals of every written report for the dedupe_window of dimo_signal_convert. Signals of a report whose write failed are not remembered, so they are accepted again when the report is retransmitted.").
+	Field(service.NewOutputField(outputFieldName).Description("Output to write to.")).
+	Field(service.NewIntField(outputMaxInFlightFieldName).Default(64).Description("Maximum number of batches written concurrently."))
+
+func init() {
+	err := service.RegisterBatchOutput(outputName, outputConfigSpec, outputCtor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+type dedupeOutput struct {
+	child *service.OwnedOutput
+}
+
+func outputCtor(cfg *service.ParsedConfig, _ *service.Resources) (service.BatchOutput, service.BatchPolicy, int, error) {
+	child, err := cfg.FieldOutput(outputFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", outputFieldName, err)
+	}
+	maxInFlight, err := cfg.FieldInt(outputMaxInFlightFieldName)
+	if err != nil {
+		return nil, service.BatchPolicy{}, 0, fmt.Errorf("failed to get %s: %w", outputMaxInFlightFieldName, err)
+	}
+	return &dedupeOutput{child: child}, service.BatchPolicy{}, maxInFlight, nil
+}
+
+// Connect to fulfill the service.BatchOutput interface. The child output
+// connects when it is first written to.
+func (*dedupeOutput) Connect(context.Context) error {
+	return nil
+}
+
+// WriteBatch writes the batch to the child output and then remembers the
+// signals of its reports. The pending signals are not passed on, so they never
+// reach the metadata of the written messages.
+func (o *dedupeOutput) WriteBatch(ctx context.Context, msgs service.MessageBatch) error {
+	var pending []*pendingSignals
+	out := make(service.MessageBatch, len(msgs))
+	for i, msg := range msgs {
+		out[i] = msg
+		value, ok := msg.MetaGetMut(pendingSignalsKey)
+		if !ok {
+			continue
+		}
+		if signals, ok := value.(*pendingSignals); ok {
+			pending = append(pending, signals)
+		}
+		out[i] = msg.Copy()
+		out[i].MetaDelete(pendingSignalsKey)
+	}
+	if err := o.child.WriteBatch(ctx, out); err != nil {
+		return err
+	}
+	for _, signals := range pending {
+		signals.remember()
+	}
+	return nil
+}
+
+// Close to fulfill the service.BatchOutput interface.
+func (o *dedupeOutput) Close(ctx context.Context) error {
+	return o.child.Close(ctx)
+}
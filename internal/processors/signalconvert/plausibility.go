@@ -0,0 +1,175 @@
+package signalconvert
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+const (
+	// earthRadiusKm is the mean radius of the earth.
+	earthRadiusKm = 6371.0
+	// gpsNoiseKm is the distance between fixes that is always plausible,
+	// however little time passed between them.
+	gpsNoiseKm = 0.1
+	// maxConsecutiveRejections is the number of fixes rejected in a row after
+	// which the next fix is accepted, so that a subject whose last accepted
+	// fix was a glitch does not have all later fixes rejected.
+	maxConsecutiveRejections = 3
+	// locationLockStripes is the number of locks the subjects of a
+	// locationFilter are spread over.
+	locationLockStripes = 64
+)
+
+// Reasons locations are rejected, used as labels of MetricSignalsRejected.
+const (
+	rejectOutOfRange = "out_of_range"
+	rejectHDOP       = "hdop"
+	rejectTeleport   = "teleport"
+)
+
+var errImplausibleLocation = errors.New("implausible location")
+
+// locationFix is the last accepted location of a subject.
+type locationFix struct {
+	latitude, longitude float64
+	time                time.Time
+	// rejections is the number of fixes rejected since this one was accepted.
+	rejections int
+}
+
+// locationFilter rejects location signals with coordinates out of range, a
+// high HDOP, or a distance to the last accepted location of their subject
+// that implies an impossible speed. It is safe for concurrent use.
+type locationFilter struct {
+	// maxSpeed is the highest plausible speed between fixes in km/h.
+	maxSpeed float64
+	// maxHDOP is zero when HDOP is not limited.
+	maxHDOP float64
+	// flag keeps implausible locations and only reports the error.
+	flag bool
+	// rejected is called with the reason and signal name of every implausible
+	// location. It is called concurrently for different subjects.
+	rejected func(reason, name string)
+
+	// locks serialize the checks of a subject against its last fix. Subjects
+	// are spread over them by hash, so that different subjects rarely wait
+	// for each other.
+	locks [locationLockStripes]sync.Mutex
+	fixes *lru.Cache[string, locationFix]
+}
+
+func newLocationFilter(subjects int, maxSpeed, maxHDOP float64, flag bool, rejected func(reason, name string)) (*locationFilter, error) {
+	fixes, err := lru.New[string, locationFix](subjects)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create location cache: %w", err)
+	}
+	return &locationFilter{maxSpeed: maxSpeed, maxHDOP: maxHDOP, flag: flag, rejected: rejected, fixes: fixes}, nil
+}
+
+// filter checks the location signals of a subject in timestamp order and
+// removes implausible ones. The returned slice of signals is always
+// meaningful, even if an error is also returned.
+func (f *locationFilter) filter(subject string, signals []vss.Signal) ([]vss.Signal, error) {
+	var locations []int
+	for i := range signals {
+		if signals[i].Data.Name == vss.FieldCurrentLocationCoordinates {
+			locations = append(locations, i)
+		}
+	}
+	if len(locations) == 0 {
+		return signals, nil
+	}
+	slices.SortStableFunc(locations, func(a, b int) int {
+		return signals[a].Data.Timestamp.Compare(signals[b].Data.Timestamp)
+	})
+
+	mu := f.lock(subject)
+	mu.Lock()
+	defer mu.Unlock()
+	var errs error
+	last, hasLast := f.fixes.Get(subject)
+	for _, i := range locations {
+		data := signals[i].Data
+		reason := f.check(data, last, hasLast)
+		if reason == "" {
+			if hasCoordinates(data.ValueLocation) && (!hasLast || !data.Timestamp.Before(last.time)) {
+				last = locationFix{latitude: data.ValueLocation.Latitude, longitude: data.ValueLocation.Longitude, time: data.Timestamp}
+				hasLast = true
+			}
+			continue
+		}
+		f.rejected(reason, data.Name)
+		errs = errors.Join(errs, fmt.Errorf("%w, %s at time %s: latitude %g, longitude %g, hdop %g",
+			errImplausibleLocation, reason, data.Timestamp, data.ValueLocation.Latitude, data.ValueLocation.Longitude, data.ValueLocation.HDOP))
+		if reason == rejectTeleport {
+			last.rejections++
+		}
+		if !f.flag {
+			signals[i].Data.Name = pruneSignalName
+		}
+	}
+	if hasLast {
+		f.fixes.Add(subject, last)
+	}
+
+	kept := signals[:0]
+	for _, signal := range signals {
+		if signal.Data.Name != pruneSignalName {
+			kept = append(kept, signal)
+		}
+	}
+	return kept, errs
+}
+
+// lock returns the lock of a subject.
+func (f *locationFilter) lock(subject string) *sync.Mutex {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(subject))
+	return &f.locks[hash.Sum32()%locationLockStripes]
+}
+
+// check returns the reason a location is implausible, or an empty string if
+// it is plausible.
+func (f *locationFilter) check(data vss.SignalData, last locationFix, hasLast bool) string {
+	loc := data.ValueLocation
+	if math.Abs(loc.Latitude) > 90 || math.Abs(loc.Longitude) > 180 || math.IsNaN(loc.Latitude) || math.IsNaN(loc.Longitude) {
+		return rejectOutOfRange
+	}
+	if f.maxHDOP > 0 && loc.HDOP > f.maxHDOP {
+		return rejectHDOP
+	}
+	if !hasCoordinates(loc) || !hasLast || last.rejections >= maxConsecutiveRejections {
+		return ""
+	}
+	distance := haversineKm(last.latitude, last.longitude, loc.Latitude, loc.Longitude) - gpsNoiseKm
+	if distance <= 0 {
+		return ""
+	}
+	hours := math.Abs(data.Timestamp.Sub(last.time).Hours())
+	if hours == 0 || distance/hours > f.maxSpeed {
+		return rejectTeleport
+	}
+	return ""
+}
+
+// hasCoordinates reports whether a location has coordinates, as opposed to
+// only an HDOP.
+func hasCoordinates(loc vss.Location) bool {
+	return loc.Latitude != 0 || loc.Longitude != 0
+}
+
+// haversineKm returns the great-circle distance between two coordinates.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := toRad(lat2-lat1), toRad(lon2-lon1)
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,146 @@
+package signalconvert
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocationFilter(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	location := func(offset time.Duration, lat, lon, hdop float64) vss.Signal {
+		return vss.Signal{Data: vss.SignalData{
+			Name:          vss.FieldCurrentLocationCoordinates,
+			Timestamp:     start.Add(offset),
+			ValueLocation: vss.Location{Latitude: lat, Longitude: lon, HDOP: hdop},
+		}}
+	}
+	// Berlin and New York are about 6400 km apart.
+	berlin := func(offset time.Duration) vss.Signal { return location(offset, 52.52, 13.405, 1) }
+	berlinNearby := func(offset time.Duration) vss.Signal { return location(offset, 52.53, 13.41, 1) }
+	newYork := func(offset time.Duration) vss.Signal { return location(offset, 40.71, -74.006, 1) }
+	speed := vss.Signal{Data: vss.SignalData{Name: vss.FieldSpeed, Timestamp: start, ValueNumber: 50}}
+
+	tests := []struct {
+		name             string
+		flag             bool
+		reports          [][]vss.Signal
+		expectedSignals  []vss.Signal
+		expectedRejected []string
+	}{
+		{
+			name:            "plausible movement",
+			reports:         [][]vss.Signal{{berlin(0)}, {berlinNearby(time.Minute), speed}},
+			expectedSignals: []vss.Signal{berlinNearby(time.Minute), speed},
+		},
+		{
+			name:             "teleport across reports",
+			reports:          [][]vss.Signal{{berlin(0)}, {newYork(time.Minute), speed}},
+			expectedSignals:  []vss.Signal{speed},
+			expectedRejected: []string{"teleport:currentLocationCoordinates"},
+		},
+		{
+			name:             "teleport within report out of order",
+			reports:          [][]vss.Signal{{berlinNearby(2 * time.Minute), newYork(time.Minute), berlin(0)}},
+			expectedSignals:  []vss.Signal{berlinNearby(2 * time.Minute), berlin(0)},
+			expectedRejected: []string{"teleport:currentLocationCoordinates"},
+		},
+		{
+			name:            "flight",
+			reports:         [][]vss.Signal{{berlin(0)}, {newYork(15 * time.Hour)}},
+			expectedSignals: []vss.Signal{newYork(15 * time.Hour)},
+		},
+		{
+			name:             "flagged teleport",
+			flag:             true,
+			reports:          [][]vss.Signal{{berlin(0)}, {newYork(time.Minute)}},
+			expectedSignals:  []vss.Signal{newYork(time.Minute)},
+			expectedRejected: []string{"teleport:currentLocationCoordinates"},
+		},
+		{
+			name:            "glitched reference is replaced",
+			reports:         [][]vss.Signal{{newYork(0)}, {berlin(time.Minute)}, {berlin(2 * time.Minute)}, {berlin(3 * time.Minute)}, {berlinNearby(4 * time.Minute)}},
+			expectedSignals: []vss.Signal{berlinNearby(4 * time.Minute)},
+			expectedRejected: []string{
+				"teleport:currentLocationCoordinates",
+				"teleport:currentLocationCoordinates",
+				"teleport:currentLocationCoordinates",
+			},
+		},
+		{
+			name:             "out of range and hdop",
+			reports:          [][]vss.Signal{{location(0, 95, 13, 1), location(time.Second, 52, 190, 1), location(2*time.Second, 52, 13, 30)}},
+			expectedSignals:  []vss.Signal{},
+			expectedRejected: []string{"out_of_range:currentLocationCoordinates", "out_of_range:currentLocationCoordinates", "hdop:currentLocationCoordinates"},
+		},
+		{
+			name:            "hdop only",
+			reports:         [][]vss.Signal{{berlin(0)}, {location(time.Minute, 0, 0, 1)}},
+			expectedSignals: []vss.Signal{location(time.Minute, 0, 0, 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rejected []string
+			filter, err := newLocationFilter(10, 500, 20, tt.flag, func(reason, name string) { rejected = append(rejected, reason+":"+name) })
+			require.NoError(t, err)
+			var result []vss.Signal
+			var errs error
+			for _, report := range tt.reports {
+				result, err = filter.filter("did:erc721:1:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:1", report)
+				errs = errors.Join(errs, err)
+			}
+			if tt.expectedRejected != nil {
+				require.ErrorIs(t, errs, errImplausibleLocation)
+			} else {
+				require.NoError(t, errs)
+			}
+			assert.Equal(t, tt.expectedSignals, result)
+			assert.Equal(t, tt.expectedRejected, rejected)
+		})
+	}
+}
+
+func TestLocationFilterConcurrentSubjects(t *testing.T) {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	var rejected atomic.Int32
+	filter, err := newLocationFilter(100, 500, 20, false, func(string, string) { rejected.Add(1) })
+	require.NoError(t, err)
+	subject := func(i int) string {
+		return fmt.Sprintf("did:erc721:1:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:%d", i)
+	}
+
+	// Every subject drives north at about 65 km/h, from several goroutines at once.
+	var wg sync.WaitGroup
+	for i := range 20 {
+		for half := range 2 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for minute := half; minute < 50; minute += 2 {
+					_, err := filter.filter(subject(i), []vss.Signal{{Data: vss.SignalData{
+						Name:          vss.FieldCurrentLocationCoordinates,
+						Timestamp:     start.Add(time.Duration(minute) * time.Minute),
+						ValueLocation: vss.Location{Latitude: 52 + float64(minute)*0.01, Longitude: 13.4, HDOP: 1},
+					}}})
+					assert.NoError(t, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+
+	assert.Zero(t, rejected.Load())
+	for i := range 20 {
+		fix, ok := filter.fixes.Get(subject(i))
+		require.True(t, ok)
+		assert.Equal(t, start.Add(49*time.Minute), fix.time)
+	}
+}
@@ -4,20 +4,77 @@ import (
 	"fmt"
 
 	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/model-garage/pkg/vss"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/redpanda-data/benthos/v4/public/service"
 )
 
 const (
-	processorName           = "dimo_signal_convert"
-	vehicleAddressFieldName = "vehicle_nft_address"
-	chainIDFieldName        = "chain_id"
+	processorName             = "dimo_signal_convert"
+	vehicleAddressFieldName   = "vehicle_nft_address"
+	chainIDFieldName          = "chain_id"
+	vehicleContractsFieldName = "vehicle_contracts"
+	contractAddressFieldName  = "address"
+	valuePolicyFieldName      = "signal_value_policy"
+	valuePoliciesFieldName    = "signal_value_policies"
+	signalRangesFieldName     = "signal_ranges"
+	rangeNameFieldName        = "name"
+	rangeMinFieldName         = "min"
+	rangeMaxFieldName         = "max"
+	locationFilterFieldName   = "location_filter"
+	locationMaxSpeedFieldName = "location_filter_max_speed"
+	locationMaxHDOPFieldName  = "location_filter_max_hdop"
+	locationActionFieldName   = "location_filter_action"
+	locationSubjectsFieldName = "location_filter_subjects"
+	locationWindowFieldName   = "location_window"
+	dedupeWindowFieldName     = "dedupe_window"
+	dedupeSignalsFieldName    = "dedupe_max_signals"
+	dedupeSubjectsFieldName   = "dedupe_subjects"
+
+	// MetricSignalsSkipped counts status events whose signals are not
+	// extracted, labelled by source and reason.
+	MetricSignalsSkipped = "dis_signals_skipped_total"
+	// MetricSignalsRejected counts signal values outside of their definition,
+	// labelled by reason and signal name.
+	MetricSignalsRejected = "dis_signals_rejected_total"
+	// MetricSignalsDuplicate counts signals removed because an earlier report
+	// of their subject already contained them, labelled by source.
+	MetricSignalsDuplicate = "dis_signals_duplicate_total"
 )
 
 var configSpec = service.NewConfigSpec().
 	Summary("Converts a cloud event into a list of signals").
-	Field(service.NewIntField(chainIDFieldName).Description("Chain Id for the Ethereum network")).
-	Field(service.NewStringField(vehicleAddressFieldName).Description("Ethereum address for the vehicles contract"))
+	Field(service.NewIntField(chainIDFieldName).Optional().Description("Chain Id for the Ethereum network")).
+	Field(service.NewStringField(vehicleAddressFieldName).Optional().Description("Ethereum address for the vehicles contract")).
+	Field(service.NewObjectListField(vehicleContractsFieldName,
+		service.NewIntField(chainIDFieldName).Description("Chain Id of the contract."),
+		service.NewStringField(contractAddressFieldName).Description("Ethereum address of the vehicles contract."),
+	).Default([]any{}).Description("Additional vehicle contracts whose status events signals are extracted from, next to the one of chain_id and vehicle_nft_address.")).
+	Field(service.NewStringEnumField(valuePolicyFieldName, string(valuePolicyDrop), string(valuePolicyClamp), string(valuePolicyFlag)).Default(string(valuePolicyDrop)).Description("What happens to signals whose value is outside of the min, max or allowed values of their VSS definition. Errors are reported for every policy.")).
+	Field(service.NewStringMapField(valuePoliciesFieldName).Default(map[string]any{}).Description("Value policies of individual signals, keyed by signal name.")).
+	Field(service.NewObjectListField(signalRangesFieldName,
+		service.NewStringField(rangeNameFieldName).Description("Name of the signal."),
+		service.NewFloatField(rangeMinFieldName).Optional().Description("Smallest valid value. Keeps the VSS definition when unset."),
+		service.NewFloatField(rangeMaxFieldName).Optional().Description("Largest valid value. Keeps the VSS definition when unset."),
+	).Default([]any{
+		map[string]any{rangeNameFieldName: vss.FieldSpeed, rangeMinFieldName: 0, rangeMaxFieldName: 500},
+		map[string]any{rangeNameFieldName: vss.FieldPowertrainTransmissionTravelledDistance, rangeMinFieldName: 0},
+	}).Description("Ranges of signals that replace or complete the min and max of their VSS definition.")).
+	Field(service.NewDurationField(locationWindowFieldName).Default(defaultLocationWindow.String()).Description("Maximum time between the latitude, longitude and HDOP that are combined into one location. Headings within this time of a location are folded into it, and altitudes and speeds are repeated at its timestamp as companion signals.")).
+	Field(service.NewBoolField(locationFilterFieldName).Default(false).Description("Reject locations with coordinates out of range, an HDOP above location_filter_max_hdop, or a distance to the last accepted location of their subject that implies a speed above location_filter_max_speed.")).
+	Field(service.NewFloatField(locationMaxSpeedFieldName).Default(500).Description("Highest plausible speed between two locations of a subject in km/h.")).
+	Field(service.NewFloatField(locationMaxHDOPFieldName).Default(0).Description("Highest accepted HDOP of a location. Zero accepts every HDOP.")).
+	Field(service.NewStringEnumField(locationActionFieldName, "reject", "flag").Default("reject").Description("Whether implausible locations are removed or kept. Errors are reported for both.")).
+	Field(service.NewIntField(locationSubjectsFieldName).Default(100000).Description("Maximum number of subjects whose last location is kept at once.")).
+	Field(service.NewDurationField(dedupeWindowFieldName).Default("0s").Description("Remove signals whose name and timestamp were already written in an earlier report of the same subject, if they are within this time of the newest signal of the subject. Signals are remembered by dimo_signal_dedupe_output once their report is written. Zero disables removing duplicates across reports.")).
+	Field(service.NewIntField(dedupeSignalsFieldName).Default(1000).Description("Maximum number of signals remembered per subject. The oldest are forgotten first.")).
+	Field(service.NewIntField(dedupeSubjectsFieldName).Default(100000).Description("Maximum number of subjects whose signals are remembered at once."))
+
+// locationFilterKey identifies the location filter shared by all processor threads.
+type locationFilterKey struct{}
+
+// signalDeduperKey identifies the signal deduper shared by all processor threads.
+type signalDeduperKey struct{}
 
 func init() {
 	err := service.RegisterBatchProcessor(processorName, configSpec, ctor)
@@ -27,22 +84,214 @@ func init() {
 }
 
 func ctor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchProcessor, error) {
-	chainID, err := cfg.FieldInt(chainIDFieldName)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get %s: %w", chainIDFieldName, err)
+	contracts := map[vehicleContract]struct{}{}
+	if cfg.Contains(chainIDFieldName) || cfg.Contains(vehicleAddressFieldName) {
+		contract, err := parseVehicleContract(cfg, vehicleAddressFieldName)
+		if err != nil {
+			return nil, err
+		}
+		contracts[contract] = struct{}{}
 	}
-	vehicleAddress, err := cfg.FieldString(vehicleAddressFieldName)
+	contractConfs, err := cfg.FieldObjectList(vehicleContractsFieldName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get %s: %w", vehicleAddressFieldName, err)
+		return nil, fmt.Errorf("failed to get %s: %w", vehicleContractsFieldName, err)
+	}
+	for _, contractConf := range contractConfs {
+		contract, err := parseVehicleContract(contractConf, contractAddressFieldName)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", vehicleContractsFieldName, err)
+		}
+		contracts[contract] = struct{}{}
 	}
-	if !common.IsHexAddress(vehicleAddress) {
-		return nil, fmt.Errorf("invalid vehicle contract address: %s", vehicleAddress)
+	if len(contracts) == 0 {
+		return nil, fmt.Errorf("either %s and %s or %s must be set", chainIDFieldName, vehicleAddressFieldName, vehicleContractsFieldName)
 	}
 	m := mgr.Metrics()
+	rejected := m.NewCounter(MetricSignalsRejected, "reason", "name")
+	validator, err := parseValueValidator(cfg, rejected)
+	if err != nil {
+		return nil, err
+	}
+	locationWindow, err := cfg.FieldDuration(locationWindowFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", locationWindowFieldName, err)
+	}
+	if locationWindow <= 0 {
+		return nil, fmt.Errorf("%s must be positive", locationWindowFieldName)
+	}
+	locations, err := parseLocationFilter(cfg, mgr, rejected)
+	if err != nil {
+		return nil, err
+	}
+	deduper, err := parseSignalDeduper(cfg, mgr)
+	if err != nil {
+		return nil, err
+	}
 	return &vssProcessor{
-		logger:            mgr.Logger(),
-		vehicleNFTAddress: common.HexToAddress(vehicleAddress),
-		chainID:           uint64(chainID),
-		signalsPerReport:  m.NewTimer(processors.MetricSignalsPerReport),
+		logger:           mgr.Logger(),
+		vehicleContracts: contracts,
+		values:           validator,
+		locationWindow:   locationWindow,
+		locations:        locations,
+		deduper:          deduper,
+		signalsPerReport: m.NewTimer(processors.MetricSignalsPerReport),
+		skipped:          m.NewCounter(MetricSignalsSkipped, "source", "reason"),
+		duplicates:       m.NewCounter(MetricSignalsDuplicate, "source"),
+	}, nil
+}
+
+func parseValueValidator(cfg *service.ParsedConfig, rejected *service.MetricCounter) (*valueValidator, error) {
+	policyName, err := cfg.FieldString(valuePolicyFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", valuePolicyFieldName, err)
+	}
+	policy, err := parseValuePolicy(policyName)
+	if err != nil {
+		return nil, err
+	}
+	policyNames, err := cfg.FieldStringMap(valuePoliciesFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", valuePoliciesFieldName, err)
+	}
+	policies := make(map[string]valuePolicy, len(policyNames))
+	for name, policyName := range policyNames {
+		if policies[name], err = parseValuePolicy(policyName); err != nil {
+			return nil, fmt.Errorf("signal %s: %w", name, err)
+		}
+	}
+	rules, err := loadValueRules()
+	if err != nil {
+		return nil, err
+	}
+	rangeConfs, err := cfg.FieldObjectList(signalRangesFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", signalRangesFieldName, err)
+	}
+	for _, rangeConf := range rangeConfs {
+		if err := applySignalRange(rules, rangeConf); err != nil {
+			return nil, err
+		}
+	}
+	return &valueValidator{
+		rules:    rules,
+		policy:   policy,
+		policies: policies,
+		rejected: func(reason, name string) { rejected.Incr(1, reason, name) },
 	}, nil
 }
+
+// parseLocationFilter returns nil when the location filter is disabled.
+func parseLocationFilter(cfg *service.ParsedConfig, mgr *service.Resources, rejected *service.MetricCounter) (*locationFilter, error) {
+	enabled, err := cfg.FieldBool(locationFilterFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", locationFilterFieldName, err)
+	}
+	if !enabled {
+		return nil, nil
+	}
+	maxSpeed, err := cfg.FieldFloat(locationMaxSpeedFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", locationMaxSpeedFieldName, err)
+	}
+	if maxSpeed <= 0 {
+		return nil, fmt.Errorf("%s must be positive", locationMaxSpeedFieldName)
+	}
+	maxHDOP, err := cfg.FieldFloat(locationMaxHDOPFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", locationMaxHDOPFieldName, err)
+	}
+	action, err := cfg.FieldString(locationActionFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", locationActionFieldName, err)
+	}
+	if action != "reject" && action != "flag" {
+		return nil, fmt.Errorf("invalid %s %q: must be reject or flag", locationActionFieldName, action)
+	}
+	subjects, err := cfg.FieldInt(locationSubjectsFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", locationSubjectsFieldName, err)
+	}
+	filter, err := newLocationFilter(subjects, maxSpeed, maxHDOP, action == "flag", func(reason, name string) { rejected.Incr(1, reason, name) })
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", locationSubjectsFieldName, err)
+	}
+	// Every pipeline thread gets its own processor, but they must share the last locations.
+	shared, _ := mgr.GetOrSetGeneric(locationFilterKey{}, filter)
+	return shared.(*locationFilter), nil
+}
+
+// parseSignalDeduper returns nil when removing duplicates across reports is disabled.
+func parseSignalDeduper(cfg *service.ParsedConfig, mgr *service.Resources) (*signalDeduper, error) {
+	window, err := cfg.FieldDuration(dedupeWindowFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", dedupeWindowFieldName, err)
+	}
+	if window < 0 {
+		return nil, fmt.Errorf("%s must not be negative", dedupeWindowFieldName)
+	}
+	if window == 0 {
+		return nil, nil
+	}
+	maxSignals, err := cfg.FieldInt(dedupeSignalsFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", dedupeSignalsFieldName, err)
+	}
+	if maxSignals <= 0 {
+		return nil, fmt.Errorf("%s must be positive", dedupeSignalsFieldName)
+	}
+	subjects, err := cfg.FieldInt(dedupeSubjectsFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", dedupeSubjectsFieldName, err)
+	}
+	deduper, err := newSignalDeduper(subjects, maxSignals, window)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", dedupeSubjectsFieldName, err)
+	}
+	// Every pipeline thread gets its own processor, but they must share the seen signals.
+	shared, _ := mgr.GetOrSetGeneric(signalDeduperKey{}, deduper)
+	return shared.(*signalDeduper), nil
+}
+
+func applySignalRange(rules map[string]valueRule, conf *service.ParsedConfig) error {
+	name, err := conf.FieldString(rangeNameFieldName)
+	if err != nil {
+		return fmt.Errorf("failed to get %s of %s: %w", rangeNameFieldName, signalRangesFieldName, err)
+	}
+	rule, ok := rules[name]
+	if !ok {
+		return fmt.Errorf("unknown signal in %s: %s", signalRangesFieldName, name)
+	}
+	if conf.Contains(rangeMinFieldName) {
+		if rule.min, err = conf.FieldFloat(rangeMinFieldName); err != nil {
+			return fmt.Errorf("failed to get %s of signal %s: %w", rangeMinFieldName, name, err)
+		}
+	}
+	if conf.Contains(rangeMaxFieldName) {
+		if rule.max, err = conf.FieldFloat(rangeMaxFieldName); err != nil {
+			return fmt.Errorf("failed to get %s of signal %s: %w", rangeMaxFieldName, name, err)
+		}
+	}
+	if rule.min > rule.max {
+		return fmt.Errorf("min of signal %s is larger than its max", name)
+	}
+	rules[name] = rule
+	return nil
+}
+
+func parseVehicleContract(conf *service.ParsedConfig, addressFieldName string) (vehicleContract, error) {
+	chainID, err := conf.FieldInt(chainIDFieldName)
+	if err != nil {
+		return vehicleContract{}, fmt.Errorf("failed to get %s: %w", chainIDFieldName, err)
+	}
+	if chainID <= 0 {
+		return vehicleContract{}, fmt.Errorf("invalid chain id: %d", chainID)
+	}
+	address, err := conf.FieldString(addressFieldName)
+	if err != nil {
+		return vehicleContract{}, fmt.Errorf("failed to get %s: %w", addressFieldName, err)
+	}
+	if !common.IsHexAddress(address) {
+		return vehicleContract{}, fmt.Errorf("invalid vehicle contract address: %s", address)
+	}
+	return vehicleContract{chainID: uint64(chainID), address: common.HexToAddress(address)}, nil
+}
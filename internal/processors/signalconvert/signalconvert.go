@@ -6,9 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"slices"
+	"time"
 
 	"github.com/DIMO-Network/cloudevent"
 	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
 	"github.com/DIMO-Network/model-garage/pkg/convert"
 	"github.com/DIMO-Network/model-garage/pkg/modules"
 	"github.com/DIMO-Network/model-garage/pkg/vss"
@@ -19,19 +21,44 @@ import (
 const (
 	signalValidContentType = "dimo_valid_signal"
 	pruneSignalName        = "___prune"
+	// pendingSignalsKey is the metadata key holding the signals of a report
+	// that are remembered by the deduper once the report is written.
+	pendingSignalsKey = "dimo_pending_signals"
 )
 
 var (
 	errLatLongMismatch = errors.New("latitude and longitude mismatch")
-	errFutureTimestamp = errors.New("future timestamp")
 	pruneSignal        = vss.Signal{Data: vss.SignalData{Name: pruneSignalName}}
 )
 
+// Reasons status events are skipped, used as labels of MetricSignalsSkipped.
+const (
+	skipInvalidSubject = "invalid_subject"
+	skipUnknownVehicle = "unknown_vehicle_contract"
+	skipDisabled       = "extraction_disabled"
+)
+
+// vehicleContract is a vehicle NFT contract on one chain.
+type vehicleContract struct {
+	chainID uint64
+	address common.Address
+}
+
 type vssProcessor struct {
-	logger            *service.Logger
-	vehicleNFTAddress common.Address
-	chainID           uint64
-	signalsPerReport  *service.MetricTimer
+	logger *service.Logger
+	// vehicleContracts are the contracts whose vehicles signals are extracted for.
+	vehicleContracts map[vehicleContract]struct{}
+	// values validates signal values against their definitions.
+	values *valueValidator
+	// locationWindow is the maximum time between the parts of a location.
+	locationWindow time.Duration
+	// locations filters implausible locations. Nil disables filtering.
+	locations *locationFilter
+	// deduper removes signals seen in earlier reports. Nil disables it.
+	deduper          *signalDeduper
+	signalsPerReport *service.MetricTimer
+	skipped          *service.MetricCounter
+	duplicates       *service.MetricCounter
 }
 
 // Close to fulfill the service.Processor interface.
@@ -53,10 +80,14 @@ func (v *vssProcessor) processMsg(ctx context.Context, msg *service.Message) ser
 	// keep the original message and add any new signal messages to the batch
 	retBatch := service.MessageBatch{msg}
 	rawEvent, err := processors.MsgToEvent(msg)
-	if err != nil || !v.isVehicleSignalMessage(rawEvent) {
+	if err != nil || rawEvent.Type != cloudevent.TypeStatus {
 		// leave the message as is and continue to the next message
 		return retBatch
 	}
+	if reason := v.skipReason(rawEvent); reason != "" {
+		v.skipped.Incr(1, rawEvent.Source, reason)
+		return retBatch
+	}
 
 	signals, err := modules.ConvertToSignals(ctx, rawEvent.Source, *rawEvent)
 	if err != nil {
@@ -75,10 +106,24 @@ func (v *vssProcessor) processMsg(ctx context.Context, msg *service.Message) ser
 		return retBatch
 	}
 
-	signals, futureDupeErr := pruneFutureAndDuplicateSignals(signals)
-	signals, locationErr := handleCoordinates(signals)
+	clockOffset := processors.ClockOffset(&rawEvent.CloudEventHeader)
+	correctClockOffset(signals, clockOffset)
+	signals, violation, timeDupeErr := pruneOutOfBoundsAndDuplicateSignals(signals, rawEvent.Source, rawEvent.Type)
+	signals, valueErr := v.values.validate(signals)
+	signals, locationErr := handleCoordinates(signals, v.locationWindow)
+	if v.deduper != nil {
+		var duplicates int
+		signals, duplicates = v.deduper.dedupe(rawEvent.Subject, signals)
+		if duplicates > 0 {
+			v.duplicates.Incr(int64(duplicates), rawEvent.Source)
+		}
+	}
+	var plausibilityErr error
+	if v.locations != nil {
+		signals, plausibilityErr = v.locations.filter(rawEvent.Subject, signals)
+	}
 
-	if comboErr := errors.Join(futureDupeErr, locationErr); comboErr != nil {
+	if comboErr := errors.Join(timeDupeErr, valueErr, locationErr, plausibilityErr); comboErr != nil {
 		errMsg := msg.Copy()
 		errMsg.SetError(comboErr)
 		retBatch = append(retBatch, errMsg)
@@ -98,29 +143,70 @@ func (v *vssProcessor) processMsg(ctx context.Context, msg *service.Message) ser
 		Type:        cloudevent.TypeSignals,
 		DataVersion: rawEvent.DataVersion,
 	}
+	if violation != "" || clockOffset != 0 {
+		header.Extras = make(map[string]any)
+	}
+	if violation != "" {
+		header.Extras[processors.ExtensionTimeViolation] = string(violation)
+	}
+	if clockOffset != 0 {
+		header.Extras[processors.ExtensionClockOffset] = clockOffset.String()
+	}
 	signalCE := vss.PackSignals(header, signals)
 	msgCpy := msg.Copy()
 	msgCpy.SetStructured(signalCE)
 	msgCpy.MetaSetMut(processors.MessageContentKey, signalValidContentType)
+	if v.deduper != nil {
+		msgCpy.MetaSetMut(pendingSignalsKey, v.deduper.pending(rawEvent.Subject, signals))
+	}
 	retBatch = append(retBatch, msgCpy)
 	v.signalsPerReport.Timing(int64(len(signals)))
 	return retBatch
 }
 
-// pruneFutureAndDuplicateSignals removes signals that are too far in the future, as
-// well as exact duplicates.
-func pruneFutureAndDuplicateSignals(signals []vss.Signal) ([]vss.Signal, error) {
+// correctClockOffset moves signal timestamps back by the clock offset
+// recorded in their event.
+func correctClockOffset(signals []vss.Signal, offset time.Duration) {
+	if offset == 0 {
+		return
+	}
+	for i := range signals {
+		signals[i].Data.Timestamp = signals[i].Data.Timestamp.Add(-offset)
+	}
+}
+
+// pruneOutOfBoundsAndDuplicateSignals applies the time policy of the event to
+// its signals and removes exact duplicates. Rejected signals are removed,
+// clamped ones moved to the exceeded bound, and logged ones kept unmarked. It returns the first violation of
+// the kept signals, so that the signals event can be tagged with it.
+func pruneOutOfBoundsAndDuplicateSignals(signals []vss.Signal, source, ceType string) ([]vss.Signal, processors.TimeViolation, error) {
 	var errs error
+	var violation processors.TimeViolation
+	for i := range signals {
+		signal := &signals[i]
+		check := processors.CheckTimestamp(signal.Data.Timestamp, source, ceType, sourceconfig.TimeActionReject)
+		if check.Violation == "" {
+			continue
+		}
+		if check.Action == sourceconfig.TimeActionReject {
+			errs = errors.Join(errs, fmt.Errorf("signal '%s': %w", signal.Data.Name, check.Err()))
+			signals[i] = pruneSignal
+			continue
+		}
+		if check.Action == sourceconfig.TimeActionLog {
+			continue
+		}
+		signal.Data.Timestamp = check.Time
+		if violation == "" {
+			violation = check.Violation
+		}
+	}
+
 	slices.SortFunc(signals, func(a, b vss.Signal) int {
 		return cmp.Or(a.Data.Timestamp.Compare(b.Data.Timestamp), cmp.Compare(a.Data.Name, b.Data.Name))
 	})
 	for i := range signals {
-		signal := &signals[i]
-
-		// prune future signals
-		if processors.IsFutureTimestamp(signal.Data.Timestamp) {
-			errs = errors.Join(errs, fmt.Errorf("%w, signal '%s' has timestamp: %v", errFutureTimestamp, signal.Data.Name, signal.Data.Timestamp))
-			signals[i] = pruneSignal
+		if signals[i].Data.Name == pruneSignalName {
 			continue
 		}
 
@@ -140,24 +226,25 @@ func pruneFutureAndDuplicateSignals(signals []vss.Signal) ([]vss.Signal, error)
 			prunedSignals = append(prunedSignals, signal)
 		}
 	}
-	return prunedSignals, errs
+	return prunedSignals, violation, errs
 }
 
 func signalEqual(a, b vss.Signal) bool {
 	return a.Data.Name == b.Data.Name && a.Data.Timestamp.Equal(b.Data.Timestamp)
 }
 
-func (v *vssProcessor) isVehicleSignalMessage(rawEvent *cloudevent.RawEvent) bool {
-	if rawEvent.Type != cloudevent.TypeStatus {
-		return false
-	}
-
+// skipReason returns why signals are not extracted from a status event, or
+// an empty string if they are.
+func (v *vssProcessor) skipReason(rawEvent *cloudevent.RawEvent) string {
 	did, err := cloudevent.DecodeERC721DID(rawEvent.Subject)
 	if err != nil {
-		return false
+		return skipInvalidSubject
+	}
+	if _, ok := v.vehicleContracts[vehicleContract{chainID: did.ChainID, address: did.ContractAddress}]; !ok {
+		return skipUnknownVehicle
 	}
-	if did.ChainID != v.chainID || did.ContractAddress.Cmp(v.vehicleNFTAddress) != 0 {
-		return false
+	if !sourceconfig.For(rawEvent.Source).ExtractSignals {
+		return skipDisabled
 	}
-	return true
+	return ""
 }
@@ -2,10 +2,16 @@ package signalconvert
 
 import (
 	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
 	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/redpanda-data/benthos/v4/public/service"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -31,7 +37,7 @@ func TestPruneSignals(t *testing.T) {
 				{Data: vss.SignalData{Name: vss.FieldPowertrainFuelSystemRelativeLevel, Timestamp: now.Add(-1 * time.Hour), ValueNumber: 75.5}},
 				{Data: vss.SignalData{Name: vss.FieldPowertrainCombustionEngineECT, Timestamp: now.Add(-30 * time.Minute), ValueNumber: 90.0}},
 			},
-			expectError: []error{errFutureTimestamp},
+			expectError: []error{processors.ErrFutureTimestamp},
 		},
 		{
 			name: "duplicate signals should be pruned",
@@ -128,7 +134,7 @@ func TestPruneSignals(t *testing.T) {
 				{Data: vss.SignalData{Name: vss.FieldSpeed, Timestamp: now.Add(-2 * time.Hour), ValueNumber: 50.0}},
 				{Data: vss.SignalData{Name: vss.FieldPowertrainCombustionEngineECT, Timestamp: now.Add(-30 * time.Minute), ValueNumber: 90.0}},
 			},
-			expectError: []error{errLatLongMismatch, errFutureTimestamp},
+			expectError: []error{errLatLongMismatch, processors.ErrFutureTimestamp},
 		},
 		{
 			name: "multiple lat/long pairs should be handled correctly",
@@ -226,8 +232,8 @@ func TestPruneSignals(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err1 := pruneFutureAndDuplicateSignals(tt.signals)
-			result, err2 := handleCoordinates(result)
+			result, _, err1 := pruneOutOfBoundsAndDuplicateSignals(tt.signals, "", cloudevent.TypeStatus)
+			result, err2 := handleCoordinates(result, defaultLocationWindow)
 			err := errors.Join(err1, err2)
 
 			if tt.expectError != nil {
@@ -254,3 +260,233 @@ func TestPruneSignals(t *testing.T) {
 		})
 	}
 }
+
+func TestPruneSignalsTimePolicy(t *testing.T) {
+	t.Cleanup(func() { sourceconfig.Set(&sourceconfig.Registry{}) })
+	source := common.HexToAddress("0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8").Hex()
+	now := time.Now()
+	maxAge := 24 * time.Hour
+	newSignals := func() []vss.Signal {
+		return []vss.Signal{
+			{Data: vss.SignalData{Name: vss.FieldSpeed, Timestamp: time.Unix(0, 0), ValueNumber: 50.0}},
+			{Data: vss.SignalData{Name: vss.FieldPowertrainCombustionEngineSpeed, Timestamp: now.Add(-time.Hour), ValueNumber: 3000}},
+		}
+	}
+
+	tests := []struct {
+		name              string
+		action            sourceconfig.TimeAction
+		expectedLen       int
+		expectedViolation processors.TimeViolation
+		expectedError     error
+	}{
+		{name: "reject", action: sourceconfig.TimeActionReject, expectedLen: 1, expectedError: processors.ErrPastTimestamp},
+		{name: "clamp", action: sourceconfig.TimeActionClamp, expectedLen: 2, expectedViolation: processors.TimeViolationPast},
+		{name: "tag", action: sourceconfig.TimeActionTag, expectedLen: 2, expectedViolation: processors.TimeViolationPast},
+		{name: "log", action: sourceconfig.TimeActionLog, expectedLen: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceconfig.Set(&sourceconfig.Registry{Sources: map[string]sourceconfig.Overrides{
+				source: {TimePolicy: sourceconfig.TimePolicy{MaxAge: &maxAge, TimeAction: &tt.action}},
+			}})
+			result, violation, err := pruneOutOfBoundsAndDuplicateSignals(newSignals(), source, cloudevent.TypeStatus)
+			if tt.expectedError != nil {
+				require.ErrorIs(t, err, tt.expectedError)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Len(t, result, tt.expectedLen)
+			assert.Equal(t, tt.expectedViolation, violation)
+
+			switch tt.action {
+			case sourceconfig.TimeActionClamp:
+				assert.WithinDuration(t, now.Add(-maxAge), result[0].Data.Timestamp, time.Minute)
+			case sourceconfig.TimeActionTag, sourceconfig.TimeActionLog:
+				assert.Equal(t, time.Unix(0, 0), result[0].Data.Timestamp)
+			}
+		})
+	}
+}
+
+func TestHandleCoordinatesWindow(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	signal := func(name string, offset time.Duration, value float64) vss.Signal {
+		return vss.Signal{Data: vss.SignalData{Name: name, Timestamp: ts.Add(offset), ValueNumber: value}}
+	}
+	location := func(offset time.Duration, loc vss.Location) vss.Signal {
+		return vss.Signal{Data: vss.SignalData{Name: vss.FieldCurrentLocationCoordinates, Timestamp: ts.Add(offset), ValueLocation: loc}}
+	}
+
+	tests := []struct {
+		name            string
+		window          time.Duration
+		signals         []vss.Signal
+		expectedSignals []vss.Signal
+		expectError     error
+	}{
+		{
+			name:   "samples folded into location",
+			window: defaultLocationWindow,
+			signals: []vss.Signal{
+				signal(vss.FieldSpeed, -100*time.Millisecond, 50),
+				signal(fieldCurrentLocationLatitude, 0, 45.5),
+				signal(fieldCurrentLocationLongitude, 0, -122.6),
+				signal(vss.FieldCurrentLocationHeading, 200*time.Millisecond, 90),
+				signal(vss.FieldCurrentLocationAltitude, 300*time.Millisecond, 120),
+			},
+			expectedSignals: []vss.Signal{
+				signal(vss.FieldSpeed, -100*time.Millisecond, 50),
+				signal(vss.FieldCurrentLocationHeading, 200*time.Millisecond, 90),
+				signal(vss.FieldCurrentLocationAltitude, 300*time.Millisecond, 120),
+				location(0, vss.Location{Latitude: 45.5, Longitude: -122.6, Heading: 90}),
+				signal(fieldCurrentLocationFixAltitude, 0, 120),
+				signal(fieldCurrentLocationFixSpeed, 0, 50),
+			},
+		},
+		{
+			name:   "closest samples folded",
+			window: defaultLocationWindow,
+			signals: []vss.Signal{
+				signal(vss.FieldSpeed, -400*time.Millisecond, 40),
+				signal(fieldCurrentLocationLatitude, 0, 45.5),
+				signal(fieldCurrentLocationLongitude, 0, -122.6),
+				signal(vss.FieldCurrentLocationHeading, 100*time.Millisecond, 90),
+				signal(vss.FieldSpeed, 100*time.Millisecond, 50),
+				signal(vss.FieldCurrentLocationHeading, 300*time.Millisecond, 95),
+			},
+			expectedSignals: []vss.Signal{
+				signal(vss.FieldSpeed, -400*time.Millisecond, 40),
+				signal(vss.FieldCurrentLocationHeading, 100*time.Millisecond, 90),
+				signal(vss.FieldSpeed, 100*time.Millisecond, 50),
+				signal(vss.FieldCurrentLocationHeading, 300*time.Millisecond, 95),
+				location(0, vss.Location{Latitude: 45.5, Longitude: -122.6, Heading: 90}),
+				signal(fieldCurrentLocationFixSpeed, 0, 50),
+			},
+		},
+		{
+			name:   "samples outside window untouched",
+			window: defaultLocationWindow,
+			signals: []vss.Signal{
+				signal(fieldCurrentLocationLatitude, 0, 45.5),
+				signal(fieldCurrentLocationLongitude, 0, -122.6),
+				signal(vss.FieldCurrentLocationHeading, time.Second, 90),
+				signal(vss.FieldSpeed, time.Second, 50),
+			},
+			expectedSignals: []vss.Signal{
+				signal(vss.FieldCurrentLocationHeading, time.Second, 90),
+				signal(vss.FieldSpeed, time.Second, 50),
+				location(0, vss.Location{Latitude: 45.5, Longitude: -122.6}),
+			},
+		},
+		{
+			name:   "default window splits slow coordinates",
+			window: defaultLocationWindow,
+			signals: []vss.Signal{
+				signal(fieldCurrentLocationLatitude, 0, 45.5),
+				signal(fieldCurrentLocationLongitude, time.Second, -122.6),
+			},
+			expectedSignals: []vss.Signal{},
+			expectError:     errLatLongMismatch,
+		},
+		{
+			name:   "wider window pairs slow coordinates",
+			window: 2 * time.Second,
+			signals: []vss.Signal{
+				signal(fieldCurrentLocationLatitude, 0, 45.5),
+				signal(fieldCurrentLocationLongitude, time.Second, -122.6),
+				signal(vss.FieldSpeed, 1500*time.Millisecond, 50),
+			},
+			expectedSignals: []vss.Signal{
+				signal(vss.FieldSpeed, 1500*time.Millisecond, 50),
+				location(0, vss.Location{Latitude: 45.5, Longitude: -122.6}),
+				signal(fieldCurrentLocationFixSpeed, 0, 50),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := handleCoordinates(tt.signals, tt.window)
+			if tt.expectError != nil {
+				require.ErrorIs(t, err, tt.expectError)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Len(t, result, len(tt.expectedSignals))
+			for i := range result {
+				assert.Equal(t, tt.expectedSignals[i].Data, result[i].Data, "signal mismatch at index %d", i)
+			}
+		})
+	}
+}
+
+func TestCorrectClockOffset(t *testing.T) {
+	ts := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	signals := []vss.Signal{
+		{Data: vss.SignalData{Name: vss.FieldSpeed, Timestamp: ts}},
+		{Data: vss.SignalData{Name: vss.FieldPowertrainCombustionEngineSpeed, Timestamp: ts.Add(time.Second)}},
+	}
+	correctClockOffset(signals, time.Hour)
+	assert.Equal(t, ts.Add(-time.Hour), signals[0].Data.Timestamp)
+	assert.Equal(t, ts.Add(-time.Hour+time.Second), signals[1].Data.Timestamp)
+
+	correctClockOffset(signals, 0)
+	assert.Equal(t, ts.Add(-time.Hour), signals[0].Data.Timestamp)
+}
+
+func TestVehicleContracts(t *testing.T) {
+	const amoyVehicle = "0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8"
+	const polygonVehicle = "0xbA5738a18d83D41847dfFbDC6101d37C69c9B0cF"
+	tests := []struct {
+		name        string
+		yaml        string
+		subject     string
+		reason      string
+		expectError bool
+	}{
+		{
+			name:    "legacy contract",
+			yaml:    "chain_id: 80002\nvehicle_nft_address: " + amoyVehicle + "\n",
+			subject: "did:erc721:80002:" + amoyVehicle + ":1",
+		},
+		{
+			name:    "additional contract",
+			yaml:    "chain_id: 80002\nvehicle_nft_address: " + amoyVehicle + "\nvehicle_contracts:\n  - chain_id: 137\n    address: " + polygonVehicle + "\n",
+			subject: "did:erc721:137:" + polygonVehicle + ":1",
+		},
+		{
+			name:    "contracts only",
+			yaml:    "vehicle_contracts:\n  - chain_id: 137\n    address: " + strings.ToLower(polygonVehicle) + "\n",
+			subject: "did:erc721:137:" + polygonVehicle + ":1",
+		},
+		{
+			name:    "contract on other chain",
+			yaml:    "vehicle_contracts:\n  - chain_id: 137\n    address: " + polygonVehicle + "\n",
+			subject: "did:erc721:80002:" + polygonVehicle + ":1",
+			reason:  skipUnknownVehicle,
+		},
+		{
+			name:    "invalid subject",
+			yaml:    "chain_id: 80002\nvehicle_nft_address: " + amoyVehicle + "\n",
+			subject: "did:ethr:80002:" + amoyVehicle,
+			reason:  skipInvalidSubject,
+		},
+		{name: "no contracts", yaml: "{}", expectError: true},
+		{name: "missing chain id", yaml: "vehicle_nft_address: " + amoyVehicle + "\n", expectError: true},
+		{name: "invalid address", yaml: "vehicle_contracts:\n  - chain_id: 137\n    address: vehicles\n", expectError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := configSpec.ParseYAML(tt.yaml, nil)
+			require.NoError(t, err)
+			proc, err := ctor(conf, service.MockResources())
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			event := &cloudevent.RawEvent{CloudEventHeader: cloudevent.CloudEventHeader{Type: cloudevent.TypeStatus, Subject: tt.subject}}
+			assert.Equal(t, tt.reason, proc.(*vssProcessor).skipReason(event))
+		})
+	}
+}
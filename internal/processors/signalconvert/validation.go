@@ -0,0 +1,208 @@
+package signalconvert
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/DIMO-Network/model-garage/pkg/schema"
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+)
+
+// valuePolicy is what happens to a signal whose value is outside of its definition.
+type valuePolicy string
+
+// Value policies.
+const (
+	// valuePolicyDrop removes the signal.
+	valuePolicyDrop valuePolicy = "drop"
+	// valuePolicyClamp moves the value to the exceeded bound. Values that are
+	// not allowed or not finite cannot be clamped and are dropped.
+	valuePolicyClamp valuePolicy = "clamp"
+	// valuePolicyFlag keeps the signal and only reports the error.
+	valuePolicyFlag valuePolicy = "flag"
+)
+
+// Reasons values are rejected, used as labels of MetricSignalsRejected.
+const (
+	rejectBelowMin   = "below_min"
+	rejectAboveMax   = "above_max"
+	rejectNotAllowed = "not_allowed"
+	rejectNotFinite  = "not_finite"
+)
+
+var (
+	errValueOutOfRange = errors.New("value out of range")
+	errValueNotAllowed = errors.New("value not allowed")
+
+	// allowedValuePattern matches the values of the Allowed column, e.g. ['ON', 'OFF'].
+	allowedValuePattern = regexp.MustCompile(`'([^']*)'`)
+
+	// dataTypeRanges are the ranges implied by the VSS data types.
+	dataTypeRanges = map[string][2]float64{
+		"boolean": {0, 1},
+		"uint8":   {0, math.MaxUint8},
+		"int8":    {math.MinInt8, math.MaxInt8},
+		"uint16":  {0, math.MaxUint16},
+		"int16":   {math.MinInt16, math.MaxInt16},
+		"uint32":  {0, math.MaxUint32},
+		"int32":   {math.MinInt32, math.MaxInt32},
+		"uint64":  {0, math.Inf(1)},
+		"int64":   {math.Inf(-1), math.Inf(1)},
+	}
+)
+
+// valueRule is the definition a signal value is validated against.
+type valueRule struct {
+	min, max float64
+	// allowed is empty when every string value is allowed.
+	allowed []string
+}
+
+// valueValidator validates signal values against the VSS signal definitions.
+type valueValidator struct {
+	rules    map[string]valueRule
+	policy   valuePolicy
+	policies map[string]valuePolicy
+	// rejected is called with the reason and signal name of every value outside of its definition.
+	rejected func(reason, name string)
+}
+
+// loadValueRules reads the min, max and allowed values of the default
+// signals. Data types bound the values of signals without their own min or max.
+func loadValueRules() (map[string]valueRule, error) {
+	signals, err := schema.GetDefaultSignals()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signal definitions: %w", err)
+	}
+	allowed, err := loadAllowedValues()
+	if err != nil {
+		return nil, err
+	}
+	rules := make(map[string]valueRule, len(signals))
+	for _, signal := range signals {
+		rule := valueRule{min: math.Inf(-1), max: math.Inf(1)}
+		if bounds, ok := dataTypeRanges[signal.DataType]; ok {
+			rule.min, rule.max = bounds[0], bounds[1]
+		}
+		if signal.Min != "" {
+			if rule.min, err = strconv.ParseFloat(signal.Min, 64); err != nil {
+				return nil, fmt.Errorf("invalid min of signal %s: %w", signal.Name, err)
+			}
+		}
+		if signal.Max != "" {
+			if rule.max, err = strconv.ParseFloat(signal.Max, 64); err != nil {
+				return nil, fmt.Errorf("invalid max of signal %s: %w", signal.Name, err)
+			}
+		}
+		if !signal.IsArray {
+			rule.allowed = allowed[signal.Name]
+		}
+		rules[signal.JSONName] = rule
+	}
+	return rules, nil
+}
+
+// loadAllowedValues reads the Allowed column of the VSS spec, which the
+// schema package does not load, keyed by VSS node name.
+func loadAllowedValues() (map[string][]string, error) {
+	records, err := csv.NewReader(strings.NewReader(schema.VssRel42DIMO())).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vss spec: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("vss spec is empty")
+	}
+	nodeCol, allowedCol := slices.Index(records[0], "Node"), slices.Index(records[0], "Allowed")
+	if nodeCol < 0 || allowedCol < 0 {
+		return nil, errors.New("vss spec has no Node or Allowed column")
+	}
+	allowed := map[string][]string{}
+	for _, record := range records[1:] {
+		for _, match := range allowedValuePattern.FindAllStringSubmatch(record[allowedCol], -1) {
+			allowed[record[nodeCol]] = append(allowed[record[nodeCol]], match[1])
+		}
+	}
+	return allowed, nil
+}
+
+// validate applies the value policy of each signal to values outside of their
+// definition. Dropped signals are removed. The returned slice of signals is
+// always meaningful, even if an error is also returned.
+func (v *valueValidator) validate(signals []vss.Signal) ([]vss.Signal, error) {
+	var errs error
+	kept := signals[:0]
+	for _, signal := range signals {
+		rule, ok := v.rules[signal.Data.Name]
+		if !ok {
+			kept = append(kept, signal)
+			continue
+		}
+		reason, bound := rule.check(signal.Data)
+		if reason == "" {
+			kept = append(kept, signal)
+			continue
+		}
+		v.rejected(reason, signal.Data.Name)
+		err := errValueOutOfRange
+		if reason == rejectNotAllowed {
+			err = errValueNotAllowed
+		}
+		errs = errors.Join(errs, fmt.Errorf("%w, signal '%s' has %s value: %s", err, signal.Data.Name, strings.ReplaceAll(reason, "_", " "), formatValue(signal.Data)))
+
+		policy := v.policy
+		if signalPolicy, ok := v.policies[signal.Data.Name]; ok {
+			policy = signalPolicy
+		}
+		switch {
+		case policy == valuePolicyFlag:
+			kept = append(kept, signal)
+		case policy == valuePolicyClamp && (reason == rejectBelowMin || reason == rejectAboveMax):
+			signal.Data.ValueNumber = bound
+			kept = append(kept, signal)
+		}
+	}
+	return kept, errs
+}
+
+// check returns the reason a value is outside of the rule, and for values out
+// of range the exceeded bound. The reason is empty for valid values.
+func (r valueRule) check(data vss.SignalData) (string, float64) {
+	if len(r.allowed) > 0 {
+		if !slices.Contains(r.allowed, data.ValueString) {
+			return rejectNotAllowed, 0
+		}
+		return "", 0
+	}
+	switch value := data.ValueNumber; {
+	case math.IsNaN(value) || math.IsInf(value, 0):
+		return rejectNotFinite, 0
+	case value < r.min:
+		return rejectBelowMin, r.min
+	case value > r.max:
+		return rejectAboveMax, r.max
+	default:
+		return "", 0
+	}
+}
+
+func formatValue(data vss.SignalData) string {
+	if data.ValueString != "" {
+		return strconv.Quote(data.ValueString)
+	}
+	return strconv.FormatFloat(data.ValueNumber, 'g', -1, 64)
+}
+
+func parseValuePolicy(policy string) (valuePolicy, error) {
+	switch p := valuePolicy(policy); p {
+	case valuePolicyDrop, valuePolicyClamp, valuePolicyFlag:
+		return p, nil
+	default:
+		return "", fmt.Errorf("invalid value policy %q: must be drop, clamp or flag", policy)
+	}
+}
@@ -0,0 +1,118 @@
+package signalconvert
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/model-garage/pkg/vss"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestValidator(t *testing.T, yaml string) (*valueValidator, *[]string) {
+	t.Helper()
+	conf, err := configSpec.ParseYAML(yaml, nil)
+	require.NoError(t, err)
+	validator, err := parseValueValidator(conf, service.MockResources().Metrics().NewCounter(MetricSignalsRejected, "reason", "name"))
+	require.NoError(t, err)
+	var rejected []string
+	validator.rejected = func(reason, name string) { rejected = append(rejected, reason+":"+name) }
+	return validator, &rejected
+}
+
+func TestValidateSignalValues(t *testing.T) {
+	ts := time.Now()
+	number := func(name string, value float64) vss.Signal {
+		return vss.Signal{Data: vss.SignalData{Name: name, Timestamp: ts, ValueNumber: value}}
+	}
+	text := func(name, value string) vss.Signal {
+		return vss.Signal{Data: vss.SignalData{Name: name, Timestamp: ts, ValueString: value}}
+	}
+	tests := []struct {
+		name             string
+		yaml             string
+		signals          []vss.Signal
+		expectedSignals  []vss.Signal
+		expectedRejected []string
+		expectError      error
+	}{
+		{
+			name:            "valid values",
+			yaml:            "{}",
+			signals:         []vss.Signal{number(vss.FieldSpeed, 88), number(vss.FieldPowertrainFuelSystemRelativeLevel, 100), text(vss.FieldPowertrainType, "ELECTRIC"), number(vss.FieldIsIgnitionOn, 1)},
+			expectedSignals: []vss.Signal{number(vss.FieldSpeed, 88), number(vss.FieldPowertrainFuelSystemRelativeLevel, 100), text(vss.FieldPowertrainType, "ELECTRIC"), number(vss.FieldIsIgnitionOn, 1)},
+		},
+		{
+			name:             "drop by default",
+			yaml:             "{}",
+			signals:          []vss.Signal{number(vss.FieldSpeed, -400), number(vss.FieldPowertrainFuelSystemRelativeLevel, 250), text(vss.FieldPowertrainType, "STEAM"), number(vss.FieldIsIgnitionOn, 2), number(vss.FieldPowertrainCombustionEngineSpeed, math.NaN())},
+			expectedSignals:  []vss.Signal{},
+			expectedRejected: []string{"below_min:speed", "above_max:powertrainFuelSystemRelativeLevel", "not_allowed:powertrainType", "above_max:isIgnitionOn", "not_finite:powertrainCombustionEngineSpeed"},
+			expectError:      errValueOutOfRange,
+		},
+		{
+			name:             "clamp",
+			yaml:             "signal_value_policy: clamp\n",
+			signals:          []vss.Signal{number(vss.FieldSpeed, -400), number(vss.FieldPowertrainFuelSystemRelativeLevel, 250), text(vss.FieldPowertrainType, "STEAM")},
+			expectedSignals:  []vss.Signal{number(vss.FieldSpeed, 0), number(vss.FieldPowertrainFuelSystemRelativeLevel, 100)},
+			expectedRejected: []string{"below_min:speed", "above_max:powertrainFuelSystemRelativeLevel", "not_allowed:powertrainType"},
+			expectError:      errValueNotAllowed,
+		},
+		{
+			name:             "flag per signal",
+			yaml:             "signal_value_policies:\n  speed: flag\n",
+			signals:          []vss.Signal{number(vss.FieldSpeed, 600), number(vss.FieldPowertrainFuelSystemRelativeLevel, 250)},
+			expectedSignals:  []vss.Signal{number(vss.FieldSpeed, 600)},
+			expectedRejected: []string{"above_max:speed", "above_max:powertrainFuelSystemRelativeLevel"},
+			expectError:      errValueOutOfRange,
+		},
+		{
+			name:            "configured range",
+			yaml:            "signal_ranges:\n  - name: speed\n    max: 1000\n",
+			signals:         []vss.Signal{number(vss.FieldSpeed, -400), number(vss.FieldSpeed, 600)},
+			expectedSignals: []vss.Signal{number(vss.FieldSpeed, -400), number(vss.FieldSpeed, 600)},
+		},
+		{
+			name:            "unknown signals are kept",
+			yaml:            "{}",
+			signals:         []vss.Signal{number(fieldCurrentLocationLatitude, -400)},
+			expectedSignals: []vss.Signal{number(fieldCurrentLocationLatitude, -400)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator, rejected := newTestValidator(t, tt.yaml)
+			result, err := validator.validate(tt.signals)
+			if tt.expectError != nil {
+				require.ErrorIs(t, err, tt.expectError)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedSignals, result)
+			assert.Equal(t, tt.expectedRejected, *rejected)
+		})
+	}
+}
+
+func TestValueValidatorConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+	}{
+		{name: "invalid policy", yaml: "signal_value_policy: ignore\n"},
+		{name: "invalid signal policy", yaml: "signal_value_policies:\n  speed: ignore\n"},
+		{name: "unknown signal range", yaml: "signal_ranges:\n  - name: warpSpeed\n    max: 9\n"},
+		{name: "min above max", yaml: "signal_ranges:\n  - name: speed\n    min: 10\n    max: 5\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := configSpec.ParseYAML(tt.yaml, nil)
+			if err == nil {
+				_, err = parseValueValidator(conf, service.MockResources().Metrics().NewCounter(MetricSignalsRejected, "reason", "name"))
+			}
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package sourceratelimit
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
+	"github.com/ethereum/go-ethereum/common"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	reasonRequests        = "requests"
+	reasonBytes           = "bytes"
+	reasonSubjectRequests = "subject_requests"
+	reasonSubjectBytes    = "subject_bytes"
+)
+
+// requestReasons and byteReasons name the budget exceeded by the source and
+// the subject limiter of a message, in that order.
+var (
+	requestReasons = [...]string{reasonRequests, reasonSubjectRequests}
+	byteReasons    = [...]string{reasonBytes, reasonSubjectBytes}
+)
+
+// Budgets is the content of the budgets file.
+type Budgets struct {
+	// Default applies to every source without an entry in Sources.
+	Default sourceconfig.RateLimit `yaml:"default"`
+	// Subject applies to each source and subject pair when a subject is configured.
+	Subject sourceconfig.RateLimit `yaml:"subject"`
+	// Sources overrides Default for individual connection sources.
+	Sources map[string]sourceconfig.RateLimit `yaml:"sources"`
+}
+
+// LoadBudgets reads and validates a budgets file. An empty path yields
+// unlimited budgets. Source addresses are normalized to their EIP-55 form.
+func LoadBudgets(path string) (*Budgets, error) {
+	budgets := &Budgets{}
+	if path == "" {
+		return budgets, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read budgets file: %w", err)
+	}
+	if err := yaml.Unmarshal(raw, budgets); err != nil {
+		return nil, fmt.Errorf("failed to parse budgets file: %w", err)
+	}
+	if err := budgets.Default.Validate(); err != nil {
+		return nil, fmt.Errorf("default budget: %w", err)
+	}
+	if err := budgets.Subject.Validate(); err != nil {
+		return nil, fmt.Errorf("subject budget: %w", err)
+	}
+	sources := make(map[string]sourceconfig.RateLimit, len(budgets.Sources))
+	for source, budget := range budgets.Sources {
+		if !common.IsHexAddress(source) {
+			return nil, fmt.Errorf("invalid source address in budgets file: %s", source)
+		}
+		if err := budget.Validate(); err != nil {
+			return nil, fmt.Errorf("budget for %s: %w", source, err)
+		}
+		sources[common.HexToAddress(source).Hex()] = budget
+	}
+	budgets.Sources = sources
+	return budgets, nil
+}
+
+// ForSource returns the budget for a normalized source address.
+func (b *Budgets) ForSource(source string) sourceconfig.RateLimit {
+	if budget, ok := b.Sources[source]; ok {
+		return budget
+	}
+	return b.Default
+}
+
+// limiter enforces a budget for one key.
+type limiter struct {
+	budget sourceconfig.RateLimit
+	// requests is nil when the request rate is unlimited.
+	requests *rate.Limiter
+
+	mu    sync.Mutex
+	day   int64
+	bytes int64
+}
+
+func newLimiter(budget sourceconfig.RateLimit) *limiter {
+	l := &limiter{budget: budget}
+	if budget.RequestsPerSecond > 0 {
+		burst := budget.Burst
+		if burst == 0 {
+			burst = max(1, int(math.Ceil(budget.RequestsPerSecond)))
+		}
+		l.requests = rate.NewLimiter(rate.Limit(budget.RequestsPerSecond), burst)
+	}
+	return l
+}
+
+// reserveRequest takes a request token. It returns the reservation, which is
+// nil when the request rate is unlimited, and how long to wait if no token is
+// available, in which case nothing is taken.
+func (l *limiter) reserveRequest(now time.Time) (*rate.Reservation, time.Duration) {
+	if l.requests == nil {
+		return nil, 0
+	}
+	reservation := l.requests.ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		reservation.CancelAt(now)
+		return nil, delay
+	}
+	return reservation, 0
+}
+
+// cancelRequests returns the tokens of reservations whose message was rejected.
+func cancelRequests(now time.Time, reservations []*rate.Reservation) {
+	for _, reservation := range reservations {
+		if reservation != nil {
+			reservation.CancelAt(now)
+		}
+	}
+}
+
+// takeBytes records size bytes against today's budget of every limiter, or of
+// none when one of them is exceeded. It returns the index of the first
+// exceeded limiter and how long to wait until size more bytes fit, or -1 when
+// the bytes were recorded. limiters must always be passed in the same order.
+func takeBytes(now time.Time, size int64, limiters []*limiter) (int, time.Duration) {
+	for _, l := range limiters {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+	}
+	for i, l := range limiters {
+		if l.budget.BytesPerDay == 0 {
+			continue
+		}
+		l.rollDay(now)
+		if l.bytes+size > l.budget.BytesPerDay {
+			return i, now.UTC().Truncate(24 * time.Hour).Add(24 * time.Hour).Sub(now)
+		}
+	}
+	for _, l := range limiters {
+		l.bytes += size
+	}
+	return -1, 0
+}
+
+func (l *limiter) rollDay(now time.Time) {
+	day := now.UTC().Unix() / int64(24*time.Hour/time.Second)
+	if day != l.day {
+		l.day = day
+		l.bytes = 0
+	}
+}
@@ -0,0 +1,56 @@
+package sourceratelimit
+
+import (
+	"fmt"
+
+	"github.com/redpanda-data/benthos/v4/public/service"
+)
+
+const (
+	processorName           = "dimo_source_rate_limit"
+	budgetsFileFieldName    = "budgets_file"
+	reloadIntervalFieldName = "reload_interval"
+	subjectFieldName        = "subject"
+	cacheSizeFieldName      = "subject_cache_size"
+)
+
+var configSpec = service.NewConfigSpec().
+	Summary("Rate limits and meters messages per dimo_cloudevent_source and optionally per subject").
+	Field(service.NewStringField(budgetsFileFieldName).Default("").Description("Path to a YAML file with per source request and byte budgets. Nothing is limited when empty.")).
+	Field(service.NewDurationField(reloadIntervalFieldName).Default("1m").Description("How often the budgets file is checked for changes. Limiter state is reset when it changes.")).
+	Field(service.NewInterpolatedStringField(subjectFieldName).Default("").Description("Subject of the message. When it resolves to a non-empty value the subject budget also applies to the source and subject pair.")).
+	Field(service.NewIntField(cacheSizeFieldName).Default(100000).Description("Maximum number of source and subject pairs tracked at once."))
+
+func init() {
+	err := service.RegisterBatchProcessor(processorName, configSpec, ctor)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func ctor(cfg *service.ParsedConfig, mgr *service.Resources) (service.BatchProcessor, error) {
+	budgetsFile, err := cfg.FieldString(budgetsFileFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", budgetsFileFieldName, err)
+	}
+	reloadInterval, err := cfg.FieldDuration(reloadIntervalFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", reloadIntervalFieldName, err)
+	}
+	subject, err := cfg.FieldInterpolatedString(subjectFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", subjectFieldName, err)
+	}
+	cacheSize, err := cfg.FieldInt(cacheSizeFieldName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s: %w", cacheSizeFieldName, err)
+	}
+	proc, err := newProcessor(budgetsFile, subject, cacheSize, mgr)
+	if err != nil {
+		return nil, err
+	}
+	if budgetsFile != "" && reloadInterval > 0 {
+		go proc.reloadLoop(reloadInterval)
+	}
+	return proc, nil
+}
@@ -0,0 +1,223 @@
+// Package sourceratelimit enforces per connection source request rates and
+// daily byte quotas so that one integrator cannot use up shared capacity.
+package sourceratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors"
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// RetryAfterKey is the metadata key holding the Retry-After response header in seconds.
+	// The HTTP inputs copy it into the response through sync_response.metadata_headers.
+	RetryAfterKey = "Retry-After"
+
+	// MetricRateLimited counts messages rejected by a budget, labelled by source and reason.
+	MetricRateLimited = "dis_rate_limited_total"
+	// MetricSourceBytes counts accepted message bytes, labelled by source.
+	MetricSourceBytes = "dis_source_bytes_total"
+)
+
+var (
+	errRateLimited   = errors.New("request rate limit exceeded")
+	errQuotaExceeded = errors.New("daily byte quota exceeded")
+)
+
+type processor struct {
+	logger       *service.Logger
+	limited      *service.MetricCounter
+	sourceBytes  *service.MetricCounter
+	subject      *service.InterpolatedString
+	budgetsFile  string
+	budgetsMTime time.Time
+	stop         chan struct{}
+
+	mu       sync.Mutex
+	budgets  *Budgets
+	sources  map[string]*limiter
+	subjects *lru.Cache[string, *limiter]
+}
+
+func newProcessor(budgetsFile string, subject *service.InterpolatedString, cacheSize int, mgr *service.Resources) (*processor, error) {
+	subjects, err := lru.New[string, *limiter](cacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create subject cache: %w", err)
+	}
+	p := &processor{
+		logger:      mgr.Logger(),
+		limited:     mgr.Metrics().NewCounter(MetricRateLimited, "source", "reason"),
+		sourceBytes: mgr.Metrics().NewCounter(MetricSourceBytes, "source"),
+		subject:     subject,
+		budgetsFile: budgetsFile,
+		stop:        make(chan struct{}),
+		sources:     map[string]*limiter{},
+		subjects:    subjects,
+	}
+	if budgetsFile != "" {
+		info, err := os.Stat(budgetsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read budgets file: %w", err)
+		}
+		p.budgetsMTime = info.ModTime()
+	}
+	p.budgets, err = LoadBudgets(budgetsFile)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close to fulfill the service.Processor interface.
+func (p *processor) Close(context.Context) error {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	return nil
+}
+
+// reloadLoop reloads the budgets file whenever its modification time changes.
+func (p *processor) reloadLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.reload(); err != nil {
+				p.logger.Errorf("Failed to reload budgets, keeping previous budgets: %v", err)
+			}
+		}
+	}
+}
+
+func (p *processor) reload() error {
+	info, err := os.Stat(p.budgetsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read budgets file: %w", err)
+	}
+	if info.ModTime().Equal(p.budgetsMTime) {
+		return nil
+	}
+	budgets, err := LoadBudgets(p.budgetsFile)
+	if err != nil {
+		return err
+	}
+	p.budgetsMTime = info.ModTime()
+	p.mu.Lock()
+	p.budgets = budgets
+	p.sources = map[string]*limiter{}
+	p.subjects.Purge()
+	p.mu.Unlock()
+	p.logger.Infof("Reloaded source budgets from %s", p.budgetsFile)
+	return nil
+}
+
+// ProcessBatch to fulfill the service.BatchProcessor interface.
+func (p *processor) ProcessBatch(_ context.Context, msgs service.MessageBatch) ([]service.MessageBatch, error) {
+	now := time.Now()
+	for _, msg := range msgs {
+		// Never echo a Retry-After header supplied by the client.
+		msg.MetaDelete(RetryAfterKey)
+		source, ok := msg.MetaGet(httpinputserver.DIMOCloudEventSource)
+		if !ok {
+			continue
+		}
+		msgBytes, err := msg.AsBytes()
+		if err != nil {
+			processors.SetError(msg, processorName, "failed to get message as bytes", err)
+			continue
+		}
+		subject := ""
+		if p.subject != nil {
+			if subject, err = p.subject.TryString(msg); err != nil {
+				processors.SetError(msg, processorName, "failed to evaluate subject", err)
+				continue
+			}
+		}
+
+		reason, wait := p.allow(now, source, subject, int64(len(msgBytes)))
+		if reason != "" {
+			p.limited.Incr(1, source, reason)
+			msg.MetaSetMut(RetryAfterKey, strconv.Itoa(retryAfterSeconds(wait)))
+			limitErr := errRateLimited
+			if reason == reasonBytes || reason == reasonSubjectBytes {
+				limitErr = errQuotaExceeded
+			}
+			processors.SetError(msg, processorName, "too many requests", fmt.Errorf("%w: %s", limitErr, reason))
+			continue
+		}
+		p.sourceBytes.Incr(int64(len(msgBytes)), source)
+	}
+	return []service.MessageBatch{msgs}, nil
+}
+
+// allow checks every budget that applies to the message and records it when
+// accepted. It returns the reason and wait time of the first exceeded budget.
+// A rejected message counts against none of its budgets.
+func (p *processor) allow(now time.Time, source, subject string, size int64) (string, time.Duration) {
+	sourceLimiter, subjectLimiter := p.limiters(source, subject)
+	limiters := []*limiter{sourceLimiter}
+	if subjectLimiter != nil {
+		limiters = append(limiters, subjectLimiter)
+	}
+
+	reservations := make([]*rate.Reservation, 0, len(limiters))
+	for i, l := range limiters {
+		reservation, wait := l.reserveRequest(now)
+		if wait > 0 {
+			cancelRequests(now, reservations)
+			return requestReasons[i], wait
+		}
+		reservations = append(reservations, reservation)
+	}
+	if i, wait := takeBytes(now, size, limiters); i >= 0 {
+		cancelRequests(now, reservations)
+		return byteReasons[i], wait
+	}
+	return "", 0
+}
+
+func (p *processor) limiters(source, subject string) (*limiter, *limiter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	budget := p.budgets.ForSource(source)
+	if override := sourceconfig.For(source).RateLimit; override != nil {
+		budget = *override
+	}
+	// A limiter is replaced when the source config registry changes its budget.
+	sourceLimiter, ok := p.sources[source]
+	if !ok || sourceLimiter.budget != budget {
+		sourceLimiter = newLimiter(budget)
+		p.sources[source] = sourceLimiter
+	}
+	if subject == "" {
+		return sourceLimiter, nil
+	}
+	key := source + "|" + subject
+	subjectLimiter, ok := p.subjects.Get(key)
+	if !ok {
+		subjectLimiter = newLimiter(p.budgets.Subject)
+		p.subjects.Add(key, subjectLimiter)
+	}
+	return sourceLimiter, subjectLimiter
+}
+
+func retryAfterSeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
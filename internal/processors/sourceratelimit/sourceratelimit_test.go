@@ -0,0 +1,221 @@
+package sourceratelimit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
+	"github.com/redpanda-data/benthos/v4/public/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	limitedSource = "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b"
+	defaultSource = "0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8"
+)
+
+const testBudgets = `
+default:
+  requests_per_second: 1000
+subject:
+  requests_per_second: 1
+  burst: 1
+sources:
+  "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b":
+    requests_per_second: 1
+    burst: 2
+    bytes_per_day: 25
+`
+
+func writeBudgets(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "budgets.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func newTestProcessor(t *testing.T, budgetsFile, subject string) *processor {
+	t.Helper()
+	subjectField, err := service.NewInterpolatedString(subject)
+	require.NoError(t, err)
+	proc, err := newProcessor(budgetsFile, subjectField, 10, service.MockResources())
+	require.NoError(t, err)
+	return proc
+}
+
+func newMsg(source, body string) *service.Message {
+	msg := service.NewMessage([]byte(body))
+	msg.MetaSetMut(httpinputserver.DIMOCloudEventSource, source)
+	return msg
+}
+
+func TestProcessBatchRequests(t *testing.T) {
+	proc := newTestProcessor(t, writeBudgets(t, testBudgets), "")
+
+	// The limited source may burst two requests, then has to wait for a token.
+	for i := range 3 {
+		msg := newMsg(limitedSource, "{}")
+		msg.MetaSetMut(RetryAfterKey, "client supplied")
+		_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+		require.NoError(t, err)
+		retryAfter, hasRetryAfter := msg.MetaGet(RetryAfterKey)
+		if i < 2 {
+			require.NoError(t, msg.GetError())
+			assert.False(t, hasRetryAfter)
+			continue
+		}
+		require.ErrorIs(t, msg.GetError(), errRateLimited)
+		assert.Equal(t, "1", retryAfter)
+	}
+
+	// Other sources have their own budget.
+	msg := newMsg(defaultSource, "{}")
+	_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.NoError(t, msg.GetError())
+}
+
+func TestProcessBatchBytes(t *testing.T) {
+	proc := newTestProcessor(t, writeBudgets(t, "sources:\n  \""+limitedSource+"\":\n    bytes_per_day: 25\n"), "")
+
+	msg := newMsg(limitedSource, `{"data":"0123456789"}`)
+	_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.NoError(t, msg.GetError())
+
+	msg = newMsg(limitedSource, `{"data":"0123456789"}`)
+	_, err = proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.ErrorIs(t, msg.GetError(), errQuotaExceeded)
+	retryAfter, ok := msg.MetaGet(RetryAfterKey)
+	require.True(t, ok)
+	assert.NotEqual(t, "0", retryAfter)
+
+	// A small message still fits in the remaining budget.
+	msg = newMsg(limitedSource, "{}")
+	_, err = proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.NoError(t, msg.GetError())
+}
+
+func TestProcessBatchSubject(t *testing.T) {
+	proc := newTestProcessor(t, writeBudgets(t, testBudgets), `${! metadata("subject").or("") }`)
+
+	send := func(subject string) error {
+		msg := newMsg(defaultSource, "{}")
+		msg.MetaSetMut("subject", subject)
+		_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+		require.NoError(t, err)
+		return msg.GetError()
+	}
+	require.NoError(t, send("vehicle-1"))
+	require.ErrorIs(t, send("vehicle-1"), errRateLimited)
+	require.NoError(t, send("vehicle-2"))
+	// Messages without a subject only count against the source.
+	require.NoError(t, send(""))
+	require.NoError(t, send(""))
+}
+
+func TestProcessBatchRejectedTakesNothing(t *testing.T) {
+	proc := newTestProcessor(t, writeBudgets(t, testBudgets), `${! metadata("subject").or("") }`)
+
+	send := func(subject string) error {
+		msg := newMsg(limitedSource, "{}")
+		msg.MetaSetMut("subject", subject)
+		_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+		require.NoError(t, err)
+		return msg.GetError()
+	}
+	// The source may burst two requests. The one rejected by its subject
+	// budget does not use up the second.
+	require.NoError(t, send("vehicle-1"))
+	require.ErrorIs(t, send("vehicle-1"), errRateLimited)
+	require.NoError(t, send("vehicle-2"))
+	require.ErrorIs(t, send("vehicle-3"), errRateLimited)
+}
+
+func TestProcessBatchBytesConcurrent(t *testing.T) {
+	proc := newTestProcessor(t, writeBudgets(t, "sources:\n  \""+limitedSource+"\":\n    bytes_per_day: 100\n"), "")
+
+	var accepted atomic.Int64
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msg := newMsg(limitedSource, "0123456789")
+			_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+			assert.NoError(t, err)
+			if msg.GetError() == nil {
+				accepted.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(10), accepted.Load())
+}
+
+func TestReload(t *testing.T) {
+	path := writeBudgets(t, testBudgets)
+	proc := newTestProcessor(t, path, "")
+	assert.Equal(t, 1.0, proc.budgets.ForSource(limitedSource).RequestsPerSecond)
+
+	require.NoError(t, os.WriteFile(path, []byte("sources:\n  \""+limitedSource+"\":\n    requests_per_second: 50\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	require.NoError(t, proc.reload())
+	assert.Equal(t, 50.0, proc.budgets.ForSource(limitedSource).RequestsPerSecond)
+
+	// An invalid file keeps the previous budgets.
+	require.NoError(t, os.WriteFile(path, []byte("default:\n  requests_per_second: -1\n"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(2*time.Minute)))
+	require.Error(t, proc.reload())
+	assert.Equal(t, 50.0, proc.budgets.ForSource(limitedSource).RequestsPerSecond)
+}
+
+func TestLoadBudgetsInvalid(t *testing.T) {
+	budgets, err := LoadBudgets("")
+	require.NoError(t, err)
+	assert.Equal(t, sourceconfig.RateLimit{}, budgets.ForSource(limitedSource))
+
+	invalid := []string{
+		"sources:\n  not-an-address:\n    requests_per_second: 1\n",
+		"default:\n  bytes_per_day: -1\n",
+		"subject: [1]\n",
+	}
+	for _, content := range invalid {
+		_, err := LoadBudgets(writeBudgets(t, content))
+		require.Error(t, err, content)
+	}
+}
+
+func TestProcessBatchSourceConfig(t *testing.T) {
+	t.Cleanup(func() { sourceconfig.Set(&sourceconfig.Registry{}) })
+	proc := newTestProcessor(t, writeBudgets(t, testBudgets), "")
+
+	// The source config registry replaces the budget of the default source.
+	sourceconfig.Set(&sourceconfig.Registry{Sources: map[string]sourceconfig.Overrides{
+		defaultSource: {RateLimit: &sourceconfig.RateLimit{RequestsPerSecond: 1, Burst: 1}},
+	}})
+	msg := newMsg(defaultSource, "{}")
+	_, err := proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.NoError(t, msg.GetError())
+	msg = newMsg(defaultSource, "{}")
+	_, err = proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.ErrorIs(t, msg.GetError(), errRateLimited)
+
+	// Removing the override restores the budgets file.
+	sourceconfig.Set(&sourceconfig.Registry{})
+	msg = newMsg(defaultSource, "{}")
+	_, err = proc.ProcessBatch(context.Background(), service.MessageBatch{msg})
+	require.NoError(t, err)
+	require.NoError(t, msg.GetError())
+}
@@ -0,0 +1,139 @@
+package processors
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/DIMO-Network/cloudevent"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
+)
+
+// CloudEvent extensions set on events whose time was changed or is outside of their time policy.
+const (
+	// ExtensionTimeViolation is "future" or "past" for tagged and clamped events.
+	ExtensionTimeViolation = "dimotimeviolation"
+	// ExtensionOriginalTime is the RFC 3339 time of a clamped event as it was sent.
+	ExtensionOriginalTime = "dimooriginaltime"
+	// ExtensionClockOffset is the clock offset of the producer that the
+	// signals of the event are corrected by, formatted as a Go duration.
+	ExtensionClockOffset = "dimoclockoffset"
+)
+
+// TimeViolation is the bound a timestamp is outside of.
+type TimeViolation string
+
+// Time violations.
+const (
+	TimeViolationFuture TimeViolation = "future"
+	TimeViolationPast   TimeViolation = "past"
+)
+
+var (
+	// ErrFutureTimestamp is returned for timestamps too far in the future.
+	ErrFutureTimestamp = errors.New("timestamp is too far in the future")
+	// ErrPastTimestamp is returned for timestamps too far in the past.
+	ErrPastTimestamp = errors.New("timestamp is too far in the past")
+)
+
+// TimeCheck is the outcome of checking a timestamp against its time policy.
+type TimeCheck struct {
+	// Original is the checked timestamp.
+	Original time.Time
+	// Time is the timestamp to keep. It is the exceeded bound when the action is clamp.
+	Time time.Time
+	// Violation is empty when the timestamp is within the bounds.
+	Violation TimeViolation
+	// Action is applied when there is a violation.
+	Action sourceconfig.TimeAction
+}
+
+// CheckTimestamp checks a timestamp against the time policy of its source and
+// CloudEvent type. The future bound falls back to ALLOWABLE_TIME_SKEW, the
+// past bound to ALLOWABLE_TIME_AGE, and the action to defaultAction.
+func CheckTimestamp(ts time.Time, source, ceType string, defaultAction sourceconfig.TimeAction) TimeCheck {
+	policy := sourceconfig.TimePolicyFor(source, ceType)
+	skew, maxAge, action := allowableTimeSkew, allowableTimeAge, defaultAction
+	if policy.TimeSkew != nil {
+		skew = *policy.TimeSkew
+	}
+	if policy.MaxAge != nil {
+		maxAge = *policy.MaxAge
+	}
+	if policy.TimeAction != nil {
+		action = *policy.TimeAction
+	}
+
+	check := TimeCheck{Original: ts, Time: ts, Action: action}
+	now := time.Now()
+	bound := now.Add(skew)
+	switch {
+	case ts.After(bound):
+		check.Violation = TimeViolationFuture
+	case maxAge > 0 && ts.Before(now.Add(-maxAge)):
+		bound = now.Add(-maxAge)
+		check.Violation = TimeViolationPast
+	default:
+		return check
+	}
+	if action == sourceconfig.TimeActionClamp {
+		check.Time = bound.UTC()
+	}
+	return check
+}
+
+// Err returns ErrFutureTimestamp or ErrPastTimestamp with the checked
+// timestamp when there is a violation, and nil otherwise.
+func (c TimeCheck) Err() error {
+	switch c.Violation {
+	case TimeViolationFuture:
+		return fmt.Errorf("%w: %v", ErrFutureTimestamp, c.Original)
+	case TimeViolationPast:
+		return fmt.Errorf("%w: %v", ErrPastTimestamp, c.Original)
+	default:
+		return nil
+	}
+}
+
+// Apply applies the action of a violation to a CloudEvent header. It returns
+// the error of a rejected timestamp, leaves a logged one unchanged, and
+// otherwise sets the time and records the violation in the header's extensions.
+func (c TimeCheck) Apply(hdr *cloudevent.CloudEventHeader) error {
+	if c.Violation == "" || c.Action == sourceconfig.TimeActionLog {
+		return nil
+	}
+	if c.Action == sourceconfig.TimeActionReject {
+		return c.Err()
+	}
+	if hdr.Extras == nil {
+		hdr.Extras = make(map[string]any)
+	}
+	hdr.Extras[ExtensionTimeViolation] = string(c.Violation)
+	if !c.Time.Equal(c.Original) {
+		SetOriginalTime(hdr, c.Original)
+		hdr.Time = c.Time
+	}
+	return nil
+}
+
+// SetOriginalTime records the time an event was sent with before its time is
+// changed. The first recorded time is kept.
+func SetOriginalTime(hdr *cloudevent.CloudEventHeader, original time.Time) {
+	if hdr.Extras == nil {
+		hdr.Extras = make(map[string]any)
+	}
+	if _, ok := hdr.Extras[ExtensionOriginalTime]; !ok {
+		hdr.Extras[ExtensionOriginalTime] = original.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// ClockOffset returns the clock offset the signals of an event are corrected
+// by, and zero if they are not corrected.
+func ClockOffset(hdr *cloudevent.CloudEventHeader) time.Duration {
+	value, _ := hdr.Extras[ExtensionClockOffset].(string)
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		return 0
+	}
+	return offset
+}
@@ -0,0 +1,296 @@
+// Package sourceconfig holds settings that can be overridden per connection
+// source. The registry is loaded from a YAML file keyed by connection license
+// address and shared by every DIS processor.
+package sourceconfig
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/yaml.v3"
+)
+
+// RateLimit limits the traffic of one source, or of one source and subject
+// pair. It is also the budget format of the rate limiter's budgets file. Zero
+// values are unlimited.
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// Burst is the number of requests allowed at once. Defaults to one second worth of requests.
+	Burst       int   `yaml:"burst"`
+	BytesPerDay int64 `yaml:"bytes_per_day"`
+}
+
+// Validate rejects negative values.
+func (l RateLimit) Validate() error {
+	if l.RequestsPerSecond < 0 || l.Burst < 0 || l.BytesPerDay < 0 {
+		return errors.New("values must not be negative")
+	}
+	return nil
+}
+
+// TimeAction is what happens to a timestamp outside of its time policy bounds.
+type TimeAction string
+
+// Time policy actions.
+const (
+	// TimeActionReject rejects the event or drops the signal.
+	TimeActionReject TimeAction = "reject"
+	// TimeActionClamp moves the timestamp to the nearest bound.
+	TimeActionClamp TimeAction = "clamp"
+	// TimeActionTag keeps the timestamp and marks the event.
+	TimeActionTag TimeAction = "tag"
+	// TimeActionLog keeps the timestamp and leaves the event unmarked.
+	TimeActionLog TimeAction = "log"
+)
+
+// TimePolicy bounds event and signal timestamps relative to the time they are received.
+type TimePolicy struct {
+	// TimeSkew is how far in the future timestamps may be.
+	TimeSkew *time.Duration `yaml:"time_skew"`
+	// MaxAge is how far in the past timestamps may be. Zero is unbounded.
+	MaxAge *time.Duration `yaml:"max_age"`
+	// TimeAction is applied to timestamps outside of the bounds.
+	TimeAction *TimeAction `yaml:"time_action"`
+}
+
+func (p *TimePolicy) apply(o TimePolicy) {
+	if o.TimeSkew != nil {
+		p.TimeSkew = o.TimeSkew
+	}
+	if o.MaxAge != nil {
+		p.MaxAge = o.MaxAge
+	}
+	if o.TimeAction != nil {
+		p.TimeAction = o.TimeAction
+	}
+}
+
+func (p TimePolicy) validate() error {
+	if p.TimeSkew != nil && *p.TimeSkew < 0 {
+		return errors.New("time_skew must not be negative")
+	}
+	if p.MaxAge != nil && *p.MaxAge < 0 {
+		return errors.New("max_age must not be negative")
+	}
+	if a := p.TimeAction; a != nil && *a != TimeActionReject && *a != TimeActionClamp && *a != TimeActionTag && *a != TimeActionLog {
+		return fmt.Errorf("invalid time_action %q: must be reject, clamp, tag or log", *a)
+	}
+	return nil
+}
+
+// Overrides are the settings of one registry entry. Unset fields fall back to
+// the default entry, and then to the global configuration.
+type Overrides struct {
+	// AllowedTypes lists the CloudEvent types the source may send. Empty allows every type.
+	AllowedTypes []string `yaml:"allowed_types"`
+	// TimePolicy bounds the timestamps of every event type.
+	TimePolicy `yaml:",inline"`
+	// Types overrides TimePolicy for individual CloudEvent types.
+	Types map[string]TimePolicy `yaml:"types"`
+	// MaxPayloadBytes is the maximum size of a message body.
+	MaxPayloadBytes *int64 `yaml:"max_payload_bytes"`
+	// ExtractSignals controls whether signals are extracted from status events.
+	ExtractSignals *bool `yaml:"extract_signals"`
+	// ExtractEvents controls whether vehicle events are extracted.
+	ExtractEvents *bool `yaml:"extract_events"`
+	// RateLimit replaces the source's budget in the rate limiter.
+	RateLimit *RateLimit `yaml:"rate_limit"`
+}
+
+// Registry is the content of the registry file.
+type Registry struct {
+	// Default applies to every source without its own value.
+	Default Overrides `yaml:"default"`
+	// Sources overrides Default for individual connection sources.
+	Sources map[string]Overrides `yaml:"sources"`
+}
+
+// Settings are the effective settings of one source.
+type Settings struct {
+	AllowedTypes []string
+	// MaxPayloadBytes is zero when payloads are not limited.
+	MaxPayloadBytes int64
+	ExtractSignals  bool
+	ExtractEvents   bool
+	// RateLimit is nil when the rate limiter's own budgets apply.
+	RateLimit *RateLimit
+}
+
+// AllowsType reports whether the source may send events of the given type.
+func (s Settings) AllowsType(ceType string) bool {
+	return len(s.AllowedTypes) == 0 || slices.Contains(s.AllowedTypes, ceType)
+}
+
+// Load reads and validates a registry file. An empty path yields an empty
+// registry. Source addresses are normalized to their EIP-55 form.
+func Load(path string) (*Registry, error) {
+	registry := &Registry{}
+	if path == "" {
+		return registry, nil
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source config file: %w", err)
+	}
+	if err := yaml.Unmarshal(raw, registry); err != nil {
+		return nil, fmt.Errorf("failed to parse source config file: %w", err)
+	}
+	if err := registry.Default.validate(); err != nil {
+		return nil, fmt.Errorf("default source config: %w", err)
+	}
+	sources := make(map[string]Overrides, len(registry.Sources))
+	for source, overrides := range registry.Sources {
+		if !common.IsHexAddress(source) {
+			return nil, fmt.Errorf("invalid source address in source config file: %s", source)
+		}
+		if err := overrides.validate(); err != nil {
+			return nil, fmt.Errorf("source config for %s: %w", source, err)
+		}
+		sources[common.HexToAddress(source).Hex()] = overrides
+	}
+	registry.Sources = sources
+	return registry, nil
+}
+
+// For returns the effective settings of a source address.
+func (r *Registry) For(source string) Settings {
+	settings := Settings{ExtractSignals: true, ExtractEvents: true}
+	settings.apply(r.Default)
+	if common.IsHexAddress(source) {
+		if overrides, ok := r.Sources[common.HexToAddress(source).Hex()]; ok {
+			settings.apply(overrides)
+		}
+	}
+	return settings
+}
+
+// TimePolicy returns the time policy of events of a CloudEvent type from a
+// source address. Type entries override the entry they belong to, and source
+// entries override the default entry. Unset fields are nil when the global
+// configuration applies.
+func (r *Registry) TimePolicy(source, ceType string) TimePolicy {
+	var policy TimePolicy
+	policy.apply(r.Default.TimePolicy)
+	policy.apply(r.Default.Types[ceType])
+	if common.IsHexAddress(source) {
+		if overrides, ok := r.Sources[common.HexToAddress(source).Hex()]; ok {
+			policy.apply(overrides.TimePolicy)
+			policy.apply(overrides.Types[ceType])
+		}
+	}
+	return policy
+}
+
+func (s *Settings) apply(o Overrides) {
+	if o.AllowedTypes != nil {
+		s.AllowedTypes = o.AllowedTypes
+	}
+	if o.MaxPayloadBytes != nil {
+		s.MaxPayloadBytes = *o.MaxPayloadBytes
+	}
+	if o.ExtractSignals != nil {
+		s.ExtractSignals = *o.ExtractSignals
+	}
+	if o.ExtractEvents != nil {
+		s.ExtractEvents = *o.ExtractEvents
+	}
+	if o.RateLimit != nil {
+		s.RateLimit = o.RateLimit
+	}
+}
+
+func (o Overrides) validate() error {
+	if err := o.TimePolicy.validate(); err != nil {
+		return err
+	}
+	for ceType, policy := range o.Types {
+		if err := policy.validate(); err != nil {
+			return fmt.Errorf("type %s: %w", ceType, err)
+		}
+	}
+	if o.MaxPayloadBytes != nil && *o.MaxPayloadBytes < 0 {
+		return errors.New("max_payload_bytes must not be negative")
+	}
+	if o.RateLimit != nil {
+		if err := o.RateLimit.Validate(); err != nil {
+			return fmt.Errorf("rate_limit: %w", err)
+		}
+	}
+	return nil
+}
+
+var current atomic.Pointer[Registry]
+
+func shared() *Registry {
+	if registry := current.Load(); registry != nil {
+		return registry
+	}
+	return &Registry{}
+}
+
+// For returns the effective settings of a source from the shared registry.
+func For(source string) Settings {
+	return shared().For(source)
+}
+
+// TimePolicyFor returns the time policy of a source and CloudEvent type from the shared registry.
+func TimePolicyFor(source, ceType string) TimePolicy {
+	return shared().TimePolicy(source, ceType)
+}
+
+// Set replaces the shared registry.
+func Set(registry *Registry) {
+	current.Store(registry)
+}
+
+// Watch loads the registry file into the shared registry and reloads it
+// whenever its modification time changes. Reload errors are passed to onError
+// and keep the previous registry. Reloading stops when ctx is done.
+func Watch(ctx context.Context, path string, interval time.Duration, onError func(error)) error {
+	registry, err := Load(path)
+	if err != nil {
+		return err
+	}
+	Set(registry)
+	if path == "" || interval <= 0 {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read source config file: %w", err)
+	}
+	go func() {
+		modTime := info.ModTime()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				onError(fmt.Errorf("failed to read source config file: %w", err))
+				continue
+			}
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			registry, err := Load(path)
+			if err != nil {
+				onError(err)
+				continue
+			}
+			modTime = info.ModTime()
+			Set(registry)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,191 @@
+package sourceconfig
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	configuredSource = "0x07B584f6a7125491C991ca2a45ab9e641B1CeE1b"
+	otherSource      = "0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8"
+)
+
+const testRegistry = `
+default:
+  max_payload_bytes: 100
+  extract_events: false
+sources:
+  "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b":
+    allowed_types: [dimo.status]
+    time_skew: 10m
+    extract_signals: false
+    extract_events: true
+    rate_limit:
+      requests_per_second: 5
+`
+
+func writeRegistry(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sources.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestFor(t *testing.T) {
+	registry, err := Load(writeRegistry(t, testRegistry))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		source   string
+		expected Settings
+	}{
+		{
+			name:   "configured source",
+			source: configuredSource,
+			expected: Settings{
+				AllowedTypes:    []string{"dimo.status"},
+				MaxPayloadBytes: 100,
+				ExtractSignals:  false,
+				ExtractEvents:   true,
+				RateLimit:       &RateLimit{RequestsPerSecond: 5},
+			},
+		},
+		{
+			name:   "lowercase source",
+			source: "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b",
+			expected: Settings{
+				AllowedTypes:    []string{"dimo.status"},
+				MaxPayloadBytes: 100,
+				ExtractSignals:  false,
+				ExtractEvents:   true,
+				RateLimit:       &RateLimit{RequestsPerSecond: 5},
+			},
+		},
+		{
+			name:     "default",
+			source:   otherSource,
+			expected: Settings{MaxPayloadBytes: 100, ExtractSignals: true, ExtractEvents: false},
+		},
+		{
+			name:     "not an address",
+			source:   "unknown",
+			expected: Settings{MaxPayloadBytes: 100, ExtractSignals: true, ExtractEvents: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, registry.For(tt.source))
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "invalid yaml", content: "sources: ["},
+		{name: "invalid address", content: "sources:\n  not-an-address: {}\n"},
+		{name: "negative skew", content: "default:\n  time_skew: -1m\n"},
+		{name: "negative max age", content: "default:\n  types:\n    dimo.status:\n      max_age: -1h\n"},
+		{name: "invalid time action", content: "default:\n  time_action: drop\n"},
+		{name: "negative payload size", content: "sources:\n  \"" + configuredSource + "\":\n    max_payload_bytes: -1\n"},
+		{name: "negative rate limit", content: "default:\n  rate_limit:\n    burst: -1\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(writeRegistry(t, tt.content))
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestTimePolicy(t *testing.T) {
+	registry, err := Load(writeRegistry(t, `
+default:
+  max_age: 720h
+  time_action: reject
+  types:
+    dimo.status:
+      time_action: clamp
+sources:
+  "0x07b584f6a7125491c991ca2a45ab9e641b1cee1b":
+    time_skew: 10m
+    time_action: tag
+    types:
+      dimo.attestation:
+        max_age: 0s
+`))
+	require.NoError(t, err)
+	duration := func(d time.Duration) *time.Duration { return &d }
+	action := func(a TimeAction) *TimeAction { return &a }
+
+	tests := []struct {
+		name     string
+		source   string
+		ceType   string
+		expected TimePolicy
+	}{
+		{
+			name:     "default",
+			source:   otherSource,
+			ceType:   "dimo.fingerprint",
+			expected: TimePolicy{MaxAge: duration(720 * time.Hour), TimeAction: action(TimeActionReject)},
+		},
+		{
+			name:     "default type",
+			source:   otherSource,
+			ceType:   "dimo.status",
+			expected: TimePolicy{MaxAge: duration(720 * time.Hour), TimeAction: action(TimeActionClamp)},
+		},
+		{
+			name:     "source overrides default type",
+			source:   configuredSource,
+			ceType:   "dimo.status",
+			expected: TimePolicy{TimeSkew: duration(10 * time.Minute), MaxAge: duration(720 * time.Hour), TimeAction: action(TimeActionTag)},
+		},
+		{
+			name:     "source type",
+			source:   configuredSource,
+			ceType:   "dimo.attestation",
+			expected: TimePolicy{TimeSkew: duration(10 * time.Minute), MaxAge: duration(0), TimeAction: action(TimeActionTag)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, registry.TimePolicy(tt.source, tt.ceType))
+		})
+	}
+}
+
+func TestAllowsType(t *testing.T) {
+	assert.True(t, Settings{}.AllowsType("dimo.status"))
+	assert.True(t, Settings{AllowedTypes: []string{"dimo.status"}}.AllowsType("dimo.status"))
+	assert.False(t, Settings{AllowedTypes: []string{"dimo.status"}}.AllowsType("dimo.events"))
+}
+
+func TestWatch(t *testing.T) {
+	path := writeRegistry(t, testRegistry)
+	// Stop watching before the temporary directory is removed.
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(func() {
+		cancel()
+		Set(&Registry{})
+	})
+	require.NoError(t, Watch(ctx, path, 10*time.Millisecond, func(error) {}))
+	assert.False(t, For(configuredSource).ExtractSignals)
+
+	updated := "sources:\n  \"" + configuredSource + "\":\n    extract_signals: true\n"
+	require.NoError(t, os.WriteFile(path, []byte(updated), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	assert.Eventually(t, func() bool {
+		return For(configuredSource).ExtractSignals && For(configuredSource).MaxPayloadBytes == 0
+	}, time.Second, 10*time.Millisecond)
+}
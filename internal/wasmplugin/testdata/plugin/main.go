@@ -0,0 +1,83 @@
+// Command plugin is a test plugin. Build it with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o plugin.wasm .
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"unsafe"
+)
+
+// pinned keeps memory handed to the host alive until the instance is closed.
+var pinned [][]byte
+
+func main() {}
+
+//go:wasmexport alloc
+func alloc(size uint32) uint32 {
+	buf := make([]byte, size+1)
+	pinned = append(pinned, buf)
+	return uint32(uintptr(unsafe.Pointer(&buf[0])))
+}
+
+//go:wasmexport cloudevent_convert
+func cloudEventConvert(ptr, size uint32) uint64 {
+	var payload struct {
+		ID      string  `json:"id"`
+		Vehicle int     `json:"vehicle"`
+		Time    string  `json:"time"`
+		Mode    string  `json:"mode"`
+		Speed   float64 `json:"speed"`
+	}
+	if err := json.Unmarshal(input(ptr, size), &payload); err != nil {
+		return output(map[string]any{"error": err.Error()})
+	}
+	switch payload.Mode {
+	case "fail":
+		return output(map[string]any{"error": "bad payload"})
+	case "loop":
+		for {
+		}
+	case "grow":
+		for {
+			pinned = append(pinned, make([]byte, 1<<20))
+		}
+	}
+	return output(map[string]any{
+		"headers": []map[string]any{{
+			"id":       payload.ID,
+			"type":     "dimo.status",
+			"producer": "did:erc721:80002:0x325b45949C833986bC98e98a49F3CA5C5c4643B5:1",
+			"subject":  "did:erc721:80002:0x45fbCD3ef7361d156e8b16F5538AE36DEdf61Da8:" + strconv.Itoa(payload.Vehicle),
+			"time":     payload.Time,
+		}},
+		"data": map[string]any{"speed": payload.Speed, "time": payload.Time},
+	})
+}
+
+//go:wasmexport signal_convert
+func signalConvert(ptr, size uint32) uint64 {
+	var event struct {
+		Data struct {
+			Speed float64 `json:"speed"`
+			Time  string  `json:"time"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(input(ptr, size), &event); err != nil {
+		return output(map[string]any{"error": err.Error()})
+	}
+	return output(map[string]any{
+		"signals": []map[string]any{{"name": "speed", "timestamp": event.Data.Time, "value": event.Data.Speed}},
+	})
+}
+
+func input(ptr, size uint32) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(uintptr(ptr))), size)
+}
+
+func output(v any) uint64 {
+	out, _ := json.Marshal(v)
+	pinned = append(pinned, out)
+	return uint64(uintptr(unsafe.Pointer(&out[0])))<<32 | uint64(len(out))
+}
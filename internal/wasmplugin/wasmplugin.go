@@ -0,0 +1,175 @@
+// Package wasmplugin hosts sandboxed WebAssembly modules that decode provider
+// payloads. Plugins run in a pure Go runtime without file system, network or
+// clock access, with their memory and execution time limited.
+//
+// A plugin exports its linear memory, an allocator and one or more conversion
+// functions:
+//
+//	alloc(size u32) u32
+//	cloudevent_convert(ptr u32, len u32) u64
+//	signal_convert(ptr u32, len u32) u64
+//	event_convert(ptr u32, len u32) u64
+//
+// The host writes the input to memory returned by alloc and calls the
+// conversion function, which returns the location of its JSON output packed as
+// ptr<<32 | len. cloudevent_convert receives the raw payload, and the others a
+// JSON CloudEvent. Any output may be {"error": "..."} to report a failure.
+package wasmplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// Exported functions of a plugin.
+const (
+	FuncCloudEventConvert = "cloudevent_convert"
+	FuncSignalConvert     = "signal_convert"
+	FuncEventConvert      = "event_convert"
+
+	funcAlloc = "alloc"
+	pageSize  = 64 * 1024
+)
+
+var errNoOutput = errors.New("plugin returned no output")
+
+// compilationCache lets every processor thread load the same plugin without compiling it again.
+var compilationCache = wazero.NewCompilationCache()
+
+// Limits bound the resources of a single plugin call.
+type Limits struct {
+	// MemoryBytes is the maximum linear memory of a plugin instance.
+	MemoryBytes int64
+	// Timeout is the maximum duration of a call, including instantiation.
+	Timeout time.Duration
+}
+
+// Plugin is a compiled WebAssembly plugin. Every call runs in a fresh instance,
+// so a plugin cannot keep state between payloads.
+type Plugin struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	timeout  time.Duration
+	exports  map[string]struct{}
+}
+
+// Load compiles the plugin at path and checks its exports.
+func Load(ctx context.Context, path string, limits Limits) (*Plugin, error) {
+	if limits.MemoryBytes < pageSize {
+		return nil, fmt.Errorf("memory limit must be at least %d bytes", pageSize)
+	}
+	if limits.Timeout <= 0 {
+		return nil, errors.New("timeout must be positive")
+	}
+	wasm, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plugin: %w", err)
+	}
+	cfg := wazero.NewRuntimeConfig().
+		WithMemoryLimitPages(uint32(min(limits.MemoryBytes/pageSize, 65536))).
+		WithCloseOnContextDone(true).
+		WithCompilationCache(compilationCache)
+	runtime := wazero.NewRuntimeWithConfig(ctx, cfg)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, runtime); err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate wasi: %w", err)
+	}
+	compiled, err := runtime.CompileModule(ctx, wasm)
+	if err != nil {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("failed to compile plugin %s: %w", path, err)
+	}
+	plugin := &Plugin{runtime: runtime, compiled: compiled, timeout: limits.Timeout, exports: map[string]struct{}{}}
+	for name := range compiled.ExportedFunctions() {
+		plugin.exports[name] = struct{}{}
+	}
+	if !plugin.Exports(funcAlloc) {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s does not export %s", path, funcAlloc)
+	}
+	if _, ok := compiled.ExportedMemories()["memory"]; !ok {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s does not export memory", path)
+	}
+	if !plugin.Exports(FuncCloudEventConvert) && !plugin.Exports(FuncSignalConvert) && !plugin.Exports(FuncEventConvert) {
+		_ = runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s exports no conversion function", path)
+	}
+	return plugin, nil
+}
+
+// Exports reports whether the plugin exports the named function.
+func (p *Plugin) Exports(name string) bool {
+	_, ok := p.exports[name]
+	return ok
+}
+
+// Close releases the plugin.
+func (p *Plugin) Close(ctx context.Context) error {
+	return p.runtime.Close(ctx)
+}
+
+// Call runs an exported conversion function on input and unmarshals its output into out.
+func (p *Plugin) Call(ctx context.Context, name string, input []byte, out any) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	mod, err := p.runtime.InstantiateModule(ctx, p.compiled, wazero.NewModuleConfig().
+		WithName("").
+		WithStartFunctions("_initialize"))
+	if err != nil {
+		return fmt.Errorf("failed to instantiate plugin: %w", err)
+	}
+	defer func() { _ = mod.Close(context.WithoutCancel(ctx)) }()
+
+	output, err := call(ctx, mod, name, input)
+	if err != nil {
+		return err
+	}
+	var pluginErr struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(output, &pluginErr); err == nil && pluginErr.Error != "" {
+		return fmt.Errorf("plugin %s failed: %s", name, pluginErr.Error)
+	}
+	if err := json.Unmarshal(output, out); err != nil {
+		return fmt.Errorf("failed to unmarshal output of %s: %w", name, err)
+	}
+	return nil
+}
+
+func call(ctx context.Context, mod api.Module, name string, input []byte) ([]byte, error) {
+	fn := mod.ExportedFunction(name)
+	if fn == nil {
+		return nil, fmt.Errorf("plugin does not export %s", name)
+	}
+	res, err := mod.ExportedFunction(funcAlloc).Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate plugin memory: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !mod.Memory().Write(ptr, input) {
+		return nil, errors.New("plugin allocated memory out of range")
+	}
+	res, err = fn.Call(ctx, uint64(ptr), uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", name, err)
+	}
+	outPtr, outLen := uint32(res[0]>>32), uint32(res[0])
+	if outLen == 0 {
+		return nil, errNoOutput
+	}
+	output, ok := mod.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, errors.New("plugin output out of range")
+	}
+	// The view is only valid until the instance is closed.
+	return append([]byte(nil), output...), nil
+}
@@ -0,0 +1,101 @@
+package wasmplugin
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testLimits = Limits{MemoryBytes: 64 << 20, Timeout: 5 * time.Second}
+
+// buildTestPlugin compiles testdata/plugin to WebAssembly.
+func buildTestPlugin(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plugin.wasm")
+	cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", path, ".")
+	cmd.Dir = filepath.Join("testdata", "plugin")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+	return path
+}
+
+func TestCall(t *testing.T) {
+	plugin, err := Load(context.Background(), buildTestPlugin(t), testLimits)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = plugin.Close(context.Background()) })
+
+	assert.True(t, plugin.Exports(FuncCloudEventConvert))
+	assert.True(t, plugin.Exports(FuncSignalConvert))
+	assert.False(t, plugin.Exports(FuncEventConvert))
+
+	var out struct {
+		Headers []struct {
+			ID   string `json:"id"`
+			Type string `json:"type"`
+		} `json:"headers"`
+	}
+	err = plugin.Call(context.Background(), FuncCloudEventConvert, []byte(`{"id":"1","vehicle":5}`), &out)
+	require.NoError(t, err)
+	require.Len(t, out.Headers, 1)
+	assert.Equal(t, "1", out.Headers[0].ID)
+	assert.Equal(t, "dimo.status", out.Headers[0].Type)
+
+	tests := []struct {
+		name     string
+		function string
+		input    string
+		contains string
+	}{
+		{name: "plugin error", function: FuncCloudEventConvert, input: `{"mode":"fail"}`, contains: "bad payload"},
+		{name: "missing export", function: FuncEventConvert, input: `{}`, contains: "does not export"},
+		{name: "memory limit", function: FuncCloudEventConvert, input: `{"mode":"grow"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := plugin.Call(context.Background(), tt.function, []byte(tt.input), &out)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.contains)
+		})
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	plugin, err := Load(context.Background(), buildTestPlugin(t), Limits{MemoryBytes: testLimits.MemoryBytes, Timeout: 500 * time.Millisecond})
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = plugin.Close(context.Background()) })
+
+	start := time.Now()
+	var out any
+	err = plugin.Call(context.Background(), FuncCloudEventConvert, []byte(`{"mode":"loop"}`), &out)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestLoadErrors(t *testing.T) {
+	notWasm := filepath.Join(t.TempDir(), "plugin.wasm")
+	require.NoError(t, os.WriteFile(notWasm, []byte("not wasm"), 0o600))
+
+	tests := []struct {
+		name   string
+		path   string
+		limits Limits
+	}{
+		{name: "missing file", path: filepath.Join(t.TempDir(), "missing.wasm"), limits: testLimits},
+		{name: "not wasm", path: notWasm, limits: testLimits},
+		{name: "memory limit too small", path: notWasm, limits: Limits{MemoryBytes: 1, Timeout: time.Second}},
+		{name: "no timeout", path: notWasm, limits: Limits{MemoryBytes: testLimits.MemoryBytes}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(context.Background(), tt.path, tt.limits)
+			require.Error(t, err)
+		})
+	}
+}
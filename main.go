@@ -11,6 +11,7 @@ import (
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/DIMO-Network/clickhouse-infra/pkg/migrate"
 	indexmigrations "github.com/DIMO-Network/cloudevent/clickhouse/migrations"
+	"github.com/DIMO-Network/dis/internal/sourceconfig"
 	sigmigrations "github.com/DIMO-Network/model-garage/pkg/migrations"
 	"github.com/redpanda-data/benthos/v4/public/service"
 
@@ -30,19 +31,29 @@ import (
 	_ "github.com/redpanda-data/connect/v4/public/components/prometheus"
 
 	// Add our custom plugin packages here.
+	_ "github.com/DIMO-Network/dis/internal/processors/asyncticket"
+	_ "github.com/DIMO-Network/dis/internal/processors/clickhouseinsert"
 	_ "github.com/DIMO-Network/dis/internal/processors/cloudeventconvert"
 	_ "github.com/DIMO-Network/dis/internal/processors/cloudeventsplit"
+	_ "github.com/DIMO-Network/dis/internal/processors/decompress"
 	_ "github.com/DIMO-Network/dis/internal/processors/eventconvert"
 	_ "github.com/DIMO-Network/dis/internal/processors/eventstoslice"
 	_ "github.com/DIMO-Network/dis/internal/processors/fingerprintvalidate"
+	_ "github.com/DIMO-Network/dis/internal/processors/hmacverify"
 	_ "github.com/DIMO-Network/dis/internal/processors/httpinputserver"
+	_ "github.com/DIMO-Network/dis/internal/processors/mqttserver"
+	_ "github.com/DIMO-Network/dis/internal/processors/prioritylane"
 	_ "github.com/DIMO-Network/dis/internal/processors/rawparquet"
 	_ "github.com/DIMO-Network/dis/internal/processors/signalconvert"
 	_ "github.com/DIMO-Network/dis/internal/processors/signalstoslice"
+	_ "github.com/DIMO-Network/dis/internal/processors/sourceratelimit"
 )
 
 func main() {
-	if shouldRunMigrations() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if runsStreams() {
 		host := envOrDefault("CLICKHOUSE_HOST", "localhost")
 		port := envOrDefault("CLICKHOUSE_PORT", "9440")
 		user := envOrDefault("CLICKHOUSE_USER", "default")
@@ -56,15 +67,34 @@ func main() {
 
 		runMigration("signal", dimoDSN, sigmigrations.BaseFS)
 		runMigration("file_index", indexDSN, indexmigrations.BaseFS)
+
+		loadSourceConfig(ctx)
 	}
 
-	service.RunCLI(context.Background())
+	service.RunCLI(ctx)
+}
+
+// loadSourceConfig loads the per source config registry and keeps it up to
+// date until ctx is cancelled.
+func loadSourceConfig(ctx context.Context) {
+	path := os.Getenv("SOURCE_CONFIG_FILE")
+	interval, err := time.ParseDuration(envOrDefault("SOURCE_CONFIG_RELOAD_INTERVAL", "1m"))
+	if err != nil {
+		log.Fatalf("Failed to parse SOURCE_CONFIG_RELOAD_INTERVAL: %v", err)
+	}
+	onError := func(err error) {
+		log.Printf("Failed to reload source config, keeping previous config: %v", err)
+	}
+	if err := sourceconfig.Watch(ctx, path, interval, onError); err != nil {
+		log.Fatalf("Failed to load source config: %v", err)
+	}
 }
 
-// shouldRunMigrations returns true only when the binary is running as a server
-// (no subcommand or explicit "run" command). Skips migrations for CLI commands
-// like "lint", "test", "list", etc. that don't need a database.
-func shouldRunMigrations() bool {
+// runsStreams returns true only when the binary is running as a server (no
+// subcommand or explicit "run" command). Migrations and the source config are
+// skipped for CLI commands like "lint", "test", "list", etc. that don't need
+// a database or a running pipeline.
+func runsStreams() bool {
 	if len(os.Args) < 2 {
 		return true
 	}
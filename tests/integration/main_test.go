@@ -65,8 +65,8 @@ var (
 	rpcServer  *httptest.Server
 	jwksServer *httptest.Server
 
-	// Source address used for mTLS CN (simulates a registered device source)
-	testSourceAddress = "0xTestSourceAddr1234567890abcdef1234"
+	// Source address used for mTLS CN (simulates a registered device source). Must be EIP-55 checksummed.
+	testSourceAddress = "0x5E57000000000000000000000000000000001234"
 
 	// Ruptela source address — must match modules.RuptelaSource
 	ruptelaSourceAddress = "0xF26421509Efe92861a587482100c6d728aBf1CD0"